@@ -1,22 +1,33 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 
 	"github.com/ledgerhq/bitcoin-keychain/config"
 	controllers "github.com/ledgerhq/bitcoin-keychain/grpc"
 	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
 	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keycache"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/oracle"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-func serve(grpcAddr string, storeType string, redisOpts *redis.Options) {
+func serve(
+	grpcAddr string, httpAddr string, metricsAddr string, storeType string,
+	coinService string, redisOpts *redis.Options, addressSearchLimit uint32,
+	keyCacheSize int, oracleURL string, oracleTimeout time.Duration,
+) {
 	conn, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -24,23 +35,47 @@ func serve(grpcAddr string, storeType string, redisOpts *redis.Options) {
 		}).Fatal("cannot listen to address")
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		metrics.UnaryServerInterceptor,
+		controllers.UnaryErrorInterceptor,
+	))
 
-	keychainController, err := controllers.NewKeychainController(storeType, redisOpts)
+	client, err := controllers.NewCoinServiceClient(coinService)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to init coin service client")
+	}
+
+	keychainController, err := controllers.NewKeychainController(
+		storeType, client, redisOpts, addressSearchLimit, keyCacheSize)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Fatal("failed to init controller")
 	}
 
+	if oracleURL != "" {
+		controllers.SetAddressUsageOracle(oracle.NewHTTP(oracleURL, oracleTimeout))
+	}
+
 	pb.RegisterKeychainServiceServer(s, keychainController)
 
-	healthCheckerController := controllers.NewHealthChecker()
+	healthCheckerController := controllers.NewHealthChecker(
+		keychainController.Store(), client)
 
 	grpc_health_v1.RegisterHealthServer(s, healthCheckerController)
 
 	reflection.Register(s)
 
+	if httpAddr != "" {
+		go serveGateway(httpAddr, grpcAddr)
+	}
+
+	if metricsAddr != "" {
+		go serveMetrics(metricsAddr)
+	}
+
 	if err := s.Serve(conn); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -48,6 +83,70 @@ func serve(grpcAddr string, storeType string, redisOpts *redis.Options) {
 	}
 }
 
+// serveGateway serves the REST API of the keychain on httpAddr, by
+// forwarding requests to the gRPC server on grpcAddr.
+func serveGateway(httpAddr string, grpcAddr string) {
+	gateway, err := controllers.NewGateway(
+		context.Background(), grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to init gateway")
+	}
+
+	log.WithFields(log.Fields{
+		"addr":    httpAddr,
+		"openapi": controllers.OpenAPIPath,
+	}).Info("serving REST gateway")
+
+	if err := http.ListenAndServe(httpAddr, gateway); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to serve gateway")
+	}
+}
+
+// serveMetrics serves the Prometheus metrics of the keychain on metricsAddr.
+func serveMetrics(metricsAddr string) {
+	mux := http.NewServeMux()
+	mux.Handle(metrics.Path, metrics.Handler())
+
+	log.WithFields(log.Fields{
+		"addr": metricsAddr,
+		"path": metrics.Path,
+	}).Info("serving metrics")
+
+	if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to serve metrics")
+	}
+}
+
+// migrateRedisSchema converts the keychains of the redis store from the
+// legacy format, before serving requests.
+func migrateRedisSchema(redisOpts *redis.Options) {
+	// Migration does not derive any address, so no CoinService is needed.
+	store, err := keystore.NewRedisKeystore(redisOpts, nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to init redis store for migration")
+	}
+
+	count, err := store.MigrateLegacyKeychains(context.Background())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":    err,
+			"migrated": count,
+		}).Fatal("failed to migrate redis schema")
+	}
+
+	log.WithFields(log.Fields{
+		"migrated": count,
+	}).Info("migrated redis schema")
+}
+
 func main() {
 	configProvider := config.LoadProvider("")
 
@@ -69,6 +168,18 @@ func main() {
 
 	grpcAddr := fmt.Sprintf("%s:%d", host, port)
 
+	// The REST gateway is only served if an HTTP port is configured.
+	var httpAddr string
+	if val := configProvider.GetInt32("http_port"); val != 0 {
+		httpAddr = fmt.Sprintf("%s:%d", host, val)
+	}
+
+	// Metrics are only served if a metrics port is configured.
+	var metricsAddr string
+	if val := configProvider.GetInt32("metrics_port"); val != 0 {
+		metricsAddr = fmt.Sprintf("%s:%d", host, val)
+	}
+
 	redisHost = configProvider.GetString("redis_host")
 
 	if val := configProvider.GetInt32("redis_port"); val != 0 {
@@ -91,10 +202,37 @@ func main() {
 
 	storeType := configProvider.GetString("store_type")
 
-	serve(grpcAddr, storeType, &redis.Options{
+	// Either "remote" (default) or "native"
+	coinService := configProvider.GetString("coin_service")
+
+	// Unknown addresses marked as used are only searched beyond the
+	// observable range of keychains if a limit is configured.
+	addressSearchLimit := uint32(configProvider.GetInt32("address_search_limit"))
+
+	// Derived keys are cached unless the cache size is set to 0.
+	keyCacheSize := keycache.DefaultSize
+	if configProvider.IsSet("key_cache_size") {
+		keyCacheSize = configProvider.GetInt("key_cache_size")
+	}
+
+	// Keychains can only be discovered if an address usage oracle is
+	// configured.
+	oracleURL := configProvider.GetString("address_usage_oracle_url")
+	oracleTimeout := configProvider.GetDuration("address_usage_oracle_timeout")
+
+	redisOpts := &redis.Options{
 		Addr:      redisAddr,
 		Password:  redisPassword, // set password
 		DB:        redisDB,       // use default DB
 		TLSConfig: tlsConfig,
-	})
+	}
+
+	// Keychains of the redis store are converted on their first update,
+	// unless all of them are migrated at startup.
+	if storeType == "redis" && configProvider.GetBool("redis_migrate_schema") {
+		migrateRedisSchema(redisOpts)
+	}
+
+	serve(grpcAddr, httpAddr, metricsAddr, storeType, coinService, redisOpts,
+		addressSearchLimit, keyCacheSize, oracleURL, oracleTimeout)
 }
@@ -23,6 +23,14 @@ func KeychainInfo(value keystore.KeychainInfo) (*pb.KeychainInfo, error) {
 		scheme = pb.Scheme_SCHEME_BIP49
 	case keystore.BIP84:
 		scheme = pb.Scheme_SCHEME_BIP84
+	case keystore.BIP86:
+		scheme = pb.Scheme_SCHEME_BIP86
+	case keystore.MultisigP2SH:
+		scheme = pb.Scheme_SCHEME_MULTISIG_P2SH
+	case keystore.MultisigP2SHP2WSH:
+		scheme = pb.Scheme_SCHEME_MULTISIG_P2SH_P2WSH
+	case keystore.MultisigP2WSH:
+		scheme = pb.Scheme_SCHEME_MULTISIG_P2WSH
 	default:
 		scheme = pb.Scheme_SCHEME_UNSPECIFIED
 	}
@@ -38,16 +46,31 @@ func KeychainInfo(value keystore.KeychainInfo) (*pb.KeychainInfo, error) {
 			ErrInvalidKeychainID, fmt.Sprintf("%v", value.ID))
 	}
 
-	return &pb.KeychainInfo{
+	info := &pb.KeychainInfo{
 		KeychainId:              id,
 		ExternalDescriptor:      value.ExternalDescriptor,
 		InternalDescriptor:      value.InternalDescriptor,
+		MultipathDescriptor:     value.MultipathDescriptor,
 		ExtendedPublicKey:       value.ExtendedPublicKey,
 		Slip32ExtendedPublicKey: value.SLIP32ExtendedPublicKey,
 		LookaheadSize:           value.LookaheadSize,
 		Scheme:                  scheme,
 		ChainParams:             chainParams,
-	}, nil
+		ExtendedPublicKeys:      value.ExtendedPublicKeys,
+		Threshold:               value.Threshold,
+		PathTemplate:            string(value.PathTemplate),
+	}
+
+	if value.AddressFormat == keystore.LegacyAddressFormat {
+		info.AddressFormat = pb.AddressFormat_ADDRESS_FORMAT_LEGACY
+	}
+
+	if origin := value.KeyOrigin; origin != nil {
+		info.MasterFingerprint = origin.MasterFingerprint[:]
+		info.AccountPath = keystore.FormatAccountPath(origin.AccountPath)
+	}
+
+	return info, nil
 }
 
 // Network is an adapter function to convert a gRPC pb.BitcoinNetwork
@@ -58,6 +81,10 @@ func Network(params *pb.ChainParams) (chaincfg.Network, error) {
 		return chaincfg.BitcoinMainnet, nil
 	case pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3:
 		return chaincfg.BitcoinTestnet3, nil
+	case pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET4:
+		return chaincfg.BitcoinTestnet4, nil
+	case pb.BitcoinNetwork_BITCOIN_NETWORK_SIGNET:
+		return chaincfg.BitcoinSignet, nil
 	case pb.BitcoinNetwork_BITCOIN_NETWORK_REGTEST:
 		return chaincfg.BitcoinRegtest, nil
 	}
@@ -65,6 +92,17 @@ func Network(params *pb.ChainParams) (chaincfg.Network, error) {
 	switch net := params.GetLitecoinNetwork(); net {
 	case pb.LitecoinNetwork_LITECOIN_NETWORK_MAINNET:
 		return chaincfg.LitecoinMainnet, nil
+	case pb.LitecoinNetwork_LITECOIN_NETWORK_TESTNET:
+		return chaincfg.LitecoinTestnet, nil
+	case pb.LitecoinNetwork_LITECOIN_NETWORK_REGTEST:
+		return chaincfg.LitecoinRegtest, nil
+	}
+
+	switch net := params.GetBitcoinCashNetwork(); net {
+	case pb.BitcoinCashNetwork_BITCOIN_CASH_NETWORK_MAINNET:
+		return chaincfg.BitcoinCashMainnet, nil
+	case pb.BitcoinCashNetwork_BITCOIN_CASH_NETWORK_TESTNET:
+		return chaincfg.BitcoinCashTestnet, nil
 	default:
 		return "", errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
 	}
@@ -86,6 +124,18 @@ func ChainParams(net chaincfg.Network) (*pb.ChainParams, error) {
 				BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3,
 			},
 		}, nil
+	case chaincfg.BitcoinTestnet4:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_BitcoinNetwork{
+				BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET4,
+			},
+		}, nil
+	case chaincfg.BitcoinSignet:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_BitcoinNetwork{
+				BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_SIGNET,
+			},
+		}, nil
 	case chaincfg.BitcoinRegtest:
 		return &pb.ChainParams{
 			Network: &pb.ChainParams_BitcoinNetwork{
@@ -98,20 +148,66 @@ func ChainParams(net chaincfg.Network) (*pb.ChainParams, error) {
 				LitecoinNetwork: pb.LitecoinNetwork_LITECOIN_NETWORK_MAINNET,
 			},
 		}, nil
+	case chaincfg.LitecoinTestnet:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_LitecoinNetwork{
+				LitecoinNetwork: pb.LitecoinNetwork_LITECOIN_NETWORK_TESTNET,
+			},
+		}, nil
+	case chaincfg.LitecoinRegtest:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_LitecoinNetwork{
+				LitecoinNetwork: pb.LitecoinNetwork_LITECOIN_NETWORK_REGTEST,
+			},
+		}, nil
+	case chaincfg.BitcoinCashMainnet:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_BitcoinCashNetwork{
+				BitcoinCashNetwork: pb.BitcoinCashNetwork_BITCOIN_CASH_NETWORK_MAINNET,
+			},
+		}, nil
+	case chaincfg.BitcoinCashTestnet:
+		return &pb.ChainParams{
+			Network: &pb.ChainParams_BitcoinCashNetwork{
+				BitcoinCashNetwork: pb.BitcoinCashNetwork_BITCOIN_CASH_NETWORK_TESTNET,
+			},
+		}, nil
 	default:
 		return nil, errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
 	}
 }
 
 // DerivationPath is an adapter function to convert a derivation path (slice)
-// to a keystore.DerivationPath instance.
-func DerivationPath(path []uint32) (keystore.DerivationPath, error) {
-	if len(path) != 2 {
-		return keystore.DerivationPath{}, errors.Wrap(
-			ErrInvalidDerivationPath, fmt.Sprintf("%v", path))
+// relative to the extended public key of a keychain, to a
+// keystore.DerivationPath instance on a chain of its path template.
+func DerivationPath(template keystore.PathTemplate, path []uint32) (keystore.DerivationPath, error) {
+	derivation, err := template.DerivationPath(path)
+	if err != nil {
+		return keystore.DerivationPath{}, errors.Wrapf(
+			ErrInvalidDerivationPath, "%v is not on a chain of path template %s",
+			path, template)
 	}
 
-	return keystore.DerivationPath{path[0], path[1]}, nil
+	return derivation, nil
+}
+
+// PathTemplate is an adapter function to convert the optional path template
+// of a pb.CreateKeychainRequest to a keystore.PathTemplate instance.
+func PathTemplate(request *pb.CreateKeychainRequest) (keystore.PathTemplate, error) {
+	return keystore.ParsePathTemplate(request.GetPathTemplate())
+}
+
+// AddressFormat is an adapter function to convert a gRPC pb.AddressFormat to
+// a keystore.AddressFormat instance.
+func AddressFormat(format pb.AddressFormat) (keystore.AddressFormat, error) {
+	switch format {
+	case pb.AddressFormat_ADDRESS_FORMAT_UNSPECIFIED:
+		return keystore.DefaultAddressFormat, nil
+	case pb.AddressFormat_ADDRESS_FORMAT_LEGACY:
+		return keystore.LegacyAddressFormat, nil
+	default:
+		return "", errors.Wrapf(keystore.ErrInvalidAddressFormat, "%v", format)
+	}
 }
 
 // Change is an adapter function to convert a gRPC pb.Change to an instance of
@@ -149,6 +245,14 @@ func Scheme(scheme pb.Scheme) (keystore.Scheme, error) {
 		return keystore.BIP49, nil
 	case pb.Scheme_SCHEME_BIP84:
 		return keystore.BIP84, nil
+	case pb.Scheme_SCHEME_BIP86:
+		return keystore.BIP86, nil
+	case pb.Scheme_SCHEME_MULTISIG_P2SH:
+		return keystore.MultisigP2SH, nil
+	case pb.Scheme_SCHEME_MULTISIG_P2SH_P2WSH:
+		return keystore.MultisigP2SHP2WSH, nil
+	case pb.Scheme_SCHEME_MULTISIG_P2WSH:
+		return keystore.MultisigP2WSH, nil
 	default:
 		return "", ErrUnrecognizedScheme
 	}
@@ -170,7 +274,7 @@ func AddressInfoProto(info keystore.AddressInfo) (*pb.AddressInfo, error) {
 
 	return &pb.AddressInfo{
 		Address:    info.Address,
-		Derivation: info.Derivation.ToSlice(),
+		Derivation: info.Path,
 		Change:     change,
 	}, nil
 }
@@ -187,3 +291,144 @@ func FromChainCode(chainCodeInfo *pb.FromChainCode) *keystore.FromChainCode {
 
 	return nil
 }
+
+// KeyOrigin is an adapter function to get the optional keystore.KeyOrigin of
+// the account of a pb.CreateKeychainRequest. The key origin of
+// from_chain_code, if any, takes precedence over the one of the request.
+//
+// It returns nil if no master fingerprint is specified.
+func KeyOrigin(request *pb.CreateKeychainRequest) (*keystore.KeyOrigin, error) {
+	fingerprint, accountPath := request.GetMasterFingerprint(), request.GetAccountPath()
+
+	if fromChainCode := request.GetFromChainCode(); len(fromChainCode.GetMasterFingerprint()) > 0 {
+		fingerprint, accountPath = fromChainCode.MasterFingerprint, fromChainCode.AccountPath
+	}
+
+	if len(fingerprint) == 0 {
+		if accountPath != "" {
+			return nil, errors.Wrapf(keystore.ErrInvalidKeyOrigin,
+				"account path %s without master fingerprint", accountPath)
+		}
+
+		return nil, nil
+	}
+
+	if len(fingerprint) != 4 {
+		return nil, errors.Wrapf(keystore.ErrInvalidKeyOrigin,
+			"master fingerprint %x is not 4 bytes long", fingerprint)
+	}
+
+	path, err := keystore.ParseAccountPath(accountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := &keystore.KeyOrigin{AccountPath: path}
+	copy(origin.MasterFingerprint[:], fingerprint)
+
+	return origin, nil
+}
+
+// AddressesPublicKeysProto is an adapter function to convert the public keys
+// returned by keystore.Keystore.GetAddressesPublicKeys to a
+// pb.GetAddressesPublicKeysResponse object.
+//
+// The flat list of public keys is only filled for single-key keychains.
+func AddressesPublicKeysProto(publicKeys [][]string) *pb.GetAddressesPublicKeysResponse {
+	response := &pb.GetAddressesPublicKeysResponse{
+		AddressesPublicKeys: make([]*pb.AddressPublicKeys, len(publicKeys)),
+	}
+
+	singleKey := true
+
+	for idx, keys := range publicKeys {
+		response.AddressesPublicKeys[idx] = &pb.AddressPublicKeys{PublicKeys: keys}
+		singleKey = singleKey && len(keys) == 1
+	}
+
+	if singleKey {
+		for _, keys := range publicKeys {
+			response.PublicKeys = append(response.PublicKeys, keys[0])
+		}
+	}
+
+	return response
+}
+
+// AddressOwnerProto is an adapter function to convert a keystore.AddressOwner
+// of an address to a pb.AddressOwner object. A nil owner is converted to an
+// AddressOwner with only the address set.
+func AddressOwnerProto(address string, owner *keystore.AddressOwner) (*pb.AddressOwner, error) {
+	if owner == nil {
+		return &pb.AddressOwner{Address: address}, nil
+	}
+
+	info, err := AddressInfoProto(keystore.AddressInfo{
+		Address:    address,
+		Derivation: owner.Derivation,
+		Path:       owner.Path,
+		Change:     owner.Derivation.ChangeIndex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := owner.KeychainID.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AddressOwner{
+		Address:    info.Address,
+		KeychainId: id,
+		Derivation: info.Derivation,
+		Change:     info.Change,
+	}, nil
+}
+
+// MarkResultProto is an adapter function to convert a keystore.MarkResult
+// to a pb.MarkAddressResult object.
+func MarkResultProto(result keystore.MarkResult) *pb.MarkAddressResult {
+	proto := &pb.MarkAddressResult{Address: result.Address}
+
+	switch result.Status {
+	case keystore.Marked:
+		proto.Status = pb.MarkStatus_MARK_STATUS_MARKED
+	case keystore.AlreadyUsed:
+		proto.Status = pb.MarkStatus_MARK_STATUS_ALREADY_USED
+	case keystore.MarkUnknownAddress:
+		proto.Status = pb.MarkStatus_MARK_STATUS_UNKNOWN_ADDRESS
+		return proto
+	}
+
+	proto.Derivation = result.Path
+
+	return proto
+}
+
+// KeychainFilter is an adapter function to convert the filters of a
+// pb.ListKeychainsRequest to a keystore.KeychainFilter instance. Unset
+// filters select any keychain.
+func KeychainFilter(request *pb.ListKeychainsRequest) (keystore.KeychainFilter, error) {
+	filter := keystore.KeychainFilter{MetadataPrefix: request.MetadataPrefix}
+
+	if request.ChainParams.GetNetwork() != nil {
+		net, err := Network(request.ChainParams)
+		if err != nil {
+			return keystore.KeychainFilter{}, err
+		}
+
+		filter.Network = net
+	}
+
+	if request.Scheme != pb.Scheme_SCHEME_UNSPECIFIED {
+		scheme, err := Scheme(request.Scheme)
+		if err != nil {
+			return keystore.KeychainFilter{}, err
+		}
+
+		filter.Scheme = scheme
+	}
+
+	return filter, nil
+}
@@ -22,4 +22,12 @@ var (
 	// ErrInvalidKeychainID indicates that the UUID representing the keychain
 	// could not be serialized / deserialized.
 	ErrInvalidKeychainID = errors.New("invalid keychain id")
+
+	// ErrNoAddressUsageOracle indicates that keychains can not be discovered,
+	// since no address usage oracle is configured.
+	ErrNoAddressUsageOracle = errors.New("no address usage oracle configured")
+
+	// ErrInvalidAccountKey indicates that the key material of an account to
+	// discover is missing.
+	ErrInvalidAccountKey = errors.New("invalid account key")
 )
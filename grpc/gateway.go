@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"google.golang.org/grpc"
+)
+
+// OpenAPIPath is the path where the gateway serves the OpenAPI document of
+// the REST API.
+const OpenAPIPath = "/openapi.json"
+
+// NewGateway returns an http.Handler serving the REST API of the keychain,
+// which forwards every request to the gRPC server at grpcAddr. It also serves
+// the OpenAPI document of the API at OpenAPIPath.
+//
+// Errors are returned as JSON objects with the gRPC status code and message,
+// and the HTTP status code matching the gRPC status code.
+func NewGateway(
+	ctx context.Context, grpcAddr string, opts ...grpc.DialOption,
+) (http.Handler, error) {
+	gateway := runtime.NewServeMux()
+
+	if err := pb.RegisterKeychainServiceHandlerFromEndpoint(
+		ctx, gateway, grpcAddr, opts); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", gateway)
+	mux.HandleFunc(OpenAPIPath, serveOpenAPI)
+
+	return mux, nil
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(pb.OpenAPI); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("[http] failed to write OpenAPI document")
+	}
+}
@@ -2,28 +2,180 @@ package grpc
 
 import (
 	"context"
+	"time"
 
 	"github.com/ledgerhq/bitcoin-keychain/log"
-
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the dependencies of the keychain service, which can be checked
+// individually.
+const (
+	HealthRedis       = "redis"
+	HealthCoinService = "coin_service"
 )
 
-type HealthChecker struct{}
+const (
+	// healthCheckTimeout bounds the check of every dependency.
+	healthCheckTimeout = 5 * time.Second
+
+	// DefaultHealthWatchInterval is the interval at which dependencies are
+	// checked, while a Watch call is streaming.
+	DefaultHealthWatchInterval = 5 * time.Second
+)
+
+// HealthCheck returns an error if a dependency of the keychain service is
+// not available.
+type HealthCheck func(ctx context.Context) error
+
+// Pinger is implemented by keystores depending on a remote database.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthChecker implements the grpc_health_v1.HealthServer interface, by
+// checking the dependencies of the keychain service.
+//
+// The empty service name and the name of the keychain service report
+// SERVING if all dependencies are available. HealthRedis and
+// HealthCoinService report a single dependency.
+type HealthChecker struct {
+	checks   map[string]HealthCheck
+	interval time.Duration
+}
+
+// NewHealthChecker returns a HealthChecker for the dependencies of a
+// keychain controller: its keystore, if it implements Pinger, and its
+// CoinService client.
+//
+// The client should not be wrapped by the metrics package or by a cache, so
+// that checks are not observed as CoinService calls, and always reach the
+// CoinService.
+func NewHealthChecker(
+	keychainStore keystore.Keystore, client bitcoin.CoinServiceClient,
+) *HealthChecker {
+	checks := map[string]HealthCheck{
+		HealthCoinService: coinServiceHealthCheck(client),
+	}
+
+	if p, ok := keychainStore.(Pinger); ok {
+		checks[HealthRedis] = p.Ping
+	}
+
+	return &HealthChecker{
+		checks:   checks,
+		interval: DefaultHealthWatchInterval,
+	}
+}
+
+// coinServiceHealthCheck checks that the CoinService answers requests, by
+// validating the address of the genesis block of bitcoin.
+func coinServiceHealthCheck(client bitcoin.CoinServiceClient) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := client.ValidateAddress(ctx, &bitcoin.ValidateAddressRequest{
+			Address: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+			ChainParams: &bitcoin.ChainParams{
+				Network: &bitcoin.ChainParams_BitcoinNetwork{
+					BitcoinNetwork: bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET,
+				},
+			},
+		})
+
+		return err
+	}
+}
+
+// servingStatus checks the dependencies of service. It returns false if the
+// service is unknown.
+func (s *HealthChecker) servingStatus(
+	ctx context.Context, service string,
+) (grpc_health_v1.HealthCheckResponse_ServingStatus, bool) {
+	checks := s.checks
+
+	if service != "" && service != pb.KeychainService_ServiceDesc.ServiceName {
+		check, ok := s.checks[service]
+		if !ok {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+
+		checks = map[string]HealthCheck{service: check}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	servingStatus := grpc_health_v1.HealthCheckResponse_SERVING
+
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			log.WithFields(log.Fields{
+				"dependency": name,
+				"error":      err,
+			}).Error("[grpc] Health: dependency is not available")
+
+			servingStatus = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	return servingStatus, true
+}
 
 func (s *HealthChecker) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
-	log.Info("Serving the Check request for health check")
+	servingStatus, ok := s.servingStatus(ctx, req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service: %s", req.Service)
+	}
+
 	return &grpc_health_v1.HealthCheckResponse{
-		Status: grpc_health_v1.HealthCheckResponse_SERVING,
+		Status: servingStatus,
 	}, nil
 }
 
+// Watch sends the serving status of the requested service, then every
+// change of status, until the client cancels the call. Unknown services are
+// reported as SERVICE_UNKNOWN, without ending the call.
 func (s *HealthChecker) Watch(req *grpc_health_v1.HealthCheckRequest, server grpc_health_v1.Health_WatchServer) error {
-	log.Info("Serving the Watch request for health check")
-	return server.Send(&grpc_health_v1.HealthCheckResponse{
-		Status: grpc_health_v1.HealthCheckResponse_SERVING,
-	})
-}
+	log.WithFields(log.Fields{
+		"service": req.Service,
+	}).Info("Serving the Watch request for health check")
+
+	ctx := server.Context()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Dependencies are never reported as UNKNOWN, so the first status is
+	// always sent.
+	last := grpc_health_v1.HealthCheckResponse_UNKNOWN
+
+	for {
+		servingStatus, _ := s.servingStatus(ctx, req.Service)
+
+		// The context may be done while checking dependencies, which would
+		// then be reported as failed.
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		if servingStatus != last {
+			if err := server.Send(&grpc_health_v1.HealthCheckResponse{
+				Status: servingStatus,
+			}); err != nil {
+				return err
+			}
+
+			last = servingStatus
+		}
 
-func NewHealthChecker() *HealthChecker {
-	return &HealthChecker{}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
 }
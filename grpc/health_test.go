@@ -0,0 +1,252 @@
+//go:build !integration
+// +build !integration
+
+package grpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// toggle is a HealthCheck whose result can be changed concurrently.
+type toggle struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (t *toggle) set(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+}
+
+func (t *toggle) check(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err
+}
+
+// watchServer is a grpc_health_v1.Health_WatchServer collecting the
+// responses sent.
+type watchServer struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+func (s *watchServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchServer) Send(resp *grpc_health_v1.HealthCheckResponse) error {
+	s.responses <- resp.Status
+	return nil
+}
+
+func TestHealthChecker_Check(t *testing.T) {
+	errUnreachable := errors.New("connection refused")
+
+	s := &HealthChecker{
+		checks: map[string]HealthCheck{
+			HealthRedis:       func(ctx context.Context) error { return nil },
+			HealthCoinService: func(ctx context.Context) error { return errUnreachable },
+		},
+		interval: DefaultHealthWatchInterval,
+	}
+
+	tests := []struct {
+		name    string
+		service string
+		want    grpc_health_v1.HealthCheckResponse_ServingStatus
+		code    codes.Code
+	}{
+		{
+			name:    "all dependencies",
+			service: "",
+			want:    grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:    "keychain service",
+			service: "pb.keychain.KeychainService",
+			want:    grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:    "redis",
+			service: HealthRedis,
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "coin service",
+			service: HealthCoinService,
+			want:    grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:    "unknown service",
+			service: "unknown",
+			code:    codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Check(context.Background(),
+				&grpc_health_v1.HealthCheckRequest{Service: tt.service})
+			if status.Code(err) != tt.code {
+				t.Fatalf("Check() error = %v, want code %v", err, tt.code)
+			}
+
+			if err == nil && resp.Status != tt.want {
+				t.Fatalf("Check() status = %v, want %v", resp.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthChecker_Watch(t *testing.T) {
+	redis := &toggle{}
+
+	s := &HealthChecker{
+		checks:   map[string]HealthCheck{HealthRedis: redis.check},
+		interval: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := &watchServer{
+		ctx:       ctx,
+		responses: make(chan grpc_health_v1.HealthCheckResponse_ServingStatus),
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.Watch(&grpc_health_v1.HealthCheckRequest{Service: HealthRedis}, server)
+	}()
+
+	expect := func(want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+
+		select {
+		case got := <-server.responses:
+			if got != want {
+				t.Fatalf("Watch() sent %v, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() did not send %v", want)
+		}
+	}
+
+	expect(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	redis.set(errors.New("connection refused"))
+	expect(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	redis.set(nil)
+	expect(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	cancel()
+
+	if err := <-done; status.Code(err) != codes.Canceled {
+		t.Fatalf("Watch() error = %v, want code %v", err, codes.Canceled)
+	}
+}
+
+func TestHealthChecker_WatchUnknownService(t *testing.T) {
+	s := &HealthChecker{interval: time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	server := &watchServer{
+		ctx:       ctx,
+		responses: make(chan grpc_health_v1.HealthCheckResponse_ServingStatus, 10),
+	}
+
+	err := s.Watch(&grpc_health_v1.HealthCheckRequest{Service: "unknown"}, server)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Watch() error = %v, want code %v", err, codes.DeadlineExceeded)
+	}
+
+	close(server.responses)
+
+	var sent []grpc_health_v1.HealthCheckResponse_ServingStatus
+	for resp := range server.responses {
+		sent = append(sent, resp)
+	}
+
+	if len(sent) != 1 || sent[0] != grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN {
+		t.Fatalf("Watch() sent %v, want a single SERVICE_UNKNOWN", sent)
+	}
+}
+
+// pingStore is a keystore depending on a database, which can be pinged.
+type pingStore struct {
+	keystore.Keystore
+	err error
+}
+
+func (s pingStore) Ping(ctx context.Context) error {
+	return s.err
+}
+
+func TestNewHealthChecker(t *testing.T) {
+	client := native.NewCoinServiceClient()
+
+	tests := []struct {
+		name    string
+		store   keystore.Keystore
+		service string
+		want    grpc_health_v1.HealthCheckResponse_ServingStatus
+		wantErr codes.Code
+	}{
+		{
+			name:  "memory store",
+			store: keystore.NewInMemoryKeystore(client),
+			want:  grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "coin service",
+			store:   keystore.NewInMemoryKeystore(client),
+			service: HealthCoinService,
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "memory store is not checked",
+			store:   keystore.NewInMemoryKeystore(client),
+			service: HealthRedis,
+			wantErr: codes.NotFound,
+		},
+		{
+			name:    "unreachable database",
+			store:   pingStore{err: errors.New("connection refused")},
+			service: HealthRedis,
+			want:    grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewHealthChecker(tt.store, client).Check(
+				context.Background(), &grpc_health_v1.HealthCheckRequest{Service: tt.service})
+			if status.Code(err) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.Status != tt.want {
+				t.Fatalf("Check() got = %v, want %v", got.Status, tt.want)
+			}
+		})
+	}
+}
@@ -6,8 +6,14 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
 	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keycache"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -19,9 +25,23 @@ type Controller struct {
 
 var store keystore.Keystore
 
+// addressUsageOracle is used to discover keychains, see
+// SetAddressUsageOracle.
+var addressUsageOracle keystore.AddressUsageOracle
+
+// SetAddressUsageOracle sets the oracle used by DiscoverKeychain. Keychains
+// can not be discovered until an oracle is set.
+func SetAddressUsageOracle(oracle keystore.AddressUsageOracle) {
+	addressUsageOracle = oracle
+}
+
 func (c Controller) CreateKeychain(
 	ctx context.Context, request *pb.CreateKeychainRequest,
 ) (*pb.KeychainInfo, error) {
+	if descriptor := request.GetOutputDescriptor(); descriptor != "" {
+		return createKeychainFromDescriptor(ctx, descriptor, request)
+	}
+
 	net, err := Network(request.ChainParams)
 	if err != nil {
 		return nil, err
@@ -37,14 +57,102 @@ func (c Controller) CreateKeychain(
 		lookaheadSize = s
 	}
 
+	index := request.GetAccountIndex()
+	metadata := request.GetMetadata()
+
+	template, err := PathTemplate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := AddressFormat(request.AddressFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if multisig := request.GetMultisigAccount(); multisig != nil {
+		r, err := store.CreateMultisig(
+			ctx, multisig.ExtendedPublicKeys, multisig.Threshold, template, format, scheme,
+			net, lookaheadSize, index, metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return KeychainInfo(r)
+	}
+
 	extendedKey := request.GetExtendedPublicKey()
 	fromChainCode := FromChainCode(request.GetFromChainCode())
 
-	index := request.GetAccountIndex()
-	metadata := request.GetMetadata()
+	origin, err := KeyOrigin(request)
+	if err != nil {
+		return nil, err
+	}
 
 	r, err := store.Create(
-		extendedKey, fromChainCode, scheme, net, lookaheadSize, index, metadata,
+		ctx, extendedKey, fromChainCode, origin, template, format, scheme, net,
+		lookaheadSize, index, metadata,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return KeychainInfo(r)
+}
+
+// createKeychainFromDescriptor registers the keychain of an account output
+// descriptor, checking that the optional scheme and network of the request
+// match the descriptor.
+func createKeychainFromDescriptor(
+	ctx context.Context, descriptor string, request *pb.CreateKeychainRequest,
+) (*pb.KeychainInfo, error) {
+	desc, err := keystore.ParseDescriptor(descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	if template, err := PathTemplate(request); err != nil || template != "" {
+		return nil, errors.Wrapf(keystore.ErrInvalidPathTemplate,
+			"path template %s is not supported with a descriptor",
+			request.PathTemplate)
+	}
+
+	if request.AddressFormat != pb.AddressFormat_ADDRESS_FORMAT_UNSPECIFIED {
+		return nil, errors.Wrapf(keystore.ErrInvalidAddressFormat,
+			"address format %v is not supported with a descriptor",
+			request.AddressFormat)
+	}
+
+	if request.Scheme != pb.Scheme_SCHEME_UNSPECIFIED {
+		scheme, err := Scheme(request.Scheme)
+		if err != nil {
+			return nil, err
+		}
+
+		if scheme != desc.Scheme {
+			return nil, errors.Wrapf(ErrUnrecognizedScheme,
+				"scheme %s does not match descriptor scheme %s",
+				scheme, desc.Scheme)
+		}
+	}
+
+	var net chaincfg.Network
+
+	if request.ChainParams != nil {
+		if net, err = Network(request.ChainParams); err != nil {
+			return nil, err
+		}
+	}
+
+	lookaheadSize := uint32(keystore.DefaultLookaheadSize) // default lookahead size
+	if s := request.GetLookaheadSize(); s != 0 {
+		lookaheadSize = s
+	}
+
+	r, err := store.CreateFromDescriptor(
+		ctx, descriptor, net, lookaheadSize, request.GetAccountIndex(),
+		request.GetMetadata(),
 	)
 	if err != nil {
 		return nil, err
@@ -61,7 +169,7 @@ func (c Controller) DeleteKeychain(
 		return nil, err
 	}
 
-	return &emptypb.Empty{}, store.Delete(id)
+	return &emptypb.Empty{}, store.Delete(ctx, id)
 }
 
 func (c Controller) ResetKeychain(
@@ -72,7 +180,7 @@ func (c Controller) ResetKeychain(
 		return nil, err
 	}
 
-	return &emptypb.Empty{}, store.Reset(id)
+	return &emptypb.Empty{}, store.Reset(ctx, id)
 }
 
 func (c Controller) GetKeychainInfo(
@@ -83,7 +191,7 @@ func (c Controller) GetKeychainInfo(
 		return nil, err
 	}
 
-	r, err := store.Get(id)
+	r, err := store.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +212,7 @@ func (c Controller) GetFreshAddresses(
 		return nil, err
 	}
 
-	addrs, err := store.GetFreshAddresses(id, change, request.BatchSize)
+	addrs, err := store.GetFreshAddresses(ctx, id, change, request.BatchSize)
 	if err != nil {
 		return nil, err
 	}
@@ -131,7 +239,7 @@ func (c Controller) GetFreshAddresses(
 
 func (c Controller) MarkAddressesAsUsed(
 	ctx context.Context, request *pb.MarkAddressesAsUsedRequest,
-) (*emptypb.Empty, error) {
+) (*pb.MarkAddressesAsUsedResponse, error) {
 	id, err := KeychainID(request.KeychainId)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -142,24 +250,191 @@ func (c Controller) MarkAddressesAsUsed(
 		return nil, err
 	}
 
-	for _, addr := range request.Addresses {
-		if err := store.MarkAddressAsUsed(id, addr); err != nil {
+	results, err := store.MarkAddressesAsUsed(ctx, id, request.Addresses)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    id.String(),
+			"addrs": request.Addresses,
+			"error": err,
+		}).Error("[grpc] MarkAddressesAsUsed: failed")
+
+		return nil, err
+	}
+
+	response := &pb.MarkAddressesAsUsedResponse{
+		Results: make([]*pb.MarkAddressResult, len(results)),
+	}
+
+	var unknown []string
+
+	for i, result := range results {
+		response.Results[i] = MarkResultProto(result)
+
+		if result.Status == keystore.MarkUnknownAddress {
+			unknown = append(unknown, result.Address)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":      id.String(),
+		"addrs":   request.Addresses,
+		"unknown": unknown,
+	}).Info("[grpc] MarkAddressesAsUsed: successful")
+
+	return response, nil
+}
+
+func (c Controller) DiscoverKeychain(
+	ctx context.Context, request *pb.DiscoverKeychainRequest,
+) (*pb.DiscoverKeychainResponse, error) {
+	id, err := KeychainID(request.KeychainId)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    request.KeychainId,
+			"error": err,
+		}).Error("[grpc] DiscoverKeychain: invalid KeychainID")
+
+		return nil, err
+	}
+
+	if addressUsageOracle == nil {
+		return nil, ErrNoAddressUsageOracle
+	}
+
+	used, err := store.DiscoverKeychain(ctx, id, addressUsageOracle)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    id.String(),
+			"error": err,
+		}).Error("[grpc] DiscoverKeychain: failed")
+
+		return nil, err
+	}
+
+	response := &pb.DiscoverKeychainResponse{
+		UsedAddresses: make([]*pb.AddressInfo, len(used)),
+	}
+
+	for i, addr := range used {
+		response.UsedAddresses[i], err = AddressInfoProto(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":   id.String(),
+		"used": len(used),
+	}).Info("[grpc] DiscoverKeychain: successful")
+
+	return response, nil
+}
+
+func (c Controller) DiscoverAccounts(
+	ctx context.Context, request *pb.DiscoverAccountsRequest,
+) (*pb.DiscoverAccountsResponse, error) {
+	if addressUsageOracle == nil {
+		return nil, ErrNoAddressUsageOracle
+	}
+
+	if len(request.Accounts) == 0 {
+		return nil, errors.Wrap(ErrInvalidAccountKey, "no account to discover")
+	}
+
+	response := &pb.DiscoverAccountsResponse{}
+
+	for i, account := range request.Accounts {
+		index := uint32(i)
+
+		info, used, err := c.discoverAccount(ctx, request, account, index)
+		if err != nil {
 			log.WithFields(log.Fields{
-				"id":    id.String(),
-				"addr":  addr,
-				"error": err,
-			}).Error("[grpc] MarkAddressesAsUsed: failed")
+				"account_index": index,
+				"error":         err,
+			}).Error("[grpc] DiscoverAccounts: failed")
 
 			return nil, err
 		}
+
+		response.Keychains = append(response.Keychains, info)
+
+		// BIP44: discovery stops at the first account without history.
+		if !used {
+			break
+		}
 	}
 
 	log.WithFields(log.Fields{
-		"id":    id.String(),
-		"addrs": request.Addresses,
-	}).Info("[grpc] MarkAddressesAsUsed: successful")
+		"accounts": len(response.Keychains),
+	}).Info("[grpc] DiscoverAccounts: successful")
 
-	return &emptypb.Empty{}, nil
+	return response, nil
+}
+
+// discoverAccount creates the keychain of an account, with the parameters of
+// a DiscoverAccountsRequest, and discovers it. It reports whether the account
+// has any used address.
+func (c Controller) discoverAccount(
+	ctx context.Context,
+	request *pb.DiscoverAccountsRequest,
+	account *pb.AccountKey,
+	index uint32,
+) (*pb.KeychainInfo, bool, error) {
+	create := &pb.CreateKeychainRequest{
+		Scheme:            request.Scheme,
+		LookaheadSize:     request.LookaheadSize,
+		ChainParams:       request.ChainParams,
+		AccountIndex:      index,
+		Metadata:          request.Metadata,
+		MasterFingerprint: request.MasterFingerprint,
+	}
+
+	switch key := account.GetKey().(type) {
+	case *pb.AccountKey_ExtendedPublicKey:
+		create.Account = &pb.CreateKeychainRequest_ExtendedPublicKey{
+			ExtendedPublicKey: key.ExtendedPublicKey,
+		}
+	case *pb.AccountKey_FromChainCode:
+		create.Account = &pb.CreateKeychainRequest_FromChainCode{
+			FromChainCode: &pb.FromChainCode{
+				PublicKey:    key.FromChainCode.GetPublicKey(),
+				ChainCode:    key.FromChainCode.GetChainCode(),
+				AccountIndex: index,
+			},
+		}
+	default:
+		return nil, false, errors.Wrapf(ErrInvalidAccountKey,
+			"no key material for account %d", index)
+	}
+
+	info, err := c.CreateKeychain(ctx, create)
+	if err != nil {
+		return nil, false, err
+	}
+
+	id, err := KeychainID(info.KeychainId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	used, err := store.DiscoverKeychain(ctx, id, addressUsageOracle)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Discovered addresses are marked as used, which changes the keychain
+	// info.
+	r, err := store.Get(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err = KeychainInfo(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return info, len(used) > 0, nil
 }
 
 func (c Controller) GetAllObservableAddresses(
@@ -177,7 +452,18 @@ func (c Controller) GetAllObservableAddresses(
 
 	var changeList []keystore.Change
 	if request.Change == pb.Change_CHANGE_UNSPECIFIED {
-		changeList = []keystore.Change{keystore.External, keystore.Internal}
+		// All the chains of the keychain, which may have a single one.
+		info, err := store.Get(ctx, id)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    id.String(),
+				"error": err,
+			}).Error("[grpc] GetAllObservableAddresses: failed to get keychain")
+
+			return nil, err
+		}
+
+		changeList = info.PathTemplate.Changes()
 	} else {
 		change, err := Change(request.Change)
 		if err != nil {
@@ -212,7 +498,7 @@ func (c Controller) GetAllObservableAddresses(
 		}).Info("[grpc] GetAllObservableAddresses: get from keystore")
 
 		changeAddrs, err := store.GetAllObservableAddresses(
-			id, change, request.FromIndex, to)
+			ctx, id, change, request.FromIndex, to)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"id":     id.String(),
@@ -265,10 +551,20 @@ func (c Controller) GetAddressesPublicKeys(
 		return nil, err
 	}
 
+	info, err := store.Get(ctx, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    request.KeychainId,
+			"error": err,
+		}).Error("[grpc] GetAddressesPublicKeys: failed to fetch keychain")
+
+		return nil, err
+	}
+
 	derivations := make([]keystore.DerivationPath, len(request.Derivations))
 
 	for idx, path := range request.Derivations {
-		derivationPath, err := DerivationPath(path.Derivation)
+		derivationPath, err := DerivationPath(info.PathTemplate, path.Derivation)
 
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -282,7 +578,7 @@ func (c Controller) GetAddressesPublicKeys(
 		derivations[idx] = derivationPath
 	}
 
-	publicKeys, err := store.GetAddressesPublicKeys(id, derivations)
+	publicKeys, err := store.GetAddressesPublicKeys(ctx, id, derivations)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id":    request.KeychainId,
@@ -292,7 +588,7 @@ func (c Controller) GetAddressesPublicKeys(
 		return nil, err
 	}
 
-	response := &pb.GetAddressesPublicKeysResponse{PublicKeys: publicKeys}
+	response := AddressesPublicKeysProto(publicKeys)
 
 	log.WithFields(log.Fields{
 		"id":          id.String(),
@@ -303,20 +599,139 @@ func (c Controller) GetAddressesPublicKeys(
 	return response, nil
 }
 
+func (c Controller) FindAddressOwner(
+	ctx context.Context, request *pb.FindAddressOwnerRequest,
+) (*pb.FindAddressOwnerResponse, error) {
+	owners, err := store.FindAddressOwner(ctx, request.Addresses)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addresses": request.Addresses,
+			"error":     err,
+		}).Error("[grpc] FindAddressOwner: failed to fetch from keystore")
+
+		return nil, err
+	}
+
+	response := &pb.FindAddressOwnerResponse{
+		Owners: make([]*pb.AddressOwner, len(owners)),
+	}
+
+	found := 0
+
+	for idx, owner := range owners {
+		ownerProto, err := AddressOwnerProto(request.Addresses[idx], owner)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"addr":  request.Addresses[idx],
+				"error": err,
+			}).Error("[grpc] FindAddressOwner: invalid AddressOwner")
+
+			return nil, err
+		}
+
+		if owner != nil {
+			found++
+		}
+
+		response.Owners[idx] = ownerProto
+	}
+
+	log.WithFields(log.Fields{
+		"num":   len(request.Addresses),
+		"found": found,
+	}).Info("[grpc] FindAddressOwner: successful")
+
+	return response, nil
+}
+
+func (c Controller) ListKeychains(
+	ctx context.Context, request *pb.ListKeychainsRequest,
+) (*pb.ListKeychainsResponse, error) {
+	filter, err := KeychainFilter(request)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("[grpc] ListKeychains: invalid filter")
+
+		return nil, err
+	}
+
+	infos, next, err := store.ListKeychains(ctx, filter, request.Cursor, request.PageSize)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"filter": filter,
+			"cursor": request.Cursor,
+			"error":  err,
+		}).Error("[grpc] ListKeychains: failed to fetch from keystore")
+
+		return nil, err
+	}
+
+	response := &pb.ListKeychainsResponse{
+		Keychains:  make([]*pb.KeychainInfo, len(infos)),
+		NextCursor: next,
+	}
+
+	for idx, info := range infos {
+		infoProto, err := KeychainInfo(info)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    info.ID.String(),
+				"error": err,
+			}).Error("[grpc] ListKeychains: invalid KeychainInfo")
+
+			return nil, err
+		}
+
+		response.Keychains[idx] = infoProto
+	}
+
+	log.WithFields(log.Fields{
+		"filter": filter,
+		"cursor": request.Cursor,
+		"num":    len(infos),
+	}).Info("[grpc] ListKeychains: successful")
+
+	return response, nil
+}
+
 // NewKeychainController returns a new instance of a Controller struct that
 // implements the pb.KeychainServiceServer interface.
-func NewKeychainController(storeType string, redisOpts *redis.Options) (*Controller, error) {
+//
+// The client is used for protocol-level operations, see
+// NewCoinServiceClient. Its calls are observed by the metrics package.
+//
+// If addressSearchLimit is not 0, unknown addresses marked as used are
+// searched up to addressSearchLimit addresses past the observable range of
+// their keychain.
+//
+// If keyCacheSize is not 0, up to keyCacheSize derived child keys are cached,
+// and shared by all keychains.
+func NewKeychainController(
+	storeType string, client bitcoin.CoinServiceClient, redisOpts *redis.Options,
+	addressSearchLimit uint32, keyCacheSize int,
+) (*Controller, error) {
 	var err error
 
+	client = metrics.NewCoinServiceClient(client)
+
+	// Calls served by the cache are not observed as CoinService calls.
+	if keyCacheSize > 0 {
+		client = keycache.NewCoinServiceClient(client, keyCacheSize)
+		log.WithFields(log.Fields{
+			"size": keyCacheSize,
+		}).Info("caching derived keys")
+	}
+
 	switch storeType {
 	case "redis":
-		store, err = keystore.NewRedisKeystore(redisOpts)
+		store, err = keystore.NewRedisKeystore(redisOpts, client)
 		log.Info("creating redis backend")
 	case "memory":
-		store = keystore.NewInMemoryKeystore()
+		store = keystore.NewInMemoryKeystore(client)
 		log.Info("creating memory backend")
 	case "wd":
-		store, err = keystore.NewWDKeystore(redisOpts)
+		store, err = keystore.NewWDKeystore(redisOpts, client)
 		log.Info("creating walletdaemon backend")
 	default:
 		return nil, fmt.Errorf("unknown store type: %s", storeType)
@@ -326,5 +741,36 @@ func NewKeychainController(storeType string, redisOpts *redis.Options) (*Control
 		return nil, fmt.Errorf("Creating redis client failed: %w", err)
 	}
 
+	if s, ok := store.(keystore.AddressSearcher); ok {
+		s.SetAddressSearchLimit(addressSearchLimit)
+	}
+
+	if s, ok := store.(metrics.Store); ok {
+		metrics.SetStore(storeType, s)
+	}
+
 	return &Controller{}, nil
 }
+
+// Store returns the keystore of the controller created by
+// NewKeychainController.
+func (c Controller) Store() keystore.Keystore {
+	return store
+}
+
+// NewCoinServiceClient returns the bitcoin.CoinServiceClient implementation
+// corresponding to the given name: "remote" dials the external
+// bitcoin-lib-grpc service, while "native" performs the operations
+// in-process. An empty name defaults to "remote".
+func NewCoinServiceClient(coinService string) (bitcoin.CoinServiceClient, error) {
+	switch coinService {
+	case "", "remote":
+		log.Info("using remote bitcoin-lib-grpc coin service")
+		return bitcoin.NewBitcoinClient(), nil
+	case "native":
+		log.Info("using native coin service")
+		return native.NewCoinServiceClient(), nil
+	default:
+		return nil, fmt.Errorf("unknown coin service: %s", coinService)
+	}
+}
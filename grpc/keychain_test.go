@@ -0,0 +1,77 @@
+//go:build !integration
+// +build !integration
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+)
+
+func TestController_GetAllObservableAddresses(t *testing.T) {
+	ctx := context.Background()
+	store = keystore.NewInMemoryKeystore(native.NewCoinServiceClient())
+
+	tests := []struct {
+		name         string
+		pathTemplate string
+		want         []pb.Change
+	}{
+		{
+			name:         "default template",
+			pathTemplate: "",
+			want:         []pb.Change{pb.Change_CHANGE_EXTERNAL, pb.Change_CHANGE_INTERNAL},
+		},
+		{
+			name:         "single chain",
+			pathTemplate: "0/*",
+			want:         []pb.Change{pb.Change_CHANGE_EXTERNAL},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Controller{}.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+				Account: &pb.CreateKeychainRequest_ExtendedPublicKey{
+					ExtendedPublicKey: "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+				},
+				LookaheadSize: 20,
+				ChainParams: &pb.ChainParams{
+					Network: &pb.ChainParams_BitcoinNetwork{
+						BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_MAINNET,
+					},
+				},
+				Scheme:       pb.Scheme_SCHEME_BIP84,
+				PathTemplate: tt.pathTemplate,
+			})
+			if err != nil {
+				t.Fatalf("CreateKeychain() unexpected error: %v", err)
+			}
+
+			// The change is unspecified, so that all chains are returned.
+			got, err := Controller{}.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+				KeychainId: info.KeychainId,
+				ToIndex:    1,
+			})
+			if err != nil {
+				t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+			}
+
+			if len(got.Addresses) != 2*len(tt.want) {
+				t.Fatalf("GetAllObservableAddresses() got %d addresses, want %d",
+					len(got.Addresses), 2*len(tt.want))
+			}
+
+			for i, addr := range got.Addresses {
+				if addr.Change != tt.want[i/2] {
+					t.Fatalf("GetAllObservableAddresses() got = %v, want change %v",
+						addr, tt.want[i/2])
+				}
+			}
+		})
+	}
+}
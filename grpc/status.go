@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang/protobuf/proto"
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/oracle"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorStatus describes how a sentinel error is reported to clients: the
+// gRPC status code, and the request field or the kind of resource it
+// relates to.
+type errorStatus struct {
+	code     codes.Code
+	field    string
+	resource string
+}
+
+// errorStatuses maps the sentinel errors of the keystore, and of the
+// packages it depends on, to their gRPC status.
+var errorStatuses = map[error]errorStatus{
+	ErrInvalidKeychainID:                  {code: codes.InvalidArgument, field: "keychain_id"},
+	ErrUnrecognizedNetwork:                {code: codes.InvalidArgument, field: "chain_params"},
+	ErrUnrecognizedScheme:                 {code: codes.InvalidArgument, field: "scheme"},
+	ErrUnrecognizedChange:                 {code: codes.InvalidArgument, field: "change"},
+	ErrInvalidDerivationPath:              {code: codes.InvalidArgument, field: "derivation"},
+	ErrInvalidAccountKey:                  {code: codes.InvalidArgument, field: "accounts"},
+	keystore.ErrUnrecognizedNetwork:       {code: codes.InvalidArgument, field: "chain_params"},
+	keystore.ErrUnrecognizedScheme:        {code: codes.InvalidArgument, field: "scheme"},
+	keystore.ErrUnrecognizedChange:        {code: codes.InvalidArgument, field: "change"},
+	keystore.ErrInvalidDerivationPath:     {code: codes.InvalidArgument, field: "derivation"},
+	keystore.ErrInvalidMultisig:           {code: codes.InvalidArgument, field: "multisig_account"},
+	keystore.ErrInvalidDescriptor:         {code: codes.InvalidArgument, field: "output_descriptor"},
+	keystore.ErrInvalidDescriptorChecksum: {code: codes.InvalidArgument, field: "output_descriptor"},
+	keystore.ErrInvalidExtendedKey:        {code: codes.InvalidArgument, field: "extended_public_key"},
+	keystore.ErrInvalidKeyOrigin:          {code: codes.InvalidArgument, field: "account_path"},
+	keystore.ErrInvalidCursor:             {code: codes.InvalidArgument, field: "cursor"},
+	keystore.ErrInvalidPathTemplate:       {code: codes.InvalidArgument, field: "path_template"},
+	keystore.ErrInvalidAddressFormat:      {code: codes.InvalidArgument, field: "address_format"},
+	chaincfg.ErrUnrecognizedNetwork:       {code: codes.InvalidArgument, field: "chain_params"},
+	bitcoin.ErrUnrecognizedNetwork:        {code: codes.InvalidArgument, field: "chain_params"},
+	address.ErrInvalidMultisig:            {code: codes.InvalidArgument, field: "multisig_account"},
+	bip32.ErrInvalidKeyLen:                {code: codes.InvalidArgument, field: "extended_public_key"},
+	bip32.ErrPrivateKey:                   {code: codes.InvalidArgument, field: "extended_public_key"},
+	bip32.ErrInvalidPublicKey:             {code: codes.InvalidArgument, field: "extended_public_key"},
+	bip32.ErrInvalidChainCode:             {code: codes.InvalidArgument, field: "extended_public_key"},
+	bip32.ErrDeriveHardened:               {code: codes.InvalidArgument, field: "derivation"},
+	bip32.ErrDeriveBeyondMaxDepth:         {code: codes.InvalidArgument, field: "derivation"},
+	base58.ErrInvalidCharacter:            {code: codes.InvalidArgument, field: "extended_public_key"},
+	base58.ErrInvalidChecksum:             {code: codes.InvalidArgument, field: "extended_public_key"},
+	base58.ErrInvalidFormat:               {code: codes.InvalidArgument, field: "extended_public_key"},
+
+	keystore.ErrKeychainNotFound: {code: codes.NotFound, resource: "keychain"},
+	keystore.ErrAddressNotFound:  {code: codes.NotFound, resource: "address"},
+
+	// Public keys are only known for derivations that were observed, through
+	// GetFreshAddresses or GetAllObservableAddresses.
+	keystore.ErrDerivationNotFound: {code: codes.FailedPrecondition, field: "derivations"},
+
+	keystore.ErrConcurrentModification: {code: codes.Aborted},
+
+	ErrNoAddressUsageOracle:         {code: codes.Unimplemented},
+	keystore.ErrInvalidAddressUsage: {code: codes.Unavailable},
+	keystore.ErrInvalidDerivations:  {code: codes.Unavailable},
+	oracle.ErrUnexpectedStatus:      {code: codes.Unavailable},
+
+	redis.ErrClosed: {code: codes.Unavailable},
+}
+
+// Status translates an error returned by the controller to a gRPC status.
+//
+// Sentinel errors are looked up from the outermost to the innermost cause,
+// and reported with details naming the offending request field or resource.
+// Errors that already carry a gRPC status, such as those of a remote
+// CoinService, keep it. Connection errors are reported as codes.Unavailable,
+// and other errors as codes.Unknown, as gRPC would.
+func Status(err error) *status.Status {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if s, ok := errorStatuses[e]; ok {
+			return s.status(err)
+		}
+
+		if _, ok := e.(interface{ GRPCStatus() *status.Status }); ok {
+			st, _ := status.FromError(e)
+			return st
+		}
+
+		if e == context.Canceled || e == context.DeadlineExceeded {
+			return status.FromContextError(e)
+		}
+
+		if _, ok := e.(net.Error); ok {
+			return status.New(codes.Unavailable, err.Error())
+		}
+	}
+
+	return status.New(codes.Unknown, err.Error())
+}
+
+// status builds the gRPC status of err, with the error details matching
+// the status code.
+func (s errorStatus) status(err error) *status.Status {
+	st := status.New(s.code, err.Error())
+
+	var detail proto.Message
+
+	switch s.code {
+	case codes.InvalidArgument:
+		detail = &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       s.field,
+				Description: err.Error(),
+			}},
+		}
+	case codes.NotFound:
+		detail = &errdetails.ResourceInfo{
+			ResourceType: s.resource,
+			Description:  err.Error(),
+		}
+	case codes.FailedPrecondition:
+		detail = &errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{{
+				Type:        "NOT_OBSERVED",
+				Subject:     s.field,
+				Description: err.Error(),
+			}},
+		}
+	default:
+		return st
+	}
+
+	withDetails, err := st.WithDetails(detail)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("[grpc] Status: failed to attach error details")
+
+		return st
+	}
+
+	return withDetails
+}
+
+// UnaryErrorInterceptor is a grpc.UnaryServerInterceptor which reports the
+// errors of the keychain service with their gRPC Status.
+func UnaryErrorInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, Status(err).Err()
+	}
+
+	return resp, nil
+}
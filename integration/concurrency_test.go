@@ -0,0 +1,144 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+// TestConcurrentMarkAddressesAsUsed marks every address of the lookahead
+// window as used from concurrent clients, and checks that none of the
+// updates of the keychain was lost.
+func TestConcurrentMarkAddressesAsUsed(t *testing.T) {
+	const workers = 20
+
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+		LookaheadSize: workers,
+		ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+		Scheme:        BitcoinMainnetP2WPKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	obsAddrs, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		FromIndex:  0,
+		ToIndex:    workers - 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to get addresses in observable range [0 %d] - error = %v",
+			workers-1, err)
+	}
+
+	if len(obsAddrs.Addresses) != workers {
+		t.Fatalf("got %d observable addresses, want %d",
+			len(obsAddrs.Addresses), workers)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, workers)
+
+	for _, addr := range obsAddrs.Addresses {
+		wg.Add(1)
+
+		go func(addr string) {
+			defer wg.Done()
+
+			_, err := client.MarkAddressesAsUsed(ctx, &pb.MarkAddressesAsUsedRequest{
+				KeychainId: info.KeychainId,
+				Addresses:  []string{addr},
+			})
+			errs <- err
+		}(addr.Address)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to mark address as used - error = %v", err)
+		}
+	}
+
+	// If any update was lost, an address below the window would still be
+	// unused, and be returned as the fresh address.
+	fresh, err := client.GetFreshAddresses(ctx, &pb.GetFreshAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		BatchSize:  1,
+	})
+	if err != nil {
+		t.Fatalf("failed to get fresh addresses - error = %v", err)
+	}
+
+	if len(fresh.Addresses) != 1 {
+		t.Fatalf("got %d fresh addresses, want 1", len(fresh.Addresses))
+	}
+
+	if got := fresh.Addresses[0].Derivation; len(got) != 2 || got[0] != 0 || got[1] != workers {
+		t.Fatalf("got fresh address at derivation %v, want [0 %d]", got, workers)
+	}
+}
+
+// TestConcurrentPolling polls the addresses of a keychain from concurrent
+// clients, which must not abort each other, including when some of them
+// derive addresses which are not stored yet.
+func TestConcurrentPolling(t *testing.T) {
+	const workers = 50
+
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+		LookaheadSize: 20,
+		ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+		Scheme:        BitcoinMainnetP2WPKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func(toIndex uint32) {
+			defer wg.Done()
+
+			_, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+				KeychainId: info.KeychainId,
+				Change:     pb.Change_CHANGE_EXTERNAL,
+				FromIndex:  0,
+				ToIndex:    toIndex,
+			})
+			errs <- err
+		}(uint32(i % 20))
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to get observable addresses - error = %v", err)
+		}
+	}
+}
@@ -111,8 +111,9 @@ func TestKeychainRegistration(t *testing.T) {
 				KeychainId:              info.KeychainId,
 				InternalDescriptor:      tt.fixture.InternalDescriptor,
 				ExternalDescriptor:      tt.fixture.ExternalDescriptor,
+				MultipathDescriptor:     tt.fixture.MultipathDescriptor,
 				ExtendedPublicKey:       tt.fixture.ExtendedPublicKey,
-				Slip32ExtendedPublicKey: tt.fixture.ExtendedPublicKey,
+				Slip32ExtendedPublicKey: tt.fixture.SLIP132ExtendedPublicKey,
 				LookaheadSize:           20,
 				Scheme:                  tt.fixture.Scheme,
 				ChainParams:             tt.fixture.ChainParams,
@@ -0,0 +1,121 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiscoverAccounts(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	// Account keys, by account index. Account 1 is unused, so that account 2
+	// must not be discovered although it is used.
+	accounts := []string{
+		"tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+		"tpubDCxX2sYFS5bDkSe5GKKYHjBW7tgyN1R3UchpLJvdbf54ohxeGRtd8MbDUe1cguVHe4vnK68DsuD5MXjxi9EXx16rb9EnNsaF5KT99CinaJz",
+		"tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+	}
+
+	request := &pb.DiscoverAccountsRequest{
+		Scheme:            pb.Scheme_SCHEME_BIP84,
+		LookaheadSize:     20,
+		ChainParams:       BitcoinTestnet3P2PKH.ChainParams,
+		MasterFingerprint: []byte{0xd3, 0x4d, 0xb3, 0x3f},
+	}
+
+	for _, xpub := range accounts {
+		request.Accounts = append(request.Accounts, &pb.AccountKey{
+			Key: &pb.AccountKey_ExtendedPublicKey{ExtendedPublicKey: xpub},
+		})
+	}
+
+	// Use an external address of account 0 and an internal address of
+	// account 2.
+	for _, tc := range []struct {
+		account int
+		change  pb.Change
+		index   uint32
+	}{
+		{account: 0, change: pb.Change_CHANGE_EXTERNAL, index: 5},
+		{account: 2, change: pb.Change_CHANGE_INTERNAL, index: 0},
+	} {
+		info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+			Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: accounts[tc.account]},
+			LookaheadSize: request.LookaheadSize,
+			ChainParams:   request.ChainParams,
+			Scheme:        request.Scheme,
+		})
+		if err != nil {
+			t.Fatalf("failed to create keychain - error = %v", err)
+		}
+
+		addrs, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+			KeychainId: info.KeychainId,
+			Change:     tc.change,
+			FromIndex:  tc.index,
+			ToIndex:    tc.index,
+		})
+		if err != nil {
+			t.Fatalf("failed to get observable addresses - error = %v", err)
+		}
+
+		usageOracle.Use(addrs.Addresses[0].Address)
+	}
+
+	got, err := client.DiscoverAccounts(ctx, request)
+	if err != nil {
+		t.Fatalf("DiscoverAccounts() - error = %v", err)
+	}
+
+	if len(got.Keychains) != 2 {
+		t.Fatalf("DiscoverAccounts() got %d keychains, want 2", len(got.Keychains))
+	}
+
+	for i, info := range got.Keychains {
+		// The account path is inferred from the account index.
+		path := fmt.Sprintf("84'/1'/%d'", i)
+
+		if info.ExtendedPublicKey != accounts[i] || info.AccountPath != path {
+			t.Fatalf("DiscoverAccounts() got keychain %v, want account %s", info, path)
+		}
+	}
+
+	// The external address of account 0 is marked as used, so that it is
+	// skipped by fresh addresses.
+	fresh, err := client.GetFreshAddresses(ctx, &pb.GetFreshAddressesRequest{
+		KeychainId: got.Keychains[0].KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		BatchSize:  6,
+	})
+	if err != nil {
+		t.Fatalf("failed to get fresh addresses - error = %v", err)
+	}
+
+	if last := fresh.Addresses[5].Derivation[1]; last != 6 {
+		t.Fatalf("GetFreshAddresses() got last index %d, want 6", last)
+	}
+}
+
+func TestDiscoverAccounts_MissingKey(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	_, err := client.DiscoverAccounts(ctx, &pb.DiscoverAccountsRequest{
+		Accounts:    []*pb.AccountKey{{}},
+		Scheme:      pb.Scheme_SCHEME_BIP84,
+		ChainParams: BitcoinTestnet3P2PKH.ChainParams,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("DiscoverAccounts() error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
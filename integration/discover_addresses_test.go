@@ -0,0 +1,89 @@
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+func TestDiscoverAddressesBeyondObservableRange(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	request := &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+		LookaheadSize: 100,
+		ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+		Scheme:        BitcoinMainnetP2WPKH.Scheme,
+	}
+
+	info, err := client.CreateKeychain(ctx, request)
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	// Derive the addresses of another wallet of the same account, which
+	// used them beyond the observable range of the keychain created below.
+	beyond, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_INTERNAL,
+		FromIndex:  30,
+		ToIndex:    30 + addressSearchLimit,
+	})
+	if err != nil {
+		t.Fatalf("failed to get addresses - error = %v", err)
+	}
+
+	// Recreate the keychain with a smaller lookahead, so that it forgets
+	// these addresses.
+	if _, err := client.DeleteKeychain(ctx, &pb.DeleteKeychainRequest{
+		KeychainId: info.KeychainId,
+	}); err != nil {
+		t.Fatalf("failed to delete keychain - error = %v", err)
+	}
+
+	request.LookaheadSize = 20
+
+	if info, err = client.CreateKeychain(ctx, request); err != nil {
+		t.Fatalf("failed to recreate keychain - error = %v", err)
+	}
+
+	// The observable range is [0..19], so that the address at index 30 is
+	// found within the search limit, unlike the one at index 30+limit.
+	found, notFound := beyond.Addresses[0], beyond.Addresses[addressSearchLimit]
+
+	got, err := client.MarkAddressesAsUsed(ctx, &pb.MarkAddressesAsUsedRequest{
+		KeychainId: info.KeychainId,
+		Addresses:  []string{found.Address, notFound.Address},
+	})
+	if err != nil {
+		t.Fatalf("MarkAddressesAsUsed() - error = %v", err)
+	}
+
+	want := &pb.MarkAddressesAsUsedResponse{Results: []*pb.MarkAddressResult{
+		{Address: found.Address, Derivation: found.Derivation, Status: pb.MarkStatus_MARK_STATUS_MARKED},
+		{Address: notFound.Address, Status: pb.MarkStatus_MARK_STATUS_UNKNOWN_ADDRESS},
+	}}
+
+	if !proto.Equal(got, want) {
+		t.Fatalf("MarkAddressesAsUsed() got = '%v', want = '%v'", got, want)
+	}
+
+	owners, err := client.FindAddressOwner(ctx, &pb.FindAddressOwnerRequest{
+		Addresses: []string{found.Address},
+	})
+	if err != nil {
+		t.Fatalf("FindAddressOwner() - error = %v", err)
+	}
+
+	if !bytes.Equal(owners.Owners[0].KeychainId, info.KeychainId) {
+		t.Fatalf("FindAddressOwner() got keychain %x, want %x",
+			owners.Owners[0].KeychainId, info.KeychainId)
+	}
+}
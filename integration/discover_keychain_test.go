@@ -0,0 +1,85 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+func TestDiscoverKeychain(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinTestnet3P2PKH.ExtendedPublicKey},
+		LookaheadSize: 20,
+		ChainParams:   BitcoinTestnet3P2PKH.ChainParams,
+		Scheme:        BitcoinTestnet3P2PKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	var want []*pb.AddressInfo
+
+	for _, tc := range []struct {
+		change  pb.Change
+		indexes []int
+	}{
+		{change: pb.Change_CHANGE_EXTERNAL, indexes: []int{0, 3, 15}},
+		{change: pb.Change_CHANGE_INTERNAL, indexes: []int{1}},
+	} {
+		addrs, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+			KeychainId: info.KeychainId,
+			Change:     tc.change,
+			FromIndex:  0,
+			ToIndex:    19,
+		})
+		if err != nil {
+			t.Fatalf("failed to get observable addresses - error = %v", err)
+		}
+
+		for _, index := range tc.indexes {
+			usageOracle.Use(addrs.Addresses[index].Address)
+			want = append(want, addrs.Addresses[index])
+		}
+	}
+
+	if _, err := client.ResetKeychain(ctx, &pb.ResetKeychainRequest{
+		KeychainId: info.KeychainId,
+	}); err != nil {
+		t.Fatalf("failed to reset keychain - error = %v", err)
+	}
+
+	got, err := client.DiscoverKeychain(ctx, &pb.DiscoverKeychainRequest{
+		KeychainId: info.KeychainId,
+	})
+	if err != nil {
+		t.Fatalf("DiscoverKeychain() - error = %v", err)
+	}
+
+	if !proto.Equal(got, &pb.DiscoverKeychainResponse{UsedAddresses: want}) {
+		t.Fatalf("DiscoverKeychain() got = '%v', want = '%v'", got.UsedAddresses, want)
+	}
+
+	// Fresh addresses skip the used addresses discovered.
+	fresh, err := client.GetFreshAddresses(ctx, &pb.GetFreshAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		BatchSize:  3,
+	})
+	if err != nil {
+		t.Fatalf("failed to get fresh addresses - error = %v", err)
+	}
+
+	for i, index := range []uint32{1, 2, 4} {
+		if got := fresh.Addresses[i].Derivation[1]; got != index {
+			t.Fatalf("GetFreshAddresses() got index %d, want %d", got, index)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+func TestFindAddressOwner(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinTestnet3P2SHP2WPKH.ExtendedPublicKey},
+		LookaheadSize: 20,
+		ChainParams:   BitcoinTestnet3P2SHP2WPKH.ChainParams,
+		Scheme:        BitcoinTestnet3P2SHP2WPKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	fresh, err := client.GetFreshAddresses(ctx, &pb.GetFreshAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_INTERNAL,
+		BatchSize:  3,
+	})
+	if err != nil {
+		t.Fatalf("failed to get fresh addresses - error = %v", err)
+	}
+
+	unknown := "2N2JD6wb56AfK4tfmM6PwdVmoYk2dCKf4Br"
+
+	got, err := client.FindAddressOwner(ctx, &pb.FindAddressOwnerRequest{
+		Addresses: []string{fresh.Addresses[2].Address, unknown},
+	})
+	if err != nil {
+		t.Fatalf("failed to find address owner - error = %v", err)
+	}
+
+	if len(got.Owners) != 2 {
+		t.Fatalf("got %d owners, want 2", len(got.Owners))
+	}
+
+	owner := got.Owners[0]
+
+	if !bytes.Equal(owner.KeychainId, info.KeychainId) {
+		t.Fatalf("got keychain %x, want %x", owner.KeychainId, info.KeychainId)
+	}
+
+	if owner.Address != fresh.Addresses[2].Address ||
+		!reflect.DeepEqual(owner.Derivation, []uint32{1, 2}) ||
+		owner.Change != pb.Change_CHANGE_INTERNAL {
+		t.Fatalf("got owner %v, want address %s at derivation [1 2]",
+			owner, fresh.Addresses[2].Address)
+	}
+
+	if got.Owners[1].Address != unknown || len(got.Owners[1].KeychainId) != 0 {
+		t.Fatalf("got owner %v for unknown address, want none", got.Owners[1])
+	}
+}
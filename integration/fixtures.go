@@ -3,17 +3,21 @@ package integration
 import pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
 
 type Fixture struct {
-	ExternalDescriptor string
-	InternalDescriptor string
-	ExtendedPublicKey  string
-	ChainParams        *pb.ChainParams
-	Scheme             pb.Scheme
+	ExternalDescriptor       string
+	InternalDescriptor       string
+	MultipathDescriptor      string
+	ExtendedPublicKey        string
+	SLIP132ExtendedPublicKey string
+	ChainParams              *pb.ChainParams
+	Scheme                   pb.Scheme
 }
 
 var BitcoinMainnetP2PKH = Fixture{
-	ExternalDescriptor: "pkh(xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or/0/*)",
-	InternalDescriptor: "pkh(xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or/1/*)",
-	ExtendedPublicKey:  "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+	ExternalDescriptor:       "pkh(xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or/0/*)#apqzzacs",
+	InternalDescriptor:       "pkh(xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or/1/*)#v49rlggg",
+	MultipathDescriptor:      "pkh(xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or/<0;1>/*)#ymahz4ya",
+	ExtendedPublicKey:        "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+	SLIP132ExtendedPublicKey: "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
 	ChainParams: &pb.ChainParams{
 		Network: &pb.ChainParams_BitcoinNetwork{
 			BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_MAINNET,
@@ -23,9 +27,11 @@ var BitcoinMainnetP2PKH = Fixture{
 }
 
 var BitcoinTestnet3P2PKH = Fixture{
-	ExternalDescriptor: "pkh(tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba/0/*)",
-	InternalDescriptor: "pkh(tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba/1/*)",
-	ExtendedPublicKey:  "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+	ExternalDescriptor:       "pkh(tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba/0/*)#ljwm9te0",
+	InternalDescriptor:       "pkh(tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba/1/*)#wxt6c7fh",
+	MultipathDescriptor:      "pkh(tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba/<0;1>/*)#wmdznkc4",
+	ExtendedPublicKey:        "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+	SLIP132ExtendedPublicKey: "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
 	ChainParams: &pb.ChainParams{
 		Network: &pb.ChainParams_BitcoinNetwork{
 			BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3,
@@ -35,9 +41,11 @@ var BitcoinTestnet3P2PKH = Fixture{
 }
 
 var BitcoinTestnet3P2SHP2WPKH = Fixture{
-	ExternalDescriptor: "sh(wpkh(tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q/0/*))",
-	InternalDescriptor: "sh(wpkh(tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q/1/*))",
-	ExtendedPublicKey:  "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+	ExternalDescriptor:       "sh(wpkh(tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q/0/*))#w5q83dfe",
+	InternalDescriptor:       "sh(wpkh(tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q/1/*))#ghgz2qzd",
+	MultipathDescriptor:      "sh(wpkh(tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q/<0;1>/*))#wnpv7jds",
+	ExtendedPublicKey:        "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+	SLIP132ExtendedPublicKey: "upub5DkWPzTWxWGNox4JWPtxtY34EWoN3brQmq9eBGL6ANg7srbhLM1PrPo8EHayjmqrMj3uLSUFgs4Y6v1uJsaZevBmpGHZAMjSKE4xqqSQJyq",
 	ChainParams: &pb.ChainParams{
 		Network: &pb.ChainParams_BitcoinNetwork{
 			BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3,
@@ -47,9 +55,11 @@ var BitcoinTestnet3P2SHP2WPKH = Fixture{
 }
 
 var BitcoinMainnetP2WPKH = Fixture{
-	ExternalDescriptor: "wpkh(xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN/0/*)",
-	InternalDescriptor: "wpkh(xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN/1/*)",
-	ExtendedPublicKey:  "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+	ExternalDescriptor:       "wpkh(xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN/0/*)#t5w76cjl",
+	InternalDescriptor:       "wpkh(xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN/1/*)#6qtl8dz8",
+	MultipathDescriptor:      "wpkh(xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN/<0;1>/*)#34m5pk6k",
+	ExtendedPublicKey:        "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+	SLIP132ExtendedPublicKey: "zpub6r2Ax5symMcw38udB56RVWhuAnn44q5HmcGq6a2SzT78xe6ePF2eczUQ2jMBZEdYJFdsZEvguBwUuVLoemJ5tZm86mNC7tQcnDB4bHYE1JE",
 	ChainParams: &pb.ChainParams{
 		Network: &pb.ChainParams_BitcoinNetwork{
 			BitcoinNetwork: pb.BitcoinNetwork_BITCOIN_NETWORK_MAINNET,
@@ -59,9 +69,11 @@ var BitcoinMainnetP2WPKH = Fixture{
 }
 
 var LitecoinMainnetP2WPKH = Fixture{
-	ExternalDescriptor: "wpkh(Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo/0/*)",
-	InternalDescriptor: "wpkh(Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo/1/*)",
-	ExtendedPublicKey:  "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+	ExternalDescriptor:       "wpkh(Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo/0/*)#3l3nq2js",
+	InternalDescriptor:       "wpkh(Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo/1/*)#qt5jalzg",
+	MultipathDescriptor:      "wpkh(Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo/<0;1>/*)#ll89ylu3",
+	ExtendedPublicKey:        "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+	SLIP132ExtendedPublicKey: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
 	ChainParams: &pb.ChainParams{
 		Network: &pb.ChainParams_LitecoinNetwork{
 			LitecoinNetwork: pb.LitecoinNetwork_LITECOIN_NETWORK_MAINNET,
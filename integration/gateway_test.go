@@ -0,0 +1,104 @@
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	controllers "github.com/ledgerhq/bitcoin-keychain/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	gateway, err := controllers.NewGateway(
+		ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to init gateway - error = %v", err)
+	}
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	post := func(t *testing.T, path string, body string) (int, map[string]interface{}) {
+		resp, err := http.Post(
+			server.URL+path, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed to call %s - error = %v", path, err)
+		}
+		defer resp.Body.Close()
+
+		var got map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response of %s - error = %v", path, err)
+		}
+
+		return resp.StatusCode, got
+	}
+
+	t.Run("create keychain", func(t *testing.T) {
+		status, got := post(t, "/v1/bitcoin/CreateKeychain", `{
+			"extendedPublicKey": "`+BitcoinMainnetP2WPKH.ExtendedPublicKey+`",
+			"scheme": "SCHEME_BIP84",
+			"lookaheadSize": 20,
+			"chainParams": {"bitcoinNetwork": "BITCOIN_NETWORK_MAINNET"}
+		}`)
+
+		if status != http.StatusOK {
+			t.Fatalf("got HTTP status %d, want %d - body = %v", status, http.StatusOK, got)
+		}
+
+		if got["externalDescriptor"] != BitcoinMainnetP2WPKH.ExternalDescriptor {
+			t.Fatalf("got external descriptor %v, want %s",
+				got["externalDescriptor"], BitcoinMainnetP2WPKH.ExternalDescriptor)
+		}
+	})
+
+	t.Run("JSON error", func(t *testing.T) {
+		status, got := post(t, "/v1/bitcoin/GetKeychainInfo", `{"keychainId": "AAAA"}`)
+
+		if status != http.StatusBadRequest {
+			t.Fatalf("got HTTP status %d, want %d", status, http.StatusBadRequest)
+		}
+
+		if code, ok := got["code"].(float64); !ok || codes.Code(code) != codes.InvalidArgument {
+			t.Fatalf("got error code %v, want %d", got["code"], codes.InvalidArgument)
+		}
+
+		if msg, ok := got["message"].(string); !ok || msg == "" {
+			t.Fatalf("got error message %v, want a message", got["message"])
+		}
+	})
+
+	t.Run("OpenAPI document", func(t *testing.T) {
+		resp, err := http.Get(server.URL + controllers.OpenAPIPath)
+		if err != nil {
+			t.Fatalf("failed to get OpenAPI document - error = %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got HTTP status %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+
+		var doc struct {
+			Swagger string                 `json:"swagger"`
+			Paths   map[string]interface{} `json:"paths"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+			t.Fatalf("failed to decode OpenAPI document - error = %v", err)
+		}
+
+		if _, ok := doc.Paths["/v1/bitcoin/CreateKeychain"]; doc.Swagger != "2.0" || !ok {
+			t.Fatalf("got invalid OpenAPI document")
+		}
+	})
+}
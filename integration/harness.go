@@ -8,8 +8,12 @@ import (
 
 	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
 
+	"github.com/ledgerhq/bitcoin-keychain/config"
 	controllers "github.com/ledgerhq/bitcoin-keychain/grpc"
 	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keycache"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/oracle"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/test/bufconn"
@@ -17,6 +21,14 @@ import (
 
 const bufSize = 1024 * 1024
 
+// addressSearchLimit enables the discovery of addresses beyond the
+// observable range, so that it can be tested.
+const addressSearchLimit = 50
+
+// usageOracle is the address usage oracle of DiscoverKeychain, whose used
+// addresses are set by tests.
+var usageOracle = oracle.NewMemory()
+
 var lis *bufconn.Listener
 
 // launch at package initialization
@@ -31,22 +43,39 @@ func init() {
 // gRPC client can use the same connection to dial to the server.
 func startKeychain() {
 	lis = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		metrics.UnaryServerInterceptor,
+		controllers.UnaryErrorInterceptor,
+	))
+
+	// The CoinService implementation is configurable, so that the same suite
+	// can run against bitcoin-lib-grpc or the native derivation.
+	coinService := config.LoadProvider("").GetString("coin_service")
 
-	keychainController, err := controllers.NewKeychainController("redis", &redis.Options{
+	client, err := controllers.NewCoinServiceClient(coinService)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed to init coin service client")
+	}
+
+	keychainController, err := controllers.NewKeychainController("redis", client, &redis.Options{
 		Addr:     "localhost:6379",
 		Password: "", // no password set
 		DB:       0,  // use default DB
-	})
+	}, addressSearchLimit, keycache.DefaultSize)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Fatal("failed to init controller")
 	}
 
+	controllers.SetAddressUsageOracle(usageOracle)
+
 	pb.RegisterKeychainServiceServer(s, keychainController)
 
-	healthCheckerController := controllers.NewHealthChecker()
+	healthCheckerController := controllers.NewHealthChecker(
+		keychainController.Store(), client)
 
 	grpc_health_v1.RegisterHealthServer(s, healthCheckerController)
 
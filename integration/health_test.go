@@ -0,0 +1,92 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	controllers "github.com/ledgerhq/bitcoin-keychain/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	_, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	tests := []struct {
+		name     string
+		service  string
+		want     grpc_health_v1.HealthCheckResponse_ServingStatus
+		wantCode codes.Code
+	}{
+		{
+			name:    "all dependencies",
+			service: "",
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "keychain service",
+			service: "pb.keychain.KeychainService",
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "redis",
+			service: controllers.HealthRedis,
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "coin service",
+			service: controllers.HealthCoinService,
+			want:    grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			name:     "unknown service",
+			service:  "unknown",
+			wantCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{
+				Service: tt.service,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Check() error = %v, want code %v", err, tt.wantCode)
+			}
+
+			if err == nil && resp.Status != tt.want {
+				t.Fatalf("Check() status = %v, want %v", resp.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	stream, err := grpc_health_v1.NewHealthClient(conn).Watch(ctx,
+		&grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("failed to watch health - error = %v", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive health status - error = %v", err)
+	}
+
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("Watch() status = %v, want SERVING", resp.Status)
+	}
+}
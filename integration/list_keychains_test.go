@@ -0,0 +1,114 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+func TestListKeychains(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	// Keychains of other tests are stored in the same database, so the
+	// listing is restricted to a metadata prefix unique to this run.
+	prefix := fmt.Sprintf("list-keychains-%d:", time.Now().UnixNano())
+
+	fixtures := []Fixture{
+		BitcoinMainnetP2PKH,
+		BitcoinTestnet3P2PKH,
+		BitcoinTestnet3P2SHP2WPKH,
+		BitcoinMainnetP2WPKH,
+	}
+
+	want := map[string]bool{}
+
+	for i, fixture := range fixtures {
+		info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+			Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: fixture.ExtendedPublicKey},
+			LookaheadSize: 20,
+			ChainParams:   fixture.ChainParams,
+			Scheme:        fixture.Scheme,
+			Metadata:      fmt.Sprintf("%sworkspace%d", prefix, i%2),
+		})
+		if err != nil {
+			t.Fatalf("failed to create keychain - error = %v", err)
+		}
+
+		want[string(info.KeychainId)] = true
+	}
+
+	tests := []struct {
+		name      string
+		request   *pb.ListKeychainsRequest
+		wantCount int
+	}{
+		{
+			name:      "by metadata prefix",
+			request:   &pb.ListKeychainsRequest{MetadataPrefix: prefix},
+			wantCount: 4,
+		},
+		{
+			name: "by network",
+			request: &pb.ListKeychainsRequest{
+				MetadataPrefix: prefix,
+				ChainParams:    BitcoinTestnet3P2PKH.ChainParams,
+			},
+			wantCount: 2,
+		},
+		{
+			name: "by scheme",
+			request: &pb.ListKeychainsRequest{
+				MetadataPrefix: prefix,
+				Scheme:         pb.Scheme_SCHEME_BIP44,
+			},
+			wantCount: 2,
+		},
+		{
+			name: "by workspace",
+			request: &pb.ListKeychainsRequest{
+				MetadataPrefix: prefix + "workspace1",
+			},
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.PageSize = 1
+
+			got := map[string]bool{}
+
+			for {
+				resp, err := client.ListKeychains(ctx, tt.request)
+				if err != nil {
+					t.Fatalf("failed to list keychains - error = %v", err)
+				}
+
+				for _, info := range resp.Keychains {
+					if !want[string(info.KeychainId)] {
+						t.Fatalf("got unexpected keychain %x", info.KeychainId)
+					}
+
+					got[string(info.KeychainId)] = true
+				}
+
+				if resp.NextCursor == "" {
+					break
+				}
+
+				tt.request.Cursor = resp.NextCursor
+			}
+
+			if len(got) != tt.wantCount {
+				t.Fatalf("got %d keychains, want %d", len(got), tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+)
+
+func TestMarkAddressesAsUsed(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+		LookaheadSize: 20,
+		ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+		Scheme:        BitcoinMainnetP2WPKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	obsAddrs, err := client.GetAllObservableAddresses(ctx, &pb.GetAllObservableAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		FromIndex:  0,
+		ToIndex:    2,
+	})
+	if err != nil {
+		t.Fatalf("failed to get observable addresses - error = %v", err)
+	}
+
+	addrs := obsAddrs.Addresses
+
+	// An address of another keychain is unknown to this one.
+	const unknownAddress = "1918hHSQNsNMRkDCUMy7DUmJ8GJzwfRkUV"
+
+	got, err := client.MarkAddressesAsUsed(ctx, &pb.MarkAddressesAsUsedRequest{
+		KeychainId: info.KeychainId,
+		Addresses:  []string{addrs[0].Address, unknownAddress, addrs[2].Address, addrs[0].Address},
+	})
+	if err != nil {
+		t.Fatalf("MarkAddressesAsUsed() - error = %v", err)
+	}
+
+	want := &pb.MarkAddressesAsUsedResponse{Results: []*pb.MarkAddressResult{
+		{Address: addrs[0].Address, Derivation: addrs[0].Derivation, Status: pb.MarkStatus_MARK_STATUS_MARKED},
+		{Address: unknownAddress, Status: pb.MarkStatus_MARK_STATUS_UNKNOWN_ADDRESS},
+		{Address: addrs[2].Address, Derivation: addrs[2].Derivation, Status: pb.MarkStatus_MARK_STATUS_MARKED},
+		{Address: addrs[0].Address, Derivation: addrs[0].Derivation, Status: pb.MarkStatus_MARK_STATUS_ALREADY_USED},
+	}}
+
+	if !proto.Equal(got, want) {
+		t.Fatalf("MarkAddressesAsUsed() got = '%v', want = '%v'", got, want)
+	}
+
+	// The known addresses are marked despite the unknown one, leaving a gap
+	// at index 1.
+	fresh, err := client.GetFreshAddresses(ctx, &pb.GetFreshAddressesRequest{
+		KeychainId: info.KeychainId,
+		Change:     pb.Change_CHANGE_EXTERNAL,
+		BatchSize:  2,
+	})
+	if err != nil {
+		t.Fatalf("failed to get fresh addresses - error = %v", err)
+	}
+
+	if fresh.Addresses[0].Address != addrs[1].Address {
+		t.Fatalf("GetFreshAddresses() got = '%v', want = '%v'",
+			fresh.Addresses[0].Address, addrs[1].Address)
+	}
+
+	if fresh.Addresses[1].Derivation[1] != 3 {
+		t.Fatalf("GetFreshAddresses() got derivation %v, want [0 3]",
+			fresh.Addresses[1].Derivation)
+	}
+}
@@ -0,0 +1,178 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/keystore"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+)
+
+var redisOpts = &redis.Options{
+	Addr: "localhost:6379",
+}
+
+// storeLegacy replaces a keychain of the redis store by its legacy
+// representation, a single JSON value under the keychain UUID.
+func storeLegacy(
+	t *testing.T, store *keystore.RedisKeystore, meta *keystore.Meta,
+) {
+	if err := store.Delete(context.Background(), meta.Main.ID); err != nil {
+		t.Fatalf("failed to delete keychain - error = %v", err)
+	}
+
+	value, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("failed to marshal legacy keychain - error = %v", err)
+	}
+
+	rdb := redis.NewClient(redisOpts)
+	defer rdb.Close()
+
+	if err := rdb.Set(context.Background(), meta.Main.ID.String(), value, 0).Err(); err != nil {
+		t.Fatalf("failed to store legacy keychain - error = %v", err)
+	}
+}
+
+// legacyExists returns whether the legacy representation of a keychain is
+// stored.
+func legacyExists(t *testing.T, meta *keystore.Meta) bool {
+	rdb := redis.NewClient(redisOpts)
+	defer rdb.Close()
+
+	n, err := rdb.Exists(context.Background(), meta.Main.ID.String()).Result()
+	if err != nil {
+		t.Fatalf("failed to check legacy keychain - error = %v", err)
+	}
+
+	return n == 1
+}
+
+func TestRedisKeystoreLegacyMigration(t *testing.T) {
+	store, err := keystore.NewRedisKeystore(redisOpts, native.NewCoinServiceClient())
+	if err != nil {
+		t.Fatalf("failed to init redis store - error = %v", err)
+	}
+
+	info, err := store.Create(
+		context.Background(), BitcoinMainnetP2WPKH.ExtendedPublicKey, nil, nil, "", "", keystore.BIP84,
+		chaincfg.BitcoinMainnet, 20, 0, "")
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	addrs, err := store.GetAllObservableAddresses(context.Background(), info.ID, keystore.External, 0, 4)
+	if err != nil {
+		t.Fatalf("failed to get observable addresses - error = %v", err)
+	}
+
+	meta := &keystore.Meta{
+		Main:        info,
+		Addresses:   map[string]keystore.DerivationPath{},
+		Derivations: map[keystore.DerivationPath][]string{},
+	}
+
+	var derivations []keystore.DerivationPath
+
+	for _, addr := range addrs {
+		meta.Addresses[addr.Address] = addr.Derivation
+		derivations = append(derivations, addr.Derivation)
+	}
+
+	publicKeys, err := store.GetAddressesPublicKeys(context.Background(), info.ID, derivations)
+	if err != nil {
+		t.Fatalf("failed to get public keys - error = %v", err)
+	}
+
+	for i, derivation := range derivations {
+		meta.Derivations[derivation] = publicKeys[i]
+	}
+
+	checkKeychain := func(t *testing.T) {
+		gotInfo, err := store.Get(context.Background(), info.ID)
+		if err != nil {
+			t.Fatalf("failed to get keychain - error = %v", err)
+		}
+
+		if !reflect.DeepEqual(gotInfo, info) {
+			t.Fatalf("got keychain %+v, want %+v", gotInfo, info)
+		}
+
+		gotPath, err := store.GetDerivationPath(context.Background(), info.ID, addrs[2].Address)
+		if err != nil {
+			t.Fatalf("failed to get derivation path - error = %v", err)
+		}
+
+		if gotPath != addrs[2].Derivation {
+			t.Fatalf("got derivation path %v, want %v", gotPath, addrs[2].Derivation)
+		}
+
+		gotPublicKeys, err := store.GetAddressesPublicKeys(context.Background(), info.ID, derivations)
+		if err != nil {
+			t.Fatalf("failed to get public keys - error = %v", err)
+		}
+
+		if !reflect.DeepEqual(gotPublicKeys, publicKeys) {
+			t.Fatalf("got public keys %v, want %v", gotPublicKeys, publicKeys)
+		}
+	}
+
+	t.Run("read legacy keychain", func(t *testing.T) {
+		storeLegacy(t, store, meta)
+		checkKeychain(t)
+
+		// Polling addresses which are already stored is a read.
+		if _, err := store.GetAllObservableAddresses(context.Background(), info.ID, keystore.External, 0, 4); err != nil {
+			t.Fatalf("failed to get observable addresses - error = %v", err)
+		}
+
+		if _, err := store.GetFreshAddresses(context.Background(), info.ID, keystore.External, 1); err != nil {
+			t.Fatalf("failed to get fresh addresses - error = %v", err)
+		}
+
+		if !legacyExists(t, meta) {
+			t.Fatalf("legacy keychain must not be converted by reads")
+		}
+	})
+
+	t.Run("convert on update", func(t *testing.T) {
+		storeLegacy(t, store, meta)
+
+		if err := store.MarkAddressAsUsed(context.Background(), info.ID, addrs[0].Address); err != nil {
+			t.Fatalf("failed to mark address as used - error = %v", err)
+		}
+
+		if legacyExists(t, meta) {
+			t.Fatalf("legacy keychain must be converted by updates")
+		}
+
+		info.MaxConsecutiveExternalIndex = 1
+		checkKeychain(t)
+		info.MaxConsecutiveExternalIndex = 0
+	})
+
+	t.Run("migrate all", func(t *testing.T) {
+		storeLegacy(t, store, meta)
+
+		count, err := store.MigrateLegacyKeychains(context.Background())
+		if err != nil {
+			t.Fatalf("failed to migrate legacy keychains - error = %v", err)
+		}
+
+		if count < 1 {
+			t.Fatalf("got %d migrated keychains, want at least 1", count)
+		}
+
+		if legacyExists(t, meta) {
+			t.Fatalf("legacy keychain must be converted by migration")
+		}
+
+		checkKeychain(t)
+	})
+}
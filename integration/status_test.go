@@ -0,0 +1,159 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	pb "github.com/ledgerhq/bitcoin-keychain/pb/keychain"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorStatus(t *testing.T) {
+	ctx := context.Background()
+	client, conn := keychainClient(ctx)
+	defer conn.Close()
+
+	info, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+		Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+		LookaheadSize: 20,
+		ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+		Scheme:        BitcoinMainnetP2WPKH.Scheme,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keychain - error = %v", err)
+	}
+
+	unknownID, _ := uuid.New().MarshalBinary()
+
+	tests := []struct {
+		name      string
+		call      func() error
+		wantCode  codes.Code
+		wantField string
+	}{
+		{
+			name: "invalid keychain ID",
+			call: func() error {
+				_, err := client.GetKeychainInfo(ctx, &pb.GetKeychainInfoRequest{
+					KeychainId: []byte("invalid"),
+				})
+				return err
+			},
+			wantCode:  codes.InvalidArgument,
+			wantField: "keychain_id",
+		},
+		{
+			name: "invalid extended public key",
+			call: func() error {
+				_, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+					Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: "xpub"},
+					LookaheadSize: 20,
+					ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+					Scheme:        BitcoinMainnetP2WPKH.Scheme,
+				})
+				return err
+			},
+			wantCode:  codes.InvalidArgument,
+			wantField: "extended_public_key",
+		},
+		{
+			name: "invalid cursor",
+			call: func() error {
+				_, err := client.ListKeychains(ctx, &pb.ListKeychainsRequest{
+					Cursor: "invalid",
+				})
+				return err
+			},
+			wantCode:  codes.InvalidArgument,
+			wantField: "cursor",
+		},
+		{
+			name: "keychain not found",
+			call: func() error {
+				_, err := client.GetKeychainInfo(ctx, &pb.GetKeychainInfoRequest{
+					KeychainId: unknownID,
+				})
+				return err
+			},
+			wantCode:  codes.NotFound,
+			wantField: "keychain",
+		},
+		{
+			name: "invalid path template",
+			call: func() error {
+				_, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+					Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+					LookaheadSize: 20,
+					ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+					Scheme:        BitcoinMainnetP2WPKH.Scheme,
+					PathTemplate:  "0'/*",
+				})
+				return err
+			},
+			wantCode:  codes.InvalidArgument,
+			wantField: "path_template",
+		},
+		{
+			name: "invalid address format",
+			call: func() error {
+				_, err := client.CreateKeychain(ctx, &pb.CreateKeychainRequest{
+					Account:       &pb.CreateKeychainRequest_ExtendedPublicKey{ExtendedPublicKey: BitcoinMainnetP2WPKH.ExtendedPublicKey},
+					LookaheadSize: 20,
+					ChainParams:   BitcoinMainnetP2WPKH.ChainParams,
+					Scheme:        BitcoinMainnetP2WPKH.Scheme,
+					AddressFormat: pb.AddressFormat_ADDRESS_FORMAT_LEGACY,
+				})
+				return err
+			},
+			wantCode:  codes.InvalidArgument,
+			wantField: "address_format",
+		},
+		{
+			name: "derivation not observed",
+			call: func() error {
+				_, err := client.GetAddressesPublicKeys(ctx, &pb.GetAddressesPublicKeysRequest{
+					KeychainId:  info.KeychainId,
+					Derivations: []*pb.DerivationPath{{Derivation: []uint32{0, 1000}}},
+				})
+				return err
+			},
+			wantCode:  codes.FailedPrecondition,
+			wantField: "derivations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(tt.call())
+			if !ok {
+				t.Fatalf("got an error without gRPC status")
+			}
+
+			if st.Code() != tt.wantCode {
+				t.Fatalf("got code %v, want %v - error = %v", st.Code(), tt.wantCode, st.Err())
+			}
+
+			var gotField string
+
+			for _, detail := range st.Details() {
+				switch d := detail.(type) {
+				case *errdetails.BadRequest:
+					gotField = d.FieldViolations[0].Field
+				case *errdetails.ResourceInfo:
+					gotField = d.ResourceType
+				case *errdetails.PreconditionFailure:
+					gotField = d.Violations[0].Subject
+				}
+			}
+
+			if gotField != tt.wantField {
+				t.Fatalf("got error details naming %q, want %q", gotField, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type coinServiceClient struct {
+	client bitcoin.CoinServiceClient
+}
+
+// NewCoinServiceClient wraps a bitcoin.CoinServiceClient, to observe the
+// duration and the status code of every call.
+func NewCoinServiceClient(client bitcoin.CoinServiceClient) bitcoin.CoinServiceClient {
+	return &coinServiceClient{client: client}
+}
+
+// observeCoinService observes a CoinService call started at start.
+func observeCoinService(method string, start time.Time, err error) {
+	coinServiceDuration.
+		WithLabelValues(method, status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+}
+
+func (c *coinServiceClient) ValidateAddress(
+	ctx context.Context,
+	in *bitcoin.ValidateAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.ValidateAddressResponse, error) {
+	start := time.Now()
+	resp, err := c.client.ValidateAddress(ctx, in, opts...)
+	observeCoinService("ValidateAddress", start, err)
+
+	return resp, err
+}
+
+func (c *coinServiceClient) DeriveExtendedKey(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	start := time.Now()
+	resp, err := c.client.DeriveExtendedKey(ctx, in, opts...)
+	observeCoinService("DeriveExtendedKey", start, err)
+
+	return resp, err
+}
+
+func (c *coinServiceClient) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	start := time.Now()
+	resp, err := c.client.DeriveExtendedKeys(ctx, in, opts...)
+	observeCoinService("DeriveExtendedKeys", start, err)
+
+	return resp, err
+}
+
+func (c *coinServiceClient) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	start := time.Now()
+	resp, err := c.client.EncodeAddress(ctx, in, opts...)
+	observeCoinService("EncodeAddress", start, err)
+
+	return resp, err
+}
+
+func (c *coinServiceClient) GetAccountExtendedKey(
+	ctx context.Context,
+	in *bitcoin.GetAccountExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.GetAccountExtendedKeyResponse, error) {
+	start := time.Now()
+	resp, err := c.client.GetAccountExtendedKey(ctx, in, opts...)
+	observeCoinService("GetAccountExtendedKey", start, err)
+
+	return resp, err
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor is a grpc.UnaryServerInterceptor which observes the
+// duration and the status code of every request.
+//
+// It should be the outermost interceptor, so that the status code is the one
+// returned to the client.
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	rpcDuration.
+		WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
@@ -0,0 +1,96 @@
+// Package metrics exposes Prometheus metrics of the keychain service: gRPC
+// requests, address derivations, CoinService calls, redis commands, and the
+// content of the keystore.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is the path of the HTTP endpoint serving the metrics.
+const Path = "/metrics"
+
+const namespace = "keychain"
+
+var (
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of the gRPC requests served, by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	derivations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "derivations_total",
+		Help:      "Number of addresses derived, by scheme.",
+	}, []string{"scheme"})
+
+	keyCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_cache_lookups_total",
+		Help:      "Number of lookups in the derived key cache, by CoinService method and result (hit or miss).",
+	}, []string{"method", "result"})
+
+	coinServiceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "coin_service_duration_seconds",
+		Help:      "Duration of the CoinService calls, by method and status code.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"method", "code"})
+
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_duration_seconds",
+		Help:      "Duration of the redis round-trips, by command. Pipelines and transactions count as one round-trip.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"command"})
+
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Number of failed redis round-trips, by command. Missing keys are not errors.",
+	}, []string{"command"})
+)
+
+// registry holds the metrics of the keychain service, and the ones of the Go
+// runtime and of the process.
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		rpcDuration,
+		derivations,
+		keyCacheLookups,
+		coinServiceDuration,
+		redisDuration,
+		redisErrors,
+		storeStats,
+	)
+}
+
+// Handler returns the HTTP handler serving all metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveDerivation counts an address derived with the given scheme.
+func ObserveDerivation(scheme string) {
+	derivations.WithLabelValues(scheme).Inc()
+}
+
+// ObserveKeyCache counts a lookup in the derived key cache, for a call to
+// the given CoinService method.
+func ObserveKeyCache(method string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	keyCacheLookups.WithLabelValues(method, result).Inc()
+}
@@ -0,0 +1,188 @@
+//go:build !integration
+// +build !integration
+
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type countsStore struct {
+	counts []int
+	err    error
+	calls  int32
+}
+
+func (s *countsStore) AddressCounts(ctx context.Context) ([]int, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.counts, s.err
+}
+
+// setStore selects a keystore with SetStore, and waits for its statistics to
+// be collected.
+func setStore(backend string, store Store) {
+	SetStore(backend, store)
+
+	storeStats.mu.Lock()
+	stop := storeStats.stop
+	storeStats.mu.Unlock()
+
+	storeStats.refresh(backend, store, stop)
+}
+
+// contentType is the media type of the Prometheus text exposition format,
+// served to scrapers without an Accept header.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// scrape returns the metrics served by Handler.
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != contentType {
+		t.Fatalf("Content-Type = %q, want %q", ct, contentType)
+	}
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	ObserveDerivation("BIP84")
+	ObserveDerivation("BIP84")
+	ObserveDerivation("BIP44")
+
+	ObserveKeyCache("EncodeAddress", true)
+	ObserveKeyCache("EncodeAddress", false)
+	ObserveKeyCache("EncodeAddress", true)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.v1.KeychainService/GetKeychainInfo"}
+
+	for _, err := range []error{nil, status.Error(codes.NotFound, "no keychain")} {
+		_, _ = UnaryServerInterceptor(context.Background(), nil, info,
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, err
+			})
+	}
+
+	redisDuration.WithLabelValues("hget").Observe(0.002)
+	redisDuration.WithLabelValues("hget").Observe(2)
+	redisErrors.WithLabelValues(`we"ird\`).Inc()
+
+	store := &countsStore{counts: []int{20, 40, 1200}}
+	setStore("memory", store)
+	defer SetStore("", nil)
+
+	// Wait for the first background collection, besides the one of setStore.
+	for atomic.LoadInt32(&store.calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var body string
+	for i := 0; i < 3; i++ {
+		body = scrape(t)
+	}
+
+	// Keystore statistics are collected in the background, not on scrapes.
+	if got := atomic.LoadInt32(&store.calls); got != 2 {
+		t.Fatalf("AddressCounts() called %d times by scrapes", got-2)
+	}
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{
+			name: "derivations by scheme",
+			line: `keychain_derivations_total{scheme="BIP84"} 2`,
+		},
+		{
+			name: "derivations type",
+			line: "# TYPE keychain_derivations_total counter",
+		},
+		{
+			name: "key cache hits",
+			line: `keychain_key_cache_lookups_total{method="EncodeAddress",result="hit"} 2`,
+		},
+		{
+			name: "rpc ok",
+			line: `keychain_rpc_duration_seconds_count{code="OK",method="GetKeychainInfo"} 1`,
+		},
+		{
+			name: "rpc not found",
+			line: `keychain_rpc_duration_seconds_count{code="NotFound",method="GetKeychainInfo"} 1`,
+		},
+		{
+			name: "cumulative redis bucket",
+			line: `keychain_redis_duration_seconds_bucket{command="hget",le="0.005"} 1`,
+		},
+		{
+			name: "redis +Inf bucket",
+			line: `keychain_redis_duration_seconds_bucket{command="hget",le="+Inf"} 2`,
+		},
+		{
+			name: "redis sum",
+			line: `keychain_redis_duration_seconds_sum{command="hget"} 2.002`,
+		},
+		{
+			name: "escaped label value",
+			line: `keychain_redis_errors_total{command="we\"ird\\"} 1`,
+		},
+		{
+			name: "keychains",
+			line: `keychain_keychains{backend="memory"} 3`,
+		},
+		{
+			name: "addresses",
+			line: `keychain_addresses{backend="memory"} 1260`,
+		},
+		{
+			name: "keychain addresses bucket",
+			line: `keychain_keychain_addresses_bucket{backend="memory",le="40"} 2`,
+		},
+		{
+			name: "keychain addresses beyond buckets",
+			line: `keychain_keychain_addresses_bucket{backend="memory",le="1000"} 2`,
+		},
+		{
+			name: "keychain addresses count",
+			line: `keychain_keychain_addresses_count{backend="memory"} 3`,
+		},
+		{
+			name: "runtime",
+			line: "# TYPE go_goroutines gauge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.line+"\n") {
+				t.Fatalf("metrics do not contain %q:\n%s", tt.line, body)
+			}
+		})
+	}
+}
+
+func TestHandler_StoreError(t *testing.T) {
+	setStore("redis", &countsStore{err: errors.New("connection refused")})
+	defer SetStore("", nil)
+
+	if body := scrape(t); strings.Contains(body, "keychain_keychains") {
+		t.Fatalf("keystore statistics reported despite error:\n%s", body)
+	}
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storeStatsInterval is the period of the collection of the keystore
+// statistics. Collecting them scans the whole keystore, so they are
+// collected in the background, and scrapes report the last collected ones.
+const storeStatsInterval = time.Minute
+
+// storeStatsTimeout bounds each collection of the keystore statistics.
+const storeStatsTimeout = 10 * time.Second
+
+// Store is implemented by keystores reporting statistics about their content.
+type Store interface {
+	// AddressCounts returns the number of addresses derived by every
+	// registered keychain, in any order.
+	AddressCounts(ctx context.Context) ([]int, error)
+}
+
+var storeStats = &storeCollector{
+	keychains: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "keychains"),
+		"Number of keychains registered in the keystore.",
+		[]string{"backend"}, nil),
+	addresses: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "addresses"),
+		"Number of addresses derived by all keychains of the keystore.",
+		[]string{"backend"}, nil),
+	keychainAddresses: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "keychain_addresses"),
+		"Distribution of the number of addresses derived per keychain.",
+		[]string{"backend"}, nil),
+	buckets: []float64{20, 40, 100, 200, 500, 1000, 5000, 10000},
+}
+
+// SetStore selects the keystore whose content is reported, under the given
+// backend label. Its statistics are collected right away, then every
+// storeStatsInterval, until another keystore is selected.
+func SetStore(backend string, store Store) {
+	storeStats.mu.Lock()
+	defer storeStats.mu.Unlock()
+
+	if storeStats.stop != nil {
+		close(storeStats.stop)
+		storeStats.stop = nil
+	}
+
+	storeStats.stats = nil
+
+	if store != nil {
+		storeStats.stop = make(chan struct{})
+		go storeStats.run(backend, store, storeStats.stop)
+	}
+}
+
+// storeCollector is a prometheus.Collector reporting the content of the
+// keystore selected by SetStore.
+type storeCollector struct {
+	keychains         *prometheus.Desc
+	addresses         *prometheus.Desc
+	keychainAddresses *prometheus.Desc
+	buckets           []float64
+
+	mu    sync.Mutex
+	stop  chan struct{} // closed when another keystore is selected
+	stats *storeSnapshot
+}
+
+// storeSnapshot holds the last statistics collected from a keystore.
+type storeSnapshot struct {
+	backend   string
+	keychains int
+	addresses int
+	buckets   map[float64]uint64 // cumulative counts of keychains
+}
+
+// run collects the statistics of store every storeStatsInterval, until stop
+// is closed.
+func (c *storeCollector) run(backend string, store Store, stop chan struct{}) {
+	ticker := time.NewTicker(storeStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		c.refresh(backend, store, stop)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// refresh collects the statistics of store. Statistics are dropped if they
+// can not be collected, rather than reporting stale ones.
+func (c *storeCollector) refresh(backend string, store Store, stop chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeStatsTimeout)
+	defer cancel()
+
+	counts, err := store.AddressCounts(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"backend": backend,
+			"error":   err,
+		}).Error("[metrics] failed to collect keystore statistics")
+	}
+
+	var stats *storeSnapshot
+	if err == nil {
+		stats = c.snapshot(backend, counts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-stop:
+		// Another keystore was selected during the collection.
+	default:
+		c.stats = stats
+	}
+}
+
+func (c *storeCollector) snapshot(backend string, counts []int) *storeSnapshot {
+	stats := &storeSnapshot{
+		backend:   backend,
+		keychains: len(counts),
+		buckets:   make(map[float64]uint64, len(c.buckets)),
+	}
+
+	bucketCounts := make([]uint64, len(c.buckets))
+
+	for _, count := range counts {
+		stats.addresses += count
+
+		if i := sort.SearchFloat64s(c.buckets, float64(count)); i < len(c.buckets) {
+			bucketCounts[i]++
+		}
+	}
+
+	var cumulative uint64
+
+	for i, bound := range c.buckets {
+		cumulative += bucketCounts[i]
+		stats.buckets[bound] = cumulative
+	}
+
+	return stats
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keychains
+	ch <- c.addresses
+	ch <- c.keychainAddresses
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	if stats == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.keychains, prometheus.GaugeValue, float64(stats.keychains), stats.backend)
+	ch <- prometheus.MustNewConstMetric(
+		c.addresses, prometheus.GaugeValue, float64(stats.addresses), stats.backend)
+	ch <- prometheus.MustNewConstHistogram(
+		c.keychainAddresses, uint64(stats.keychains), float64(stats.addresses),
+		stats.buckets, stats.backend)
+}
@@ -1,21 +1,31 @@
 package bitcoin
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/ledgerhq/bitcoin-keychain/config"
 	"github.com/ledgerhq/bitcoin-keychain/log"
 	"google.golang.org/grpc"
 )
 
+// DefaultTimeout bounds every call to the external bitcoin-lib-grpc service,
+// unless another timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
 // NewBitcoinClient creates a new CoinService client by dialing the
 // external bitcoin-lib-grpc gRPC service.
+//
+// Every call is bounded by the configured timeout, in addition to the
+// deadline of its context.
 func NewBitcoinClient() CoinServiceClient {
 	configProvider := config.LoadProvider("bitcoin")
 
 	var (
-		host string = ""
-		port int32  = 50051
+		host    string        = ""
+		port    int32         = 50051
+		timeout time.Duration = DefaultTimeout
 	)
 
 	host = configProvider.GetString("host")
@@ -24,9 +34,15 @@ func NewBitcoinClient() CoinServiceClient {
 		port = val
 	}
 
+	if val := configProvider.GetDuration("timeout"); val != 0 {
+		timeout = val
+	}
+
 	addr := fmt.Sprintf("%s:%d", host, port)
 
-	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	conn, err := grpc.Dial(
+		addr, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(TimeoutInterceptor(timeout)))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -36,3 +52,21 @@ func NewBitcoinClient() CoinServiceClient {
 
 	return NewCoinServiceClient(conn)
 }
+
+// TimeoutInterceptor returns a grpc.UnaryClientInterceptor which bounds the
+// duration of every call by timeout.
+func TimeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,11 @@
+package keychain
+
+import (
+	_ "embed" // for the OpenAPI document
+)
+
+// OpenAPI is the OpenAPI v2 document of the REST API of KeychainService, as
+// served by the grpc-gateway handlers of this package.
+//
+//go:embed service.swagger.json
+var OpenAPI []byte
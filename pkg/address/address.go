@@ -0,0 +1,130 @@
+// Package address serializes public keys to Bitcoin-like addresses, for the
+// networks defined in the chaincfg package.
+package address
+
+import (
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidPublicKey indicates that a serialized public key is not a
+	// valid point on the secp256k1 curve.
+	ErrInvalidPublicKey = errors.New("invalid public key")
+
+	// ErrInvalidBech32 indicates that a string is not a valid bech32
+	// encoded segwit address.
+	ErrInvalidBech32 = errors.New("invalid bech32 address")
+
+	// ErrInvalidAddress indicates that an address is malformed, or does not
+	// belong to the expected network.
+	ErrInvalidAddress = errors.New("invalid address")
+)
+
+// EncodeP2PKH serializes a public key to a Pay-to-PubKey-Hash address.
+func EncodeP2PKH(publicKey []byte, params *chaincfg.Params) (string, error) {
+	pk, err := compressPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return base58.CheckEncode(append([]byte{params.PubKeyHashAddrID}, Hash160(pk)...)), nil
+}
+
+// EncodeP2SHP2WPKH serializes a public key to a Pay-to-Witness-PubKey-Hash
+// address nested in Pay-to-Script-Hash.
+func EncodeP2SHP2WPKH(publicKey []byte, params *chaincfg.Params) (string, error) {
+	pk, err := compressPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Redeem script: OP_0 <20-byte key hash>
+	redeemScript := append([]byte{0x00, 0x14}, Hash160(pk)...)
+
+	return base58.CheckEncode(append([]byte{params.ScriptHashAddrID}, Hash160(redeemScript)...)), nil
+}
+
+// EncodeP2WPKH serializes a public key to a native segwit
+// Pay-to-Witness-PubKey-Hash address.
+func EncodeP2WPKH(publicKey []byte, params *chaincfg.Params) (string, error) {
+	pk, err := compressPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeSegwit(params.Bech32HRPSegwit, 0, Hash160(pk))
+}
+
+// EncodeP2TR serializes a public key to a Pay-to-Taproot address, spendable
+// by key path only, as specified in BIP86.
+func EncodeP2TR(publicKey []byte, params *chaincfg.Params) (string, error) {
+	outputKey, err := taprootOutputKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeSegwit(params.Bech32HRPSegwit, 1, outputKey)
+}
+
+// Validate checks that an address is well-formed and belongs to the network
+// described by params. It returns the address in normalized form.
+//
+// On networks with CashAddr addresses, both CashAddr and base58 addresses are
+// accepted, and returned in their own format.
+func Validate(addr string, params *chaincfg.Params) (string, error) {
+	if params.CashAddrPrefix != "" && isCashAddr(addr) {
+		return ToCashAddr(addr, params)
+	}
+
+	if params.Bech32HRPSegwit != "" && strings.HasPrefix(strings.ToLower(addr), params.Bech32HRPSegwit+"1") {
+		version, program, err := decodeSegwit(params.Bech32HRPSegwit, addr)
+		if err != nil {
+			return "", err
+		}
+
+		if version == 0 && len(program) != 20 && len(program) != 32 {
+			return "", errors.Wrap(ErrInvalidAddress, "invalid witness program length")
+		}
+
+		if version == 1 && len(program) != 32 {
+			return "", errors.Wrap(ErrInvalidAddress, "invalid taproot program length")
+		}
+
+		if version > 1 {
+			return "", errors.Wrapf(ErrInvalidAddress, "unsupported witness version %d", version)
+		}
+
+		return strings.ToLower(addr), nil
+	}
+
+	payload, err := base58.CheckDecode(addr)
+	if err != nil {
+		return "", errors.Wrap(ErrInvalidAddress, err.Error())
+	}
+
+	if len(payload) != 21 {
+		return "", errors.Wrap(ErrInvalidAddress, "invalid payload length")
+	}
+
+	if payload[0] != params.PubKeyHashAddrID && payload[0] != params.ScriptHashAddrID {
+		return "", errors.Wrapf(ErrInvalidAddress, "unexpected version byte %#x", payload[0])
+	}
+
+	return addr, nil
+}
+
+// compressPublicKey returns the compressed serialization of a public key,
+// which may be given in compressed or uncompressed form.
+func compressPublicKey(publicKey []byte) ([]byte, error) {
+	pk, err := secp256k1.ParsePubKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPublicKey, err.Error())
+	}
+
+	return pk.SerializeCompressed(), nil
+}
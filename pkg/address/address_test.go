@@ -0,0 +1,256 @@
+//go:build !integration
+// +build !integration
+
+package address
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+func TestValidate(t *testing.T) {
+	mainnet, _ := chaincfg.GetParams(chaincfg.BitcoinMainnet)
+	testnet, _ := chaincfg.GetParams(chaincfg.BitcoinTestnet3)
+	bch, _ := chaincfg.GetParams(chaincfg.BitcoinCashMainnet)
+
+	tests := []struct {
+		name    string
+		addr    string
+		params  *chaincfg.Params
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "p2pkh",
+			addr:   "151krzHgfkNoH3XHBzEVi6tSn4db7pVjmR",
+			params: mainnet,
+			want:   "151krzHgfkNoH3XHBzEVi6tSn4db7pVjmR",
+		},
+		{
+			name:   "p2sh",
+			addr:   "2MvuUMAG1NFQmmM69Writ6zTsYCnQHFG9BF",
+			params: testnet,
+			want:   "2MvuUMAG1NFQmmM69Writ6zTsYCnQHFG9BF",
+		},
+		{
+			name:   "p2wpkh uppercase",
+			addr:   "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4",
+			params: mainnet,
+			want:   "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		},
+		{
+			name:   "p2tr",
+			addr:   "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+			params: mainnet,
+			want:   "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+		},
+		{
+			name:    "p2tr with bech32 checksum",
+			addr:    "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+			params:  mainnet,
+			wantErr: ErrInvalidBech32,
+		},
+		{
+			name:    "p2pkh wrong network",
+			addr:    "151krzHgfkNoH3XHBzEVi6tSn4db7pVjmR",
+			params:  testnet,
+			wantErr: ErrInvalidAddress,
+		},
+		{
+			name:    "bech32 invalid checksum",
+			addr:    "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+			params:  mainnet,
+			wantErr: ErrInvalidBech32,
+		},
+		{
+			name:   "cashaddr uppercase without prefix",
+			addr:   "QPM2QSZNHKS23Z7629MMS6S4CWEF74VCWVY22GDX6A",
+			params: bch,
+			want:   "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		},
+		{
+			name:   "bitcoin cash p2pkh",
+			addr:   "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu",
+			params: bch,
+			want:   "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu",
+		},
+		{
+			name:    "cashaddr invalid checksum",
+			addr:    "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b",
+			params:  bch,
+			wantErr: ErrInvalidCashAddr,
+		},
+		{
+			name:    "cashaddr wrong network",
+			addr:    "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+			params:  bch,
+			wantErr: ErrInvalidCashAddr,
+		},
+		{
+			name:    "bech32 mixed case",
+			addr:    "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kV8f3t4",
+			params:  mainnet,
+			wantErr: ErrInvalidBech32,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Validate(tt.addr, tt.params)
+			if err != nil && errors.Cause(err) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+
+			if err == nil && tt.wantErr != nil {
+				t.Fatalf("Validate() expected error %v", tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Fatalf("Validate() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The vectors below are the ones of the CashAddr specification.
+func TestCashAddr(t *testing.T) {
+	mainnet, _ := chaincfg.GetParams(chaincfg.BitcoinCashMainnet)
+
+	tests := []struct {
+		name     string
+		legacy   string
+		cashAddr string
+	}{
+		{
+			name:     "p2pkh",
+			legacy:   "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu",
+			cashAddr: "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		},
+		{
+			name:     "p2pkh 2",
+			legacy:   "1KXrWXciRDZUpQwQmuM1DbwsKDLYAYsVLR",
+			cashAddr: "bitcoincash:qr95sy3j9xwd2ap32xkykttr4cvcu7as4y0qverfuy",
+		},
+		{
+			name:     "p2sh",
+			legacy:   "3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC",
+			cashAddr: "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, addr := range []string{tt.legacy, tt.cashAddr} {
+				got, err := ToCashAddr(addr, mainnet)
+				if err != nil || got != tt.cashAddr {
+					t.Fatalf("ToCashAddr() got = %v, %v, want = %v", got, err, tt.cashAddr)
+				}
+
+				got, err = ToLegacy(addr, mainnet)
+				if err != nil || got != tt.legacy {
+					t.Fatalf("ToLegacy() got = %v, %v, want = %v", got, err, tt.legacy)
+				}
+			}
+		})
+	}
+
+	bitcoin, _ := chaincfg.GetParams(chaincfg.BitcoinMainnet)
+
+	if _, err := ToCashAddr(tests[0].legacy, bitcoin); errors.Cause(err) != ErrInvalidAddress {
+		t.Fatalf("ToCashAddr() error = %v, wantErr = %v", err, ErrInvalidAddress)
+	}
+}
+
+func TestEncodeScript(t *testing.T) {
+	mainnet, _ := chaincfg.GetParams(chaincfg.BitcoinMainnet)
+
+	// BIP67 test vector 1, with public keys in lexicographic order.
+	multisig, err := MultisigScript(2, [][]byte{
+		mustDecodeHex("02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f"),
+		mustDecodeHex("02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8"),
+	})
+	if err != nil {
+		t.Fatalf("MultisigScript() unexpected error: %v", err)
+	}
+
+	// BIP173 test vector: <pubkey> OP_CHECKSIG
+	checksig := mustDecodeHex(
+		"210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac")
+
+	tests := []struct {
+		name   string
+		encode func([]byte, *chaincfg.Params) (string, error)
+		script []byte
+		want   string
+	}{
+		{
+			name:   "p2sh multisig",
+			encode: EncodeP2SH,
+			script: multisig,
+			want:   "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z",
+		},
+		{
+			name:   "p2wsh",
+			encode: EncodeP2WSH,
+			script: checksig,
+			want:   "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.encode(tt.script, mainnet)
+			if err != nil {
+				t.Fatalf("encode() unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("encode() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultisigScript(t *testing.T) {
+	key := mustDecodeHex("02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f")
+
+	tests := []struct {
+		name      string
+		threshold int
+		keys      int
+		wantErr   error
+	}{
+		{name: "1-of-1", threshold: 1, keys: 1},
+		{name: "15-of-15", threshold: 15, keys: 15},
+		{name: "no keys", threshold: 1, keys: 0, wantErr: ErrInvalidMultisig},
+		{name: "too many keys", threshold: 1, keys: 16, wantErr: ErrInvalidMultisig},
+		{name: "zero threshold", threshold: 0, keys: 2, wantErr: ErrInvalidMultisig},
+		{name: "threshold above keys", threshold: 3, keys: 2, wantErr: ErrInvalidMultisig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := make([][]byte, tt.keys)
+			for i := range keys {
+				keys[i] = key
+			}
+
+			_, err := MultisigScript(tt.threshold, keys)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("MultisigScript() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
@@ -0,0 +1,217 @@
+package address
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// References:
+//   https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+//   https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Variant is the constant XOR-ed into the checksum, which tells apart
+// bech32 (BIP173) from bech32m (BIP350) strings.
+type bech32Variant uint32
+
+const (
+	// bech32 is used for witness version 0 addresses.
+	bech32 bech32Variant = 1
+
+	// bech32m is used for witness version 1 to 16 addresses.
+	bech32m bech32Variant = 0x2bc830a3
+)
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+
+	for _, c := range []byte(hrp) {
+		ret = append(ret, c>>5)
+	}
+
+	ret = append(ret, 0)
+
+	for _, c := range []byte(hrp) {
+		ret = append(ret, c&31)
+	}
+
+	return ret
+}
+
+func bech32Checksum(hrp string, data []byte, variant bech32Variant) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+
+	mod := polymod(values) ^ uint32(variant)
+
+	ret := make([]byte, 6)
+	for i := range ret {
+		ret[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+
+	return ret
+}
+
+// bech32Encode encodes 5-bit groups of data with a human-readable part.
+func bech32Encode(hrp string, data []byte, variant bech32Variant) string {
+	var sb strings.Builder
+
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+
+	for _, b := range append(data, bech32Checksum(hrp, data, variant)...) {
+		sb.WriteByte(charset[b])
+	}
+
+	return sb.String()
+}
+
+// bech32Decode decodes a bech32 or bech32m string into its human-readable
+// part and its 5-bit data groups, without the checksum. It also returns the
+// variant of the checksum.
+func bech32Decode(s string) (string, []byte, bech32Variant, error) {
+	if len(s) > 90 {
+		return "", nil, 0, errors.Wrap(ErrInvalidBech32, "too long")
+	}
+
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, errors.Wrap(ErrInvalidBech32, "mixed case")
+	}
+
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, 0, errors.Wrap(ErrInvalidBech32, "invalid separator position")
+	}
+
+	hrp := s[:pos]
+
+	data := make([]byte, 0, len(s)-pos-1)
+	for _, c := range []byte(s[pos+1:]) {
+		v := strings.IndexByte(charset, c)
+		if v < 0 {
+			return "", nil, 0, errors.Wrapf(ErrInvalidBech32, "invalid character %q", c)
+		}
+		data = append(data, byte(v))
+	}
+
+	variant := bech32Variant(polymod(append(hrpExpand(hrp), data...)))
+	if variant != bech32 && variant != bech32m {
+		return "", nil, 0, errors.Wrap(ErrInvalidBech32, "invalid checksum")
+	}
+
+	return hrp, data[:len(data)-6], variant, nil
+}
+
+// convertBits regroups a slice of fromBits-bit values into toBits-bit
+// values.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		ret  []byte
+	)
+
+	maxv := uint32(1)<<toBits - 1
+
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.Wrap(ErrInvalidBech32, "invalid data range")
+		}
+
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.Wrap(ErrInvalidBech32, "invalid padding")
+	}
+
+	return ret, nil
+}
+
+// encodeSegwit encodes a witness program to a segwit address, using bech32
+// for version 0 and bech32m for later versions.
+func encodeSegwit(hrp string, version byte, program []byte) (string, error) {
+	if hrp == "" {
+		return "", errors.Wrap(ErrInvalidAddress, "no segwit addresses on this network")
+	}
+
+	data, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	variant := bech32
+	if version > 0 {
+		variant = bech32m
+	}
+
+	return bech32Encode(hrp, append([]byte{version}, data...), variant), nil
+}
+
+// decodeSegwit decodes a segwit address into its witness version and
+// program, checking it against the expected human-readable part.
+func decodeSegwit(hrp string, addr string) (byte, []byte, error) {
+	gotHRP, data, variant, err := bech32Decode(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if gotHRP != hrp {
+		return 0, nil, errors.Wrapf(ErrInvalidBech32, "unexpected hrp %s", gotHRP)
+	}
+
+	if len(data) < 1 {
+		return 0, nil, errors.Wrap(ErrInvalidBech32, "missing witness version")
+	}
+
+	if version := data[0]; version > 16 {
+		return 0, nil, errors.Wrapf(ErrInvalidBech32, "invalid witness version %d", version)
+	} else if (version == 0) != (variant == bech32) {
+		return 0, nil, errors.Wrapf(ErrInvalidBech32,
+			"invalid checksum variant for witness version %d", version)
+	}
+
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, errors.Wrap(ErrInvalidBech32, "invalid program length")
+	}
+
+	return data[0], program, nil
+}
@@ -0,0 +1,207 @@
+package address
+
+import (
+	"strings"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+// Reference:
+//   https://github.com/bitcoincashorg/bitcoincash.org/blob/master/spec/cashaddr.md
+
+// ErrInvalidCashAddr indicates that a string is not a valid CashAddr
+// encoded address.
+var ErrInvalidCashAddr = errors.New("invalid cashaddr address")
+
+// CashAddr version bytes of 160-bit hashes, by address type.
+const (
+	cashAddrP2PKH byte = 0 << 3
+	cashAddrP2SH  byte = 1 << 3
+)
+
+var cashAddrGenerator = [5]uint64{
+	0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470,
+}
+
+func cashAddrPolymod(values []byte) uint64 {
+	chk := uint64(1)
+
+	for _, v := range values {
+		top := chk >> 35
+		chk = (chk&0x07ffffffff)<<5 ^ uint64(v)
+
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= cashAddrGenerator[i]
+			}
+		}
+	}
+
+	return chk ^ 1
+}
+
+func cashAddrPrefixExpand(prefix string) []byte {
+	ret := make([]byte, 0, len(prefix)+1)
+
+	for _, c := range []byte(prefix) {
+		ret = append(ret, c&31)
+	}
+
+	return append(ret, 0)
+}
+
+func cashAddrChecksum(prefix string, data []byte) []byte {
+	values := append(cashAddrPrefixExpand(prefix), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	mod := cashAddrPolymod(values)
+
+	ret := make([]byte, 8)
+	for i := range ret {
+		ret[i] = byte(mod>>uint(5*(7-i))) & 31
+	}
+
+	return ret
+}
+
+// encodeCashAddr encodes a version byte and a 160-bit hash to a CashAddr
+// address, with its prefix.
+func encodeCashAddr(prefix string, version byte, hash []byte) (string, error) {
+	data, err := convertBits(append([]byte{version}, hash...), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(prefix)
+	sb.WriteByte(':')
+
+	for _, b := range append(data, cashAddrChecksum(prefix, data)...) {
+		sb.WriteByte(charset[b])
+	}
+
+	return sb.String(), nil
+}
+
+// decodeCashAddr decodes a CashAddr address into its version byte and its
+// 160-bit hash, checking it against the expected prefix. The prefix may be
+// omitted from the address.
+func decodeCashAddr(prefix string, addr string) (byte, []byte, error) {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return 0, nil, errors.Wrap(ErrInvalidCashAddr, "mixed case")
+	}
+
+	addr = strings.ToLower(addr)
+
+	if pos := strings.IndexByte(addr, ':'); pos >= 0 {
+		if addr[:pos] != prefix {
+			return 0, nil, errors.Wrapf(ErrInvalidCashAddr, "unexpected prefix %s", addr[:pos])
+		}
+
+		addr = addr[pos+1:]
+	}
+
+	data := make([]byte, 0, len(addr))
+	for _, c := range []byte(addr) {
+		v := strings.IndexByte(charset, c)
+		if v < 0 {
+			return 0, nil, errors.Wrapf(ErrInvalidCashAddr, "invalid character %q", c)
+		}
+		data = append(data, byte(v))
+	}
+
+	if len(data) < 8 || cashAddrPolymod(append(cashAddrPrefixExpand(prefix), data...)) != 0 {
+		return 0, nil, errors.Wrap(ErrInvalidCashAddr, "invalid checksum")
+	}
+
+	payload, err := convertBits(data[:len(data)-8], 5, 8, false)
+	if err != nil {
+		return 0, nil, errors.Wrap(ErrInvalidCashAddr, err.Error())
+	}
+
+	// Only 160-bit hashes are used by P2PKH and P2SH addresses.
+	if len(payload) != 21 || (payload[0] != cashAddrP2PKH && payload[0] != cashAddrP2SH) {
+		return 0, nil, errors.Wrapf(ErrInvalidCashAddr, "unsupported version byte %#x", payload[0])
+	}
+
+	return payload[0], payload[1:], nil
+}
+
+// ToCashAddr converts a base58 P2PKH or P2SH address to the CashAddr format
+// of the network described by params, such as
+// bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a.
+//
+// Addresses already in CashAddr format are returned in normalized form.
+func ToCashAddr(addr string, params *chaincfg.Params) (string, error) {
+	if params.CashAddrPrefix == "" {
+		return "", errors.Wrapf(ErrInvalidAddress, "no cashaddr format on %s", params.Name)
+	}
+
+	version, hash, err := decodeAnyFormat(addr, params)
+	if err != nil {
+		return "", err
+	}
+
+	cashAddrVersion := cashAddrP2PKH
+	if version == params.ScriptHashAddrID {
+		cashAddrVersion = cashAddrP2SH
+	}
+
+	return encodeCashAddr(params.CashAddrPrefix, cashAddrVersion, hash)
+}
+
+// ToLegacy converts a CashAddr address to the base58 format of the network
+// described by params, such as 1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu.
+//
+// Addresses already in base58 format are returned unchanged, once validated.
+func ToLegacy(addr string, params *chaincfg.Params) (string, error) {
+	version, hash, err := decodeAnyFormat(addr, params)
+	if err != nil {
+		return "", err
+	}
+
+	return base58.CheckEncode(append([]byte{version}, hash...)), nil
+}
+
+// decodeAnyFormat decodes a P2PKH or P2SH address, in base58 or CashAddr
+// format, into its base58 version byte and its 160-bit hash.
+func decodeAnyFormat(addr string, params *chaincfg.Params) (byte, []byte, error) {
+	if params.CashAddrPrefix != "" && isCashAddr(addr) {
+		version, hash, err := decodeCashAddr(params.CashAddrPrefix, addr)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if version == cashAddrP2SH {
+			return params.ScriptHashAddrID, hash, nil
+		}
+
+		return params.PubKeyHashAddrID, hash, nil
+	}
+
+	payload, err := base58.CheckDecode(addr)
+	if err != nil {
+		return 0, nil, errors.Wrap(ErrInvalidAddress, err.Error())
+	}
+
+	if len(payload) != 21 {
+		return 0, nil, errors.Wrap(ErrInvalidAddress, "invalid payload length")
+	}
+
+	if payload[0] != params.PubKeyHashAddrID && payload[0] != params.ScriptHashAddrID {
+		return 0, nil, errors.Wrapf(ErrInvalidAddress, "unexpected version byte %#x", payload[0])
+	}
+
+	return payload[0], payload[1:], nil
+}
+
+// isCashAddr returns true if addr looks like a CashAddr address, with or
+// without prefix. Base58 addresses are told apart by their length, and by the
+// absence of a prefix separator.
+func isCashAddr(addr string) bool {
+	// A 160-bit hash is encoded to 42 characters, checksum included.
+	return strings.Contains(addr, ":") || len(addr) == 42
+}
@@ -0,0 +1,18 @@
+package address
+
+import (
+	"crypto/sha256"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Hash160 computes RIPEMD160(SHA256(b)), as used in P2PKH and P2WPKH
+// outputs, and in BIP32 key fingerprints.
+func Hash160(b []byte) []byte {
+	sha := sha256.Sum256(b)
+
+	h := ripemd160.New()
+	h.Write(sha[:])
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package address
+
+import (
+	"crypto/sha256"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+// MaxMultisigKeys is the maximum number of public keys in a multisig script.
+// It is bounded by the 520 bytes limit of P2SH redeem scripts.
+const MaxMultisigKeys = 15
+
+const (
+	op0             = 0x00
+	op1             = 0x51
+	opCheckMultisig = 0xae
+)
+
+// ErrInvalidMultisig indicates that a multisig threshold or set of public
+// keys is not valid.
+var ErrInvalidMultisig = errors.New("invalid multisig")
+
+// MultisigScript builds a threshold-of-n OP_CHECKMULTISIG script, with the
+// public keys in the given order.
+//
+// Public keys may be given in compressed or uncompressed form, but are always
+// serialized compressed in the script.
+func MultisigScript(threshold int, publicKeys [][]byte) ([]byte, error) {
+	n := len(publicKeys)
+
+	if n < 1 || n > MaxMultisigKeys {
+		return nil, errors.Wrapf(ErrInvalidMultisig, "invalid number of keys %d", n)
+	}
+
+	if threshold < 1 || threshold > n {
+		return nil, errors.Wrapf(ErrInvalidMultisig,
+			"invalid threshold %d for %d keys", threshold, n)
+	}
+
+	script := make([]byte, 0, 3+34*n)
+	script = append(script, byte(op1-1+threshold))
+
+	for _, publicKey := range publicKeys {
+		pk, err := compressPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		script = append(script, byte(len(pk)))
+		script = append(script, pk...)
+	}
+
+	return append(script, byte(op1-1+n), opCheckMultisig), nil
+}
+
+// EncodeP2SH serializes a redeem script to a Pay-to-Script-Hash address.
+func EncodeP2SH(script []byte, params *chaincfg.Params) (string, error) {
+	return base58.CheckEncode(append([]byte{params.ScriptHashAddrID}, Hash160(script)...)), nil
+}
+
+// EncodeP2SHP2WSH serializes a witness script to a Pay-to-Witness-Script-Hash
+// address nested in Pay-to-Script-Hash.
+func EncodeP2SHP2WSH(script []byte, params *chaincfg.Params) (string, error) {
+	hash := sha256.Sum256(script)
+
+	// Redeem script: OP_0 <32-byte script hash>
+	redeemScript := append([]byte{op0, sha256.Size}, hash[:]...)
+
+	return EncodeP2SH(redeemScript, params)
+}
+
+// EncodeP2WSH serializes a witness script to a native segwit
+// Pay-to-Witness-Script-Hash address.
+func EncodeP2WSH(script []byte, params *chaincfg.Params) (string, error) {
+	hash := sha256.Sum256(script)
+
+	return encodeSegwit(params.Bech32HRPSegwit, 0, hash[:])
+}
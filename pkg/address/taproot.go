@@ -0,0 +1,67 @@
+package address
+
+import (
+	"crypto/sha256"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/pkg/errors"
+)
+
+// References:
+//   https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki
+//   https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki
+//   https://github.com/bitcoin/bips/blob/master/bip-0086.mediawiki
+
+// taggedHash computes SHA256(SHA256(tag) || SHA256(tag) || msg), as defined
+// in BIP340.
+func taggedHash(tag string, msg ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+
+	for _, m := range msg {
+		h.Write(m)
+	}
+
+	return h.Sum(nil)
+}
+
+// taprootOutputKey computes the x-only output key Q = P + int(t)G of a
+// key-path only Taproot output, where P is the internal key lifted to an even
+// Y coordinate and t = hash_TapTweak(bytes(P)).
+func taprootOutputKey(publicKey []byte) ([]byte, error) {
+	pk, err := secp256k1.ParsePubKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPublicKey, err.Error())
+	}
+
+	// Drop the parity byte of the compressed serialization to get the
+	// x-only internal key.
+	internalKey := pk.SerializeCompressed()[1:]
+
+	var tweak secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(taggedHash("TapTweak", internalKey)); overflow {
+		return nil, errors.Wrap(ErrInvalidPublicKey, "taproot tweak overflow")
+	}
+
+	var internalPoint, tweakPoint, outputPoint secp256k1.JacobianPoint
+	pk.AsJacobian(&internalPoint)
+
+	// lift_x(P) always has an even Y coordinate.
+	if internalPoint.Y.IsOdd() {
+		internalPoint.Y.Negate(1).Normalize()
+	}
+
+	secp256k1.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	secp256k1.AddNonConst(&internalPoint, &tweakPoint, &outputPoint)
+
+	if (outputPoint.X.IsZero() && outputPoint.Y.IsZero()) || outputPoint.Z.IsZero() {
+		return nil, errors.Wrap(ErrInvalidPublicKey, "taproot output key is infinity")
+	}
+
+	outputPoint.ToAffine()
+
+	return secp256k1.NewPublicKey(&outputPoint.X, &outputPoint.Y).SerializeCompressed()[1:], nil
+}
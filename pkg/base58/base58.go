@@ -0,0 +1,118 @@
+// Package base58 implements the base58 and base58check encodings used by
+// Bitcoin for legacy addresses and extended keys.
+package base58
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	// ErrInvalidCharacter indicates that a string contains a character
+	// outside of the base58 alphabet.
+	ErrInvalidCharacter = errors.New("invalid base58 character")
+
+	// ErrInvalidChecksum indicates that the checksum of a base58check string
+	// does not match its payload.
+	ErrInvalidChecksum = errors.New("invalid base58 checksum")
+
+	// ErrInvalidFormat indicates that a base58check string is too short to
+	// hold a checksum.
+	ErrInvalidFormat = errors.New("invalid base58check format")
+)
+
+var (
+	bigRadix = big.NewInt(58)
+	bigZero  = big.NewInt(0)
+)
+
+// Encode encodes a byte slice to a base58 string. Leading zero bytes are
+// preserved as leading '1' characters.
+func Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Cmp(bigZero) > 0 {
+		x.DivMod(x, bigRadix, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, alphabet[0])
+	}
+
+	// Reverse the digits, which were computed least significant first.
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+// Decode decodes a base58 string to a byte slice.
+func Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+
+	for _, c := range []byte(s) {
+		digit := bytes.IndexByte([]byte(alphabet), c)
+		if digit < 0 {
+			return nil, errors.Wrapf(ErrInvalidCharacter, "%q", c)
+		}
+
+		x.Mul(x, bigRadix)
+		x.Add(x, big.NewInt(int64(digit)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
+
+// CheckEncode appends a 4-byte double-SHA256 checksum to the payload, and
+// encodes the result to a base58 string.
+func CheckEncode(payload []byte) string {
+	b := make([]byte, 0, len(payload)+4)
+	b = append(b, payload...)
+	b = append(b, checksum(payload)...)
+
+	return Encode(b)
+}
+
+// CheckDecode decodes a base58check string, verifies its checksum, and
+// returns the payload without the checksum.
+func CheckDecode(s string) ([]byte, error) {
+	b, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < 4 {
+		return nil, ErrInvalidFormat
+	}
+
+	payload, sum := b[:len(b)-4], b[len(b)-4:]
+	if !bytes.Equal(checksum(payload), sum) {
+		return nil, ErrInvalidChecksum
+	}
+
+	return payload, nil
+}
+
+func checksum(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
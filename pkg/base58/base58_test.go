@@ -0,0 +1,97 @@
+//go:build !integration
+// +build !integration
+
+package base58
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		decoded string // hex
+		encoded string
+	}{
+		{name: "empty", decoded: "", encoded: ""},
+		{name: "leading zeros", decoded: "0000287fb4cd", encoded: "11233QC4"},
+		{name: "hello world", decoded: "68656c6c6f20776f726c64", encoded: "StV1DL6CwTryKyV"},
+		{name: "single zero", decoded: "00", encoded: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, _ := hex.DecodeString(tt.decoded)
+
+			if got := Encode(decoded); got != tt.encoded {
+				t.Fatalf("Encode() got = %v, want = %v", got, tt.encoded)
+			}
+
+			got, err := Decode(tt.encoded)
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(got, decoded) {
+				t.Fatalf("Decode() got = %x, want = %x", got, decoded)
+			}
+		})
+	}
+}
+
+func TestCheckDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    string // hex
+		wantErr error
+	}{
+		{
+			name:    "p2pkh address",
+			encoded: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+			want:    "0077bff20c60e522dfaa3350c39b030a5d004e839a",
+		},
+		{
+			name:    "bad checksum",
+			encoded: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3",
+			wantErr: ErrInvalidChecksum,
+		},
+		{
+			name:    "invalid character",
+			encoded: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN0",
+			wantErr: ErrInvalidCharacter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckDecode(tt.encoded)
+			if err != nil && tt.wantErr == nil {
+				t.Fatalf("CheckDecode() unexpected error: %v", err)
+			}
+
+			if err == nil && tt.wantErr != nil {
+				t.Fatalf("CheckDecode() got no error, want '%v'", tt.wantErr)
+			}
+
+			if err != nil && errors.Cause(err) != tt.wantErr {
+				t.Fatalf("CheckDecode() got error '%v', want '%v'",
+					err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if hex.EncodeToString(got) != tt.want {
+				t.Fatalf("CheckDecode() got = %x, want = %v", got, tt.want)
+			}
+
+			if encoded := CheckEncode(got); encoded != tt.encoded {
+				t.Fatalf("CheckEncode() got = %v, want = %v", encoded, tt.encoded)
+			}
+		})
+	}
+}
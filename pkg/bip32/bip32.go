@@ -0,0 +1,216 @@
+// Package bip32 implements public derivation of BIP32 hierarchical
+// deterministic extended keys.
+//
+// Only extended public keys are supported, since the keychain never handles
+// private key material.
+//
+// Reference:
+//   https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+package bip32
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/pkg/errors"
+)
+
+// HardenedKeyStart is the index of the first hardened child key.
+const HardenedKeyStart = 0x80000000
+
+// serializedKeyLen is the length of a serialized extended key, without the
+// base58check checksum.
+const serializedKeyLen = 4 + 1 + 4 + 4 + 32 + 33
+
+var (
+	// ErrInvalidKeyLen indicates that a serialized extended key does not
+	// have the expected length.
+	ErrInvalidKeyLen = errors.New("invalid extended key length")
+
+	// ErrPrivateKey indicates that a private extended key was given where a
+	// public one is expected.
+	ErrPrivateKey = errors.New("private extended keys are not supported")
+
+	// ErrInvalidPublicKey indicates that the key data of an extended key is
+	// not a valid secp256k1 point.
+	ErrInvalidPublicKey = errors.New("invalid public key")
+
+	// ErrInvalidChainCode indicates that a chain code is not 32 bytes long.
+	ErrInvalidChainCode = errors.New("invalid chain code")
+
+	// ErrDeriveHardened indicates an attempt to derive a hardened child
+	// from an extended public key.
+	ErrDeriveHardened = errors.New("cannot derive a hardened key from a public key")
+
+	// ErrDeriveBeyondMaxDepth indicates an attempt to derive a child beyond
+	// the maximum depth of 255.
+	ErrDeriveBeyondMaxDepth = errors.New("cannot derive a key with more than 255 indexes")
+
+	// ErrInvalidChild indicates that the derivation at a given index yields
+	// an invalid key. This happens with a probability lower than 1 in 2^127.
+	ErrInvalidChild = errors.New("the extended key at this index is invalid")
+)
+
+// ExtendedKey is a BIP32 extended public key.
+type ExtendedKey struct {
+	Version           [4]byte
+	Depth             uint8
+	ParentFingerprint [4]byte
+	ChildNumber       uint32
+	ChainCode         []byte
+	PublicKey         *secp256k1.PublicKey
+}
+
+// NewExtendedKey builds an extended public key from its components. The
+// public key may be serialized in compressed or uncompressed form.
+func NewExtendedKey(
+	version [4]byte, publicKey []byte, chainCode []byte,
+	parentFingerprint [4]byte, depth uint8, childNumber uint32,
+) (*ExtendedKey, error) {
+	pk, err := secp256k1.ParsePubKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPublicKey, err.Error())
+	}
+
+	if len(chainCode) != 32 {
+		return nil, ErrInvalidChainCode
+	}
+
+	return &ExtendedKey{
+		Version:           version,
+		Depth:             depth,
+		ParentFingerprint: parentFingerprint,
+		ChildNumber:       childNumber,
+		ChainCode:         chainCode,
+		PublicKey:         pk,
+	}, nil
+}
+
+// ParseExtendedKey decodes a base58check serialized extended public key.
+//
+// The version bytes are not interpreted, so that any network or SLIP-0132
+// flavour of extended public key is accepted.
+func ParseExtendedKey(key string) (*ExtendedKey, error) {
+	payload, err := base58.CheckDecode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) != serializedKeyLen {
+		return nil, ErrInvalidKeyLen
+	}
+
+	keyData := payload[45:78]
+	if keyData[0] == 0x00 {
+		return nil, ErrPrivateKey
+	}
+
+	pk, err := secp256k1.ParsePubKey(keyData)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPublicKey, err.Error())
+	}
+
+	k := &ExtendedKey{
+		Depth:       payload[4],
+		ChildNumber: binary.BigEndian.Uint32(payload[9:13]),
+		ChainCode:   append([]byte(nil), payload[13:45]...),
+		PublicKey:   pk,
+	}
+
+	copy(k.Version[:], payload[0:4])
+	copy(k.ParentFingerprint[:], payload[5:9])
+
+	return k, nil
+}
+
+// String returns the base58check serialization of the extended key.
+func (k *ExtendedKey) String() string {
+	payload := make([]byte, 0, serializedKeyLen)
+	payload = append(payload, k.Version[:]...)
+	payload = append(payload, k.Depth)
+	payload = append(payload, k.ParentFingerprint[:]...)
+	payload = append(payload, uint32Bytes(k.ChildNumber)...)
+	payload = append(payload, k.ChainCode...)
+	payload = append(payload, k.PublicKey.SerializeCompressed()...)
+
+	return base58.CheckEncode(payload)
+}
+
+// Fingerprint returns the first 4 bytes of the HASH160 of the serialized
+// public key, which identifies the key as the parent of its children.
+func (k *ExtendedKey) Fingerprint() [4]byte {
+	var fp [4]byte
+	copy(fp[:], address.Hash160(k.PublicKey.SerializeCompressed()))
+
+	return fp
+}
+
+// Child derives the non-hardened child extended key at the given index.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if index >= HardenedKeyStart {
+		return nil, ErrDeriveHardened
+	}
+
+	if k.Depth == 255 {
+		return nil, ErrDeriveBeyondMaxDepth
+	}
+
+	// I = HMAC-SHA512(Key = cpar, Data = serP(Kpar) || ser32(i))
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(k.PublicKey.SerializeCompressed())
+	mac.Write(uint32Bytes(index))
+	ilr := mac.Sum(nil)
+
+	// Ki = point(parse256(IL)) + Kpar, which is invalid if parse256(IL) >= n
+	// or if Ki is the point at infinity.
+	var tweak secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(ilr[:32]); overflow {
+		return nil, ErrInvalidChild
+	}
+
+	var tweakPoint, parentPoint, childPoint secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	k.PublicKey.AsJacobian(&parentPoint)
+	secp256k1.AddNonConst(&tweakPoint, &parentPoint, &childPoint)
+
+	if (childPoint.X.IsZero() && childPoint.Y.IsZero()) || childPoint.Z.IsZero() {
+		return nil, ErrInvalidChild
+	}
+
+	childPoint.ToAffine()
+
+	return &ExtendedKey{
+		Version:           k.Version,
+		Depth:             k.Depth + 1,
+		ParentFingerprint: k.Fingerprint(),
+		ChildNumber:       index,
+		ChainCode:         ilr[32:],
+		PublicKey:         secp256k1.NewPublicKey(&childPoint.X, &childPoint.Y),
+	}, nil
+}
+
+// Derive derives the extended key at the given path, relative to the depth
+// of the extended key.
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	child := k
+
+	for _, index := range path {
+		var err error
+		if child, err = child.Child(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return child, nil
+}
+
+func uint32Bytes(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+
+	return b
+}
@@ -0,0 +1,101 @@
+//go:build !integration
+// +build !integration
+
+package bip32
+
+import (
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/base58"
+	"github.com/pkg/errors"
+)
+
+func TestExtendedKeyDerive(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		path    []uint32
+		want    string
+		wantErr error
+	}{
+		{
+			// BIP32 test vector 1, chain m/0H/1/2H/2 -> m/0H/1/2H/2/1000000000
+			name: "bip32 test vector 1",
+			key:  "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			path: []uint32{1000000000},
+			want: "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+		},
+		{
+			name: "empty path",
+			key:  "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			path: []uint32{},
+			want: "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		},
+		{
+			name:    "hardened index",
+			key:     "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			path:    []uint32{HardenedKeyStart},
+			wantErr: ErrDeriveHardened,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseExtendedKey(tt.key)
+			if err != nil {
+				t.Fatalf("ParseExtendedKey() unexpected error: %v", err)
+			}
+
+			child, err := key.Derive(tt.path)
+			if err != nil && errors.Cause(err) != tt.wantErr {
+				t.Fatalf("Derive() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if got := child.String(); got != tt.want {
+				t.Fatalf("Derive() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExtendedKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{
+			name: "public key",
+			key:  "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+		},
+		{
+			name:    "private key",
+			key:     "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+			wantErr: ErrPrivateKey,
+		},
+		{
+			name:    "truncated key",
+			key:     "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMc",
+			wantErr: base58.ErrInvalidChecksum,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseExtendedKey(tt.key)
+
+			switch {
+			case err != nil && errors.Cause(err) != tt.wantErr:
+				t.Fatalf("ParseExtendedKey() error = %v, wantErr = %v", err, tt.wantErr)
+			case err == nil && tt.wantErr != nil:
+				t.Fatalf("ParseExtendedKey() expected error %v", tt.wantErr)
+			case err == nil && key.String() != tt.key:
+				t.Fatalf("String() got = %v, want = %v", key.String(), tt.key)
+			}
+		})
+	}
+}
@@ -7,6 +7,87 @@ const (
 	// BitcoinTestnet3 indicates the current Bitcoin test network
 	BitcoinTestnet3 BitcoinNetwork = "bitcoin_testnet3"
 
+	// BitcoinTestnet4 indicates the Bitcoin test network of BIP94
+	BitcoinTestnet4 BitcoinNetwork = "bitcoin_testnet4"
+
+	// BitcoinSignet indicates the default Bitcoin signet test network
+	BitcoinSignet BitcoinNetwork = "bitcoin_signet"
+
 	// BitcoinRegtest indicates the Bitcoin regression test network
 	BitcoinRegtest BitcoinNetwork = "bitcoin_regtest"
 )
+
+var bitcoinMainnetParams = Params{
+	Name:             BitcoinMainnet,
+	PubKeyHashAddrID: 0x00, // starts with 1
+	ScriptHashAddrID: 0x05, // starts with 3
+	Bech32HRPSegwit:  "bc",
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e}, // xpub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x04, 0x9d, 0x7c, 0xb2}, // ypub
+		P2WPKH:     [4]byte{0x04, 0xb2, 0x47, 0x46}, // zpub
+		P2SHP2WSH:  [4]byte{0x02, 0x95, 0xb4, 0x3f}, // Ypub
+		P2WSH:      [4]byte{0x02, 0xaa, 0x7e, 0xd3}, // Zpub
+	},
+	HDCoinType: 0,
+}
+
+var bitcoinTestnet3Params = Params{
+	Name:             BitcoinTestnet3,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0xc4, // starts with 2
+	Bech32HRPSegwit:  "tb",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x04, 0x4a, 0x52, 0x62}, // upub
+		P2WPKH:     [4]byte{0x04, 0x5f, 0x1c, 0xf6}, // vpub
+		P2SHP2WSH:  [4]byte{0x02, 0x42, 0x89, 0xef}, // Upub
+		P2WSH:      [4]byte{0x02, 0x57, 0x54, 0x83}, // Vpub
+	},
+	HDCoinType: 1,
+}
+
+var bitcoinTestnet4Params = Params{
+	Name:             BitcoinTestnet4,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0xc4, // starts with 2
+	Bech32HRPSegwit:  "tb",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x04, 0x4a, 0x52, 0x62}, // upub
+		P2WPKH:     [4]byte{0x04, 0x5f, 0x1c, 0xf6}, // vpub
+		P2SHP2WSH:  [4]byte{0x02, 0x42, 0x89, 0xef}, // Upub
+		P2WSH:      [4]byte{0x02, 0x57, 0x54, 0x83}, // Vpub
+	},
+	HDCoinType: 1,
+}
+
+var bitcoinSignetParams = Params{
+	Name:             BitcoinSignet,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0xc4, // starts with 2
+	Bech32HRPSegwit:  "tb",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x04, 0x4a, 0x52, 0x62}, // upub
+		P2WPKH:     [4]byte{0x04, 0x5f, 0x1c, 0xf6}, // vpub
+		P2SHP2WSH:  [4]byte{0x02, 0x42, 0x89, 0xef}, // Upub
+		P2WSH:      [4]byte{0x02, 0x57, 0x54, 0x83}, // Vpub
+	},
+	HDCoinType: 1,
+}
+
+var bitcoinRegtestParams = Params{
+	Name:             BitcoinRegtest,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0xc4, // starts with 2
+	Bech32HRPSegwit:  "bcrt",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x04, 0x4a, 0x52, 0x62}, // upub
+		P2WPKH:     [4]byte{0x04, 0x5f, 0x1c, 0xf6}, // vpub
+		P2SHP2WSH:  [4]byte{0x02, 0x42, 0x89, 0xef}, // Upub
+		P2WSH:      [4]byte{0x02, 0x57, 0x54, 0x83}, // Vpub
+	},
+	HDCoinType: 1,
+}
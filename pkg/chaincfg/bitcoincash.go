@@ -0,0 +1,27 @@
+package chaincfg
+
+const (
+	// BitcoinCashMainnet indicates the main Bitcoin Cash network
+	BitcoinCashMainnet BitcoinCashNetwork = "bitcoin_cash_mainnet"
+
+	// BitcoinCashTestnet indicates the current Bitcoin Cash test network
+	BitcoinCashTestnet BitcoinCashNetwork = "bitcoin_cash_testnet"
+)
+
+var bitcoinCashMainnetParams = Params{
+	Name:             BitcoinCashMainnet,
+	PubKeyHashAddrID: 0x00, // starts with 1
+	ScriptHashAddrID: 0x05, // starts with 3
+	CashAddrPrefix:   "bitcoincash",
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e}, // xpub
+	HDCoinType:       145,
+}
+
+var bitcoinCashTestnetParams = Params{
+	Name:             BitcoinCashTestnet,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0xc4, // starts with 2
+	CashAddrPrefix:   "bchtest",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	HDCoinType:       1,
+}
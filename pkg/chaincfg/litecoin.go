@@ -3,4 +3,46 @@ package chaincfg
 const (
 	// LitecoinMainnet indicates the main Litecoin network
 	LitecoinMainnet LitecoinNetwork = "litecoin_mainnet"
+
+	// LitecoinTestnet indicates the current Litecoin test network (testnet4)
+	LitecoinTestnet LitecoinNetwork = "litecoin_testnet"
+
+	// LitecoinRegtest indicates the Litecoin regression test network
+	LitecoinRegtest LitecoinNetwork = "litecoin_regtest"
 )
+
+var litecoinMainnetParams = Params{
+	Name:             LitecoinMainnet,
+	PubKeyHashAddrID: 0x30, // starts with L
+	ScriptHashAddrID: 0x32, // starts with M
+	Bech32HRPSegwit:  "ltc",
+	HDPublicKeyID:    [4]byte{0x01, 0x9d, 0xa4, 0x62}, // Ltub
+	SLIP132HDPublicKeyIDs: SLIP132HDPublicKeyIDs{
+		P2SHP2WPKH: [4]byte{0x01, 0xb2, 0x6e, 0xf6}, // Mtub
+	},
+	HDPublicKeyIDAliases: [][4]byte{
+		{0x04, 0x88, 0xb2, 0x1e}, // xpub
+	},
+	HDCoinType: 2,
+}
+
+var litecoinTestnetParams = Params{
+	Name:             LitecoinTestnet,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0x3a, // starts with Q
+	Bech32HRPSegwit:  "tltc",
+	HDPublicKeyID:    [4]byte{0x04, 0x36, 0xf6, 0xe1}, // ttub
+	HDPublicKeyIDAliases: [][4]byte{
+		{0x04, 0x35, 0x87, 0xcf}, // tpub
+	},
+	HDCoinType: 1,
+}
+
+var litecoinRegtestParams = Params{
+	Name:             LitecoinRegtest,
+	PubKeyHashAddrID: 0x6f, // starts with m or n
+	ScriptHashAddrID: 0x3a, // starts with Q
+	Bech32HRPSegwit:  "rltc",
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+	HDCoinType:       1,
+}
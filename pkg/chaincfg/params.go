@@ -1,5 +1,11 @@
 package chaincfg
 
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
 // Network defines a type alias to represent the network parameters for
 // various currencies.
 type Network = string
@@ -11,3 +17,135 @@ type BitcoinNetwork = Network
 // LitecoinNetwork defines the network (and therefore the chain parameters)
 // that a Litecoin keychain is associated to.
 type LitecoinNetwork = Network
+
+// BitcoinCashNetwork defines the network (and therefore the chain parameters)
+// that a Bitcoin Cash keychain is associated to.
+type BitcoinCashNetwork = Network
+
+// ErrUnrecognizedNetwork indicates that no parameters are defined for the
+// requested Network.
+var ErrUnrecognizedNetwork = errors.New("unrecognized network")
+
+// Params defines the magic numbers of a Network, used to serialize addresses
+// and extended keys.
+type Params struct {
+	// Name is the Network these parameters are defined for.
+	Name Network
+
+	// PubKeyHashAddrID is the version byte of P2PKH addresses.
+	PubKeyHashAddrID byte
+
+	// ScriptHashAddrID is the version byte of P2SH addresses.
+	ScriptHashAddrID byte
+
+	// Bech32HRPSegwit is the human-readable part of segwit addresses. It is
+	// empty for networks without segwit.
+	Bech32HRPSegwit string
+
+	// CashAddrPrefix is the prefix of CashAddr addresses, the standard
+	// address format of Bitcoin Cash. It is empty for other networks.
+	//
+	// Reference:
+	//   https://github.com/bitcoincashorg/bitcoincash.org/blob/master/spec/cashaddr.md
+	CashAddrPrefix string
+
+	// HDPublicKeyID is the standard version of serialized extended public
+	// keys.
+	HDPublicKeyID [4]byte
+
+	// SLIP132HDPublicKeyIDs are the versions of serialized extended public
+	// keys which also tell the output script type of the account.
+	SLIP132HDPublicKeyIDs SLIP132HDPublicKeyIDs
+
+	// HDPublicKeyIDAliases are other versions of serialized extended public
+	// keys found in the wild for the network, such as the Bitcoin xpub used
+	// by Litecoin wallets. They are accepted, and replaced by HDPublicKeyID.
+	HDPublicKeyIDAliases [][4]byte
+
+	// HDCoinType is the BIP44 coin type, used in the hardened coin_type'
+	// level of account derivation paths.
+	//
+	// Reference:
+	//   https://github.com/satoshilabs/slips/blob/master/slip-0044.md
+	HDCoinType uint32
+}
+
+// SLIP132HDPublicKeyIDs defines the SLIP-0132 versions of serialized
+// extended public keys, by output script type. A zero version means that
+// SLIP-0132 does not define any for the network, and that the standard
+// HDPublicKeyID is used instead.
+//
+// Reference:
+//   https://github.com/satoshilabs/slips/blob/master/slip-0132.md
+type SLIP132HDPublicKeyIDs struct {
+	// P2SHP2WPKH is the version of P2WPKH nested in P2SH accounts keys.
+	P2SHP2WPKH [4]byte
+
+	// P2WPKH is the version of native segwit P2WPKH accounts keys.
+	P2WPKH [4]byte
+
+	// P2SHP2WSH is the version of multisig P2WSH nested in P2SH accounts
+	// keys.
+	P2SHP2WSH [4]byte
+
+	// P2WSH is the version of native segwit multisig P2WSH accounts keys.
+	P2WSH [4]byte
+}
+
+// GetParams returns the Params of a given Network.
+func GetParams(net Network) (*Params, error) {
+	params, ok := networkParams[net]
+	if !ok {
+		return nil, errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
+	}
+
+	return params, nil
+}
+
+// NetworkFromHDPublicKeyID returns the Network whose standard extended public
+// key version matches the given version bytes.
+//
+// Several networks may share the same version bytes, like the Bitcoin test
+// networks. In that case, the first one in the following order is returned:
+// mainnets, then testnets, then regtests. Bitcoin Testnet3 is the first
+// testnet, so that keychains of other test networks must name their Network
+// explicitly. Likewise, Bitcoin Cash keys are serialized like Bitcoin ones,
+// so that Bitcoin Cash keychains must always name their Network.
+func NetworkFromHDPublicKeyID(id [4]byte) (Network, error) {
+	for _, net := range networks {
+		if networkParams[net].HDPublicKeyID == id {
+			return net, nil
+		}
+	}
+
+	return "", errors.Wrapf(ErrUnrecognizedNetwork, "extended key version %x", id)
+}
+
+// networks lists all supported networks, by order of preference when
+// inferring a Network from version bytes.
+var networks = []Network{
+	BitcoinMainnet,
+	LitecoinMainnet,
+	BitcoinCashMainnet,
+	BitcoinTestnet3,
+	BitcoinTestnet4,
+	BitcoinSignet,
+	LitecoinTestnet,
+	BitcoinCashTestnet,
+	BitcoinRegtest,
+	LitecoinRegtest,
+}
+
+var networkParams = map[Network]*Params{
+	BitcoinMainnet:  &bitcoinMainnetParams,
+	BitcoinTestnet3: &bitcoinTestnet3Params,
+	BitcoinTestnet4: &bitcoinTestnet4Params,
+	BitcoinSignet:   &bitcoinSignetParams,
+	BitcoinRegtest:  &bitcoinRegtestParams,
+	LitecoinMainnet: &litecoinMainnetParams,
+	LitecoinTestnet: &litecoinTestnetParams,
+	LitecoinRegtest: &litecoinRegtestParams,
+
+	BitcoinCashMainnet: &bitcoinCashMainnetParams,
+	BitcoinCashTestnet: &bitcoinCashTestnetParams,
+}
@@ -0,0 +1,156 @@
+// Package keycache implements a cache of derived keys in front of a
+// bitcoin-lib-grpc CoinService.
+//
+// Child keys are shared by every keychain of the same chain extended key,
+// whatever its scheme or metadata, and by every request polling the
+// addresses of a keychain.
+package keycache
+
+import (
+	"context"
+
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultSize is the default number of child keys cached. An entry takes a
+// few hundred bytes.
+const DefaultSize = 100000
+
+type coinServiceClient struct {
+	bitcoin.CoinServiceClient
+
+	cache *lru
+}
+
+// NewCoinServiceClient wraps a bitcoin.CoinServiceClient, to cache up to
+// size child keys derived one level below an extended key, and the
+// addresses their public keys are encoded to.
+//
+// Other calls are not cached.
+func NewCoinServiceClient(client bitcoin.CoinServiceClient, size int) bitcoin.CoinServiceClient {
+	return &coinServiceClient{
+		CoinServiceClient: client,
+		cache:             newLRU(size),
+	}
+}
+
+func (c *coinServiceClient) DeriveExtendedKey(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	if len(in.Derivation) != 1 {
+		return c.CoinServiceClient.DeriveExtendedKey(ctx, in, opts...)
+	}
+
+	key := childKey{xPub: in.ExtendedKey, index: in.Derivation[0]}
+
+	if child, ok := c.cache.child(key); ok {
+		metrics.ObserveKeyCache("DeriveExtendedKey", true)
+		return cloneChild(child), nil
+	}
+
+	metrics.ObserveKeyCache("DeriveExtendedKey", false)
+
+	child, err := c.CoinServiceClient.DeriveExtendedKey(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.addChild(key, cloneChild(child))
+
+	return child, nil
+}
+
+// DeriveExtendedKeys only requests the child indexes which are not cached.
+func (c *coinServiceClient) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	resp := &bitcoin.DeriveExtendedKeysResponse{
+		Keys: make([]*bitcoin.DeriveExtendedKeyResponse, len(in.ChildIndexes)),
+	}
+
+	var (
+		positions []int
+		misses    []uint32
+	)
+
+	for i, index := range in.ChildIndexes {
+		child, ok := c.cache.child(childKey{xPub: in.ExtendedKey, index: index})
+		metrics.ObserveKeyCache("DeriveExtendedKeys", ok)
+
+		if ok {
+			resp.Keys[i] = cloneChild(child)
+			continue
+		}
+
+		positions = append(positions, i)
+		misses = append(misses, index)
+	}
+
+	if len(misses) == 0 {
+		return resp, nil
+	}
+
+	derived, err := c.CoinServiceClient.DeriveExtendedKeys(ctx, &bitcoin.DeriveExtendedKeysRequest{
+		ExtendedKey:  in.ExtendedKey,
+		ChildIndexes: misses,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the caller report a response without every child key.
+	if len(derived.Keys) != len(misses) {
+		return derived, nil
+	}
+
+	for i, child := range derived.Keys {
+		c.cache.addChild(childKey{xPub: in.ExtendedKey, index: misses[i]}, cloneChild(child))
+		resp.Keys[positions[i]] = child
+	}
+
+	return resp, nil
+}
+
+// EncodeAddress only caches the addresses of the public keys of cached
+// child keys.
+func (c *coinServiceClient) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	chainParams, err := proto.MarshalOptions{Deterministic: true}.Marshal(in.ChainParams)
+	if err != nil {
+		return c.CoinServiceClient.EncodeAddress(ctx, in, opts...)
+	}
+
+	key := addressKey{encoding: in.Encoding, chainParams: string(chainParams)}
+
+	if addr, ok := c.cache.address(in.PublicKey, key); ok {
+		metrics.ObserveKeyCache("EncodeAddress", true)
+		return &bitcoin.EncodeAddressResponse{Address: addr}, nil
+	}
+
+	metrics.ObserveKeyCache("EncodeAddress", false)
+
+	resp, err := c.CoinServiceClient.EncodeAddress(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.addAddress(in.PublicKey, key, resp.Address)
+
+	return resp, nil
+}
+
+// cloneChild copies a child key, so that cached keys are not shared with
+// callers.
+func cloneChild(child *bitcoin.DeriveExtendedKeyResponse) *bitcoin.DeriveExtendedKeyResponse {
+	return proto.Clone(child).(*bitcoin.DeriveExtendedKeyResponse)
+}
@@ -0,0 +1,194 @@
+//go:build !integration
+// +build !integration
+
+package keycache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"google.golang.org/grpc"
+)
+
+const xpub = "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"
+
+var mainnet = &bitcoin.ChainParams{
+	Network: &bitcoin.ChainParams_BitcoinNetwork{
+		BitcoinNetwork: bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET,
+	},
+}
+
+// recordingClient is the native CoinService, recording the child indexes
+// derived and the public keys encoded.
+type recordingClient struct {
+	bitcoin.CoinServiceClient
+
+	derived []uint32
+	encoded int
+}
+
+func newRecordingClient() *recordingClient {
+	return &recordingClient{CoinServiceClient: native.NewCoinServiceClient()}
+}
+
+func (c *recordingClient) DeriveExtendedKey(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	c.derived = append(c.derived, in.Derivation...)
+	return c.CoinServiceClient.DeriveExtendedKey(ctx, in, opts...)
+}
+
+func (c *recordingClient) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	c.derived = append(c.derived, in.ChildIndexes...)
+	return c.CoinServiceClient.DeriveExtendedKeys(ctx, in, opts...)
+}
+
+func (c *recordingClient) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	c.encoded++
+	return c.CoinServiceClient.EncodeAddress(ctx, in, opts...)
+}
+
+func derive(t *testing.T, client bitcoin.CoinServiceClient, derivation ...uint32) *bitcoin.DeriveExtendedKeyResponse {
+	t.Helper()
+
+	child, err := client.DeriveExtendedKey(context.Background(), &bitcoin.DeriveExtendedKeyRequest{
+		ExtendedKey: xpub,
+		Derivation:  derivation,
+	})
+	if err != nil {
+		t.Fatalf("DeriveExtendedKey() unexpected error: %v", err)
+	}
+
+	return child
+}
+
+func encode(t *testing.T, client bitcoin.CoinServiceClient, publicKey []byte) string {
+	t.Helper()
+
+	resp, err := client.EncodeAddress(context.Background(), &bitcoin.EncodeAddressRequest{
+		PublicKey:   publicKey,
+		Encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH,
+		ChainParams: mainnet,
+	})
+	if err != nil {
+		t.Fatalf("EncodeAddress() unexpected error: %v", err)
+	}
+
+	return resp.Address
+}
+
+func TestCoinServiceClient_DeriveExtendedKeys(t *testing.T) {
+	remote := newRecordingClient()
+	client := NewCoinServiceClient(remote, DefaultSize)
+
+	derive(t, client, 1)
+	derive(t, client, 1)
+
+	// Children of more than one level are not cached.
+	derive(t, client, 0, 1)
+
+	resp, err := client.DeriveExtendedKeys(context.Background(), &bitcoin.DeriveExtendedKeysRequest{
+		ExtendedKey:  xpub,
+		ChildIndexes: []uint32{0, 1, 2},
+	})
+	if err != nil {
+		t.Fatalf("DeriveExtendedKeys() unexpected error: %v", err)
+	}
+
+	if want := []uint32{1, 0, 1, 0, 2}; !reflect.DeepEqual(remote.derived, want) {
+		t.Fatalf("derived child indexes %v, want %v", remote.derived, want)
+	}
+
+	for i, child := range resp.Keys {
+		if want := derive(t, native.NewCoinServiceClient(), uint32(i)); !proto.Equal(child, want) {
+			t.Fatalf("DeriveExtendedKeys() got = %v at index %d, want = %v", child, i, want)
+		}
+	}
+
+	// Cached keys are not shared with callers.
+	resp.Keys[1].PublicKey[0] ^= 0xff
+
+	if got := derive(t, client, 1); !proto.Equal(got, derive(t, native.NewCoinServiceClient(), 1)) {
+		t.Fatalf("DeriveExtendedKey() got modified key %v", got)
+	}
+}
+
+func TestCoinServiceClient_EncodeAddress(t *testing.T) {
+	remote := newRecordingClient()
+	client := NewCoinServiceClient(remote, DefaultSize)
+
+	child := derive(t, client, 0)
+
+	want := encode(t, client, child.PublicKey)
+	if got := encode(t, client, child.PublicKey); got != want {
+		t.Fatalf("EncodeAddress() got = %s, want = %s", got, want)
+	}
+
+	if remote.encoded != 1 {
+		t.Fatalf("encoded %d public keys, want 1", remote.encoded)
+	}
+
+	// Addresses of public keys which are not cached are not cached either.
+	other := derive(t, native.NewCoinServiceClient(), 7)
+
+	encode(t, client, other.PublicKey)
+	encode(t, client, other.PublicKey)
+
+	if remote.encoded != 3 {
+		t.Fatalf("encoded %d public keys, want 3", remote.encoded)
+	}
+}
+
+func TestCoinServiceClient_Eviction(t *testing.T) {
+	remote := newRecordingClient()
+	client := NewCoinServiceClient(remote, 2)
+
+	first := derive(t, client, 0)
+	encode(t, client, first.PublicKey)
+
+	derive(t, client, 1)
+	derive(t, client, 0) // 1 is now the least recently used
+	derive(t, client, 2)
+
+	if n := client.(*coinServiceClient).cache.len(); n != 2 {
+		t.Fatalf("cached %d child keys, want 2", n)
+	}
+
+	derive(t, client, 0)
+	derive(t, client, 1)
+
+	if want := []uint32{0, 1, 2, 1}; !reflect.DeepEqual(remote.derived, want) {
+		t.Fatalf("derived child indexes %v, want %v", remote.derived, want)
+	}
+
+	// 0 is still cached with its address, 2 being evicted instead.
+	encode(t, client, first.PublicKey)
+
+	if remote.encoded != 1 {
+		t.Fatalf("encoded %d public keys, want 1", remote.encoded)
+	}
+
+	// Encoding the address of 0 used it, so that it is only evicted, along
+	// with its address, after two other child keys.
+	derive(t, client, 2)
+	derive(t, client, 1)
+	encode(t, client, first.PublicKey)
+
+	if remote.encoded != 2 {
+		t.Fatalf("encoded %d public keys, want 2", remote.encoded)
+	}
+}
@@ -0,0 +1,145 @@
+package keycache
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+)
+
+// childKey identifies a child extended key, one level below a chain
+// extended key.
+type childKey struct {
+	xPub  string
+	index uint32
+}
+
+// addressKey identifies the encoding of a public key to an address: the
+// address encoding, and the serialized chain params.
+type addressKey struct {
+	encoding    bitcoin.AddressEncoding
+	chainParams string
+}
+
+// entry is a derived child key, with the addresses its public key was
+// encoded to.
+type entry struct {
+	key       childKey
+	publicKey string
+	child     *bitcoin.DeriveExtendedKeyResponse
+	addresses map[addressKey]string
+}
+
+// lru is a cache of derived child keys, bounded by the number of child keys.
+// The least recently used child key is evicted first, along with its
+// addresses.
+type lru struct {
+	size int
+
+	mu         sync.Mutex
+	ll         *list.List
+	children   map[childKey]*list.Element
+	publicKeys map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:       size,
+		ll:         list.New(),
+		children:   make(map[childKey]*list.Element),
+		publicKeys: make(map[string]*list.Element),
+	}
+}
+
+// child returns the cached child key of key, if any.
+func (c *lru) child(key childKey) (*bitcoin.DeriveExtendedKeyResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.children[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+
+	return e.Value.(*entry).child, true
+}
+
+// addChild caches the child key of key, evicting the least recently used
+// child key if the cache is full.
+func (c *lru) addChild(key childKey, child *bitcoin.DeriveExtendedKeyResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.children[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	e := c.ll.PushFront(&entry{
+		key:       key,
+		publicKey: string(child.PublicKey),
+		child:     child,
+		addresses: make(map[addressKey]string),
+	})
+
+	c.children[key] = e
+	c.publicKeys[string(child.PublicKey)] = e
+
+	if c.ll.Len() > c.size {
+		c.evict(c.ll.Back())
+	}
+}
+
+// address returns the cached address of a public key, if the child key of
+// the public key is cached.
+func (c *lru) address(publicKey []byte, key addressKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.publicKeys[string(publicKey)]
+	if !ok {
+		return "", false
+	}
+
+	addr, ok := e.Value.(*entry).addresses[key]
+	if ok {
+		c.ll.MoveToFront(e)
+	}
+
+	return addr, ok
+}
+
+// addAddress caches the address of a public key, if the child key of the
+// public key is cached. Addresses of other public keys are not cached, so
+// that the cache stays bounded.
+func (c *lru) addAddress(publicKey []byte, key addressKey, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.publicKeys[string(publicKey)]; ok {
+		e.Value.(*entry).addresses[key] = addr
+	}
+}
+
+func (c *lru) evict(e *list.Element) {
+	c.ll.Remove(e)
+
+	ent := e.Value.(*entry)
+	delete(c.children, ent.key)
+
+	// The same public key may be cached for another chain extended key,
+	// serialized with other version bytes.
+	if c.publicKeys[ent.publicKey] == e {
+		delete(c.publicKeys, ent.publicKey)
+	}
+}
+
+// len returns the number of cached child keys.
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
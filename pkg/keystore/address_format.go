@@ -0,0 +1,96 @@
+package keystore
+
+import (
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+// AddressFormat defines the format of the addresses of a keychain, on
+// networks with several address formats, such as Bitcoin Cash.
+type AddressFormat string
+
+const (
+	// DefaultAddressFormat is the standard address format of the Network:
+	// CashAddr on Bitcoin Cash, the only format of other networks.
+	DefaultAddressFormat AddressFormat = ""
+
+	// LegacyAddressFormat is the base58 format of Bitcoin addresses, still
+	// used by some Bitcoin Cash wallets and exchanges.
+	LegacyAddressFormat AddressFormat = "legacy"
+)
+
+// checkNetwork checks that the addresses of a keychain with the given Scheme
+// and AddressFormat can be encoded on the Network.
+func checkNetwork(scheme Scheme, format AddressFormat, net chaincfg.Network) error {
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case BIP49, BIP84, BIP86, MultisigP2SHP2WSH, MultisigP2WSH:
+		if params.Bech32HRPSegwit == "" {
+			return errors.Wrapf(ErrUnrecognizedScheme,
+				"%s is not supported on %s, which has no segwit", scheme, net)
+		}
+	}
+
+	switch format {
+	case DefaultAddressFormat:
+		return nil
+	case LegacyAddressFormat:
+		if params.CashAddrPrefix == "" {
+			return errors.Wrapf(ErrInvalidAddressFormat,
+				"%s has no other format than the legacy one", net)
+		}
+
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidAddressFormat, "%q", format)
+	}
+}
+
+// formatAddress serializes an address encoded by the CoinService, or an
+// address given by a client, in the AddressFormat of the keychain.
+//
+// Addresses are returned unchanged on networks with a single address format.
+func (info KeychainInfo) formatAddress(addr string) (string, error) {
+	params, err := chaincfg.GetParams(info.Network)
+	if err != nil {
+		return "", err
+	}
+
+	if params.CashAddrPrefix == "" {
+		return addr, nil
+	}
+
+	if info.AddressFormat == LegacyAddressFormat {
+		return address.ToLegacy(addr, params)
+	}
+
+	return address.ToCashAddr(addr, params)
+}
+
+// normalizeAddress returns an address given by a client in the AddressFormat
+// of the keychain, so that it can be looked up in the addresses derived by
+// the keychain. Invalid addresses are returned unchanged, since they are
+// unknown to the keychain anyway.
+func (info KeychainInfo) normalizeAddress(addr string) string {
+	normalized, err := info.formatAddress(addr)
+	if err != nil {
+		return addr
+	}
+
+	return normalized
+}
+
+// normalizeAddresses applies normalizeAddress to a batch of addresses.
+func (info KeychainInfo) normalizeAddresses(addrs []string) []string {
+	normalized := make([]string, len(addrs))
+	for i, addr := range addrs {
+		normalized[i] = info.normalizeAddress(addr)
+	}
+
+	return normalized
+}
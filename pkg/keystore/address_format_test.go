@@ -0,0 +1,128 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+)
+
+func TestInMemoryKeystore_AddressFormat(t *testing.T) {
+	ctx := context.Background()
+	keystore := NewInMemoryKeystore(native.NewCoinServiceClient())
+
+	create := func(t *testing.T, format AddressFormat, net chaincfg.Network) KeychainInfo {
+		t.Helper()
+
+		info, err := keystore.Create(
+			ctx, mockXPub, nil, nil, "", format, BIP44, net,
+			DefaultLookaheadSize, 0, "")
+		if err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		return info
+	}
+
+	addresses := func(t *testing.T, info KeychainInfo) []AddressInfo {
+		t.Helper()
+
+		addrs, err := keystore.GetAllObservableAddresses(ctx, info.ID, External, 0, 4)
+		if err != nil {
+			t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+		}
+
+		return addrs
+	}
+
+	bitcoin := addresses(t, create(t, DefaultAddressFormat, chaincfg.BitcoinMainnet))
+	cashAddr := create(t, DefaultAddressFormat, chaincfg.BitcoinCashMainnet)
+	legacy := create(t, LegacyAddressFormat, chaincfg.BitcoinCashMainnet)
+
+	if cashAddr.ID == legacy.ID || legacy.AddressFormat != LegacyAddressFormat {
+		t.Fatalf("Create() got ID = %v, format = %q", legacy.ID, legacy.AddressFormat)
+	}
+
+	// Bitcoin Cash legacy addresses are the Bitcoin ones, with the same
+	// extended public key.
+	cashAddrs, legacyAddrs := addresses(t, cashAddr), addresses(t, legacy)
+
+	for i := range bitcoin {
+		if legacyAddrs[i].Address != bitcoin[i].Address {
+			t.Fatalf("GetAllObservableAddresses() got = %s, want %s",
+				legacyAddrs[i].Address, bitcoin[i].Address)
+		}
+
+		if !strings.HasPrefix(cashAddrs[i].Address, "bitcoincash:q") {
+			t.Fatalf("GetAllObservableAddresses() got = %s, want a cashaddr address",
+				cashAddrs[i].Address)
+		}
+	}
+
+	// Both formats are accepted by both keychains, and reported as given.
+	for _, info := range []KeychainInfo{cashAddr, legacy} {
+		for _, addr := range []string{cashAddrs[1].Address, legacyAddrs[1].Address} {
+			path, err := keystore.GetDerivationPath(ctx, info.ID, addr)
+			if err != nil || path != (DerivationPath{0, 1}) {
+				t.Fatalf("GetDerivationPath() got = %v, %v, want %v", path, err, DerivationPath{0, 1})
+			}
+		}
+
+		results, err := keystore.MarkAddressesAsUsed(
+			ctx, info.ID, []string{cashAddrs[2].Address, legacyAddrs[3].Address})
+		if err != nil {
+			t.Fatalf("MarkAddressesAsUsed() unexpected error: %v", err)
+		}
+
+		if results[0].Address != cashAddrs[2].Address || results[0].Status != Marked ||
+			results[1].Address != legacyAddrs[3].Address || results[1].Status != Marked {
+			t.Fatalf("MarkAddressesAsUsed() got = %v", results)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		format  AddressFormat
+		scheme  Scheme
+		network chaincfg.Network
+		wantErr error
+	}{
+		{
+			name:    "segwit on bitcoin cash",
+			scheme:  BIP84,
+			network: chaincfg.BitcoinCashMainnet,
+			wantErr: ErrUnrecognizedScheme,
+		},
+		{
+			name:    "legacy on bitcoin",
+			format:  LegacyAddressFormat,
+			scheme:  BIP44,
+			network: chaincfg.BitcoinMainnet,
+			wantErr: ErrInvalidAddressFormat,
+		},
+		{
+			name:    "unknown format",
+			format:  "slp",
+			scheme:  BIP44,
+			network: chaincfg.BitcoinCashMainnet,
+			wantErr: ErrInvalidAddressFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keystore.Create(
+				ctx, mockXPub, nil, nil, "", tt.format, tt.scheme, tt.network,
+				DefaultLookaheadSize, 0, "")
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
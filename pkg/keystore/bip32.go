@@ -3,13 +3,17 @@ package keystore
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
 )
 
-// DerivationPath represents the BIP32 derivation path as an array, relative
-// to the BIP32 path-level 3 (account level).
+// DerivationPath represents the position of an address in the chains of a
+// keychain, as an array of the chain and the address index.
 //
 // Hardened indexes are NOT supported, which is enforced by the uint32 type.
 //
@@ -18,8 +22,11 @@ import (
 //   │ 4 bytes      │ 4 bytes       │
 //   └──────────────┴───────────────┘
 //
-// For example, if the full derivation path is m/44'/0'/0'/1/2, the
-// representation in DerivationPath would be DerivationPath{1, 2}.
+// The change index is the position of the chain in the PathTemplate of the
+// keychain, which gives the actual BIP32 derivation path, see
+// PathTemplate.Path. With the DefaultPathTemplate, both are the same: if the
+// full derivation path is m/44'/0'/0'/1/2, the representation in
+// DerivationPath would be DerivationPath{1, 2}.
 type DerivationPath [2]uint32
 
 func (path DerivationPath) MarshalText() (text []byte, err error) {
@@ -27,24 +34,42 @@ func (path DerivationPath) MarshalText() (text []byte, err error) {
 	return []byte(p), nil
 }
 
-// ChangeIndex returns the change index at BIP32 path-level 4, from a given
-// DerivationPath.
-func (path DerivationPath) ChangeIndex() Change {
-	if path[0] == 0 {
-		return External
+// UnmarshalText parses a DerivationPath serialized by MarshalText, such as
+// "1/2".
+func (path *DerivationPath) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), "/")
+	if len(parts) != 2 {
+		return errors.Wrapf(ErrInvalidDerivationPath, "%q", text)
+	}
+
+	change, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || change > 1 {
+		return errors.Wrapf(ErrInvalidDerivationPath, "%q", text)
+	}
+
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return errors.Wrapf(ErrInvalidDerivationPath, "%q", text)
 	}
 
-	return Internal // path[0] is 1
+	*path = DerivationPath{uint32(change), uint32(index)}
+
+	return nil
 }
 
-// ChangeIndex returns the address index at BIP32 path-level 5, from a given
+// ChangeIndex returns the chain of the keychain, from a given DerivationPath.
+func (path DerivationPath) ChangeIndex() Change {
+	return Change(path[0])
+}
+
+// AddressIndex returns the address index in its chain, from a given
 // DerivationPath.
 func (path DerivationPath) AddressIndex() uint32 {
 	return path[1]
 }
 
-// ToSlice returns the raw derivation path as a uint32 slice. The derivation
-// is relative to the BIP-32 account-level.
+// ToSlice returns the raw derivation path as a uint32 slice. See
+// PathTemplate.Path for the BIP32 derivation path of the address.
 func (path DerivationPath) ToSlice() []uint32 {
 	return []uint32{path[0], path[1]}
 }
@@ -72,28 +97,93 @@ const (
 // index, from a parent extended public key.
 //
 // This helper can only be used to derive one BIP32 level at a time.
-func childKDF(client bitcoin.CoinServiceClient, xPub string, childIndex uint32) (*bitcoin.DeriveExtendedKeyResponse, error) {
+func childKDF(ctx context.Context, client bitcoin.CoinServiceClient, xPub string, childIndex uint32) (*bitcoin.DeriveExtendedKeyResponse, error) {
 	return client.DeriveExtendedKey(
-		context.Background(), &bitcoin.DeriveExtendedKeyRequest{
+		ctx, &bitcoin.DeriveExtendedKeyRequest{
 			ExtendedKey: xPub,
 			Derivation:  []uint32{childIndex},
 		})
 }
 
+// chainXPub derives the extended public key of the chain of template for
+// change, from the extended public key of a keychain. It returns an empty
+// string if the template has no such chain.
+func chainXPub(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	xPub string,
+	template PathTemplate,
+	change Change,
+) (string, error) {
+	if !template.HasChange(change) {
+		return "", nil
+	}
+
+	path, err := template.ChainPath(change)
+	if err != nil {
+		return "", err
+	}
+
+	if len(path) == 0 {
+		return xPub, nil
+	}
+
+	child, err := client.DeriveExtendedKey(
+		ctx, &bitcoin.DeriveExtendedKeyRequest{
+			ExtendedKey: xPub,
+			Derivation:  path,
+		})
+	if err != nil {
+		return "", errors.Wrapf(err,
+			"failed to derive xpub %v at path %v", xPub, path)
+	}
+
+	return child.ExtendedKey, nil
+}
+
+// accountDepth is the BIP32 depth of account-level extended keys, i.e.
+// m / purpose' / coin_type' / account'.
+const accountDepth = 3
+
 // GetAccountExtendedKey is a helper to get extendend key from
 // a public key, a chain code, an account index and a chain params.
-func GetAccountExtendedKey(client bitcoin.CoinServiceClient, net chaincfg.Network, request *FromChainCode) (*bitcoin.GetAccountExtendedKeyResponse, error) {
+//
+// The networks without ChainParams are not supported by bitcoin-lib-grpc, so
+// their extended key is serialized in-process.
+func GetAccountExtendedKey(ctx context.Context, client bitcoin.CoinServiceClient, net chaincfg.Network, request *FromChainCode) (*bitcoin.GetAccountExtendedKeyResponse, error) {
 	chainParams, err := ChainParams(net)
 
 	if err != nil {
-		return nil, err
+		return getLocalAccountExtendedKey(net, request)
 	}
 
 	return client.GetAccountExtendedKey(
-		context.Background(), &bitcoin.GetAccountExtendedKeyRequest{
+		ctx, &bitcoin.GetAccountExtendedKeyRequest{
 			PublicKey:    request.PublicKey,
 			ChainCode:    request.ChainCode,
 			AccountIndex: request.AccountIndex,
 			ChainParams:  chainParams,
 		})
 }
+
+// getLocalAccountExtendedKey is a helper to serialize the account extended
+// key of GetAccountExtendedKey, without calling bitcoin-lib-grpc.
+func getLocalAccountExtendedKey(net chaincfg.Network, request *FromChainCode) (*bitcoin.GetAccountExtendedKeyResponse, error) {
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
+	}
+
+	// The parent fingerprint is unknown, since only the account-level key
+	// material is provided.
+	key, err := bip32.NewExtendedKey(
+		params.HDPublicKeyID, request.PublicKey, request.ChainCode, [4]byte{},
+		accountDepth, bip32.HardenedKeyStart+request.AccountIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bitcoin.GetAccountExtendedKeyResponse{
+		ExtendedKey: key.String(),
+	}, nil
+}
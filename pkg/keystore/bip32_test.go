@@ -0,0 +1,74 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDerivationPath_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    DerivationPath
+		wantErr error
+	}{
+		{
+			name: "external",
+			text: "0/5",
+			want: DerivationPath{0, 5},
+		},
+		{
+			name: "internal",
+			text: "1/4294967295",
+			want: DerivationPath{1, 4294967295},
+		},
+		{
+			name:    "invalid change",
+			text:    "2/0",
+			wantErr: ErrInvalidDerivationPath,
+		},
+		{
+			name:    "missing address index",
+			text:    "0",
+			wantErr: ErrInvalidDerivationPath,
+		},
+		{
+			name:    "too deep",
+			text:    "0/1/2",
+			wantErr: ErrInvalidDerivationPath,
+		},
+		{
+			name:    "not a number",
+			text:    "0/x",
+			wantErr: ErrInvalidDerivationPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got DerivationPath
+
+			err := got.UnmarshalText([]byte(tt.text))
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got != tt.want {
+				t.Fatalf("UnmarshalText() got = %v, want %v", got, tt.want)
+			}
+
+			text, _ := got.MarshalText()
+			if string(text) != tt.text {
+				t.Fatalf("MarshalText() got = %s, want %s", text, tt.text)
+			}
+		})
+	}
+}
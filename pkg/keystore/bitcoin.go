@@ -1,12 +1,14 @@
 package keystore
 
 import (
+	"bytes"
 	"context"
-	"encoding/hex"
 	"fmt"
+	"sort"
 
-	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
 	"github.com/pkg/errors"
 )
@@ -45,6 +47,9 @@ func schemeFromProtoEncoding(encoding bitcoin.AddressEncoding) (Scheme, error) {
 
 // ChainParams is a helper to convert a Network in keystore package to
 // the corresponding *bitcoin.ChainParams value in bitcoin-lib-grpc.
+//
+// Only the networks known to bitcoin-lib-grpc have one, the other ones
+// return ErrUnrecognizedNetwork.
 func ChainParams(net chaincfg.Network) (*bitcoin.ChainParams, error) {
 	switch net {
 	case chaincfg.BitcoinMainnet:
@@ -98,76 +103,131 @@ func networkFromChainParams(params *bitcoin.ChainParams) (chaincfg.Network, erro
 
 // encodeAddress is a helper to serialize a public key to an address, based on
 // the Scheme and Network.
+//
+// Taproot addresses, and the networks without ChainParams, are not supported
+// by bitcoin-lib-grpc, so they are encoded in-process, see
+// encodeLocalAddress.
 func encodeAddress(
+	ctx context.Context,
 	client bitcoin.CoinServiceClient,
 	publicKey []byte,
 	scheme Scheme,
 	net chaincfg.Network,
 ) (string, error) {
-	encoding, err := protoEncodingFromScheme(scheme)
-	if err != nil {
-		return "", err
+	chainParams, err := ChainParams(net)
+	if err != nil || scheme == BIP86 {
+		return encodeLocalAddress(publicKey, scheme, net)
 	}
 
-	chainParams, err := ChainParams(net)
+	encoding, err := protoEncodingFromScheme(scheme)
 	if err != nil {
 		return "", err
 	}
 
 	addr, err := client.EncodeAddress(
-		context.Background(), &bitcoin.EncodeAddressRequest{
+		ctx, &bitcoin.EncodeAddressRequest{
 			PublicKey:   publicKey,
 			Encoding:    encoding,
 			ChainParams: chainParams,
 		})
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return addr.Address, nil
 }
 
-// deriveAddressAtIndex is a helper to derive a child for a registered keychain
-// at the given DerivationPath, and encode the corresponding public key to an
-// address based on the given Scheme and Network.
+// encodeLocalAddress is a helper to serialize a public key to an address,
+// based on the Scheme and Network, without calling bitcoin-lib-grpc.
+func encodeLocalAddress(
+	publicKey []byte,
+	scheme Scheme,
+	net chaincfg.Network,
+) (string, error) {
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return "", errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
+	}
+
+	switch scheme {
+	case BIP44:
+		return address.EncodeP2PKH(publicKey, params)
+	case BIP49:
+		return address.EncodeP2SHP2WPKH(publicKey, params)
+	case BIP84:
+		return address.EncodeP2WPKH(publicKey, params)
+	case BIP86:
+		return address.EncodeP2TR(publicKey, params)
+	default:
+		return "", errors.Wrap(ErrUnrecognizedScheme, fmt.Sprint(scheme))
+	}
+}
+
+// deriveAddress is a helper to derive a child for a registered keychain at
+// the given DerivationPath, and encode the corresponding public key, or the
+// sortedmulti script of a multisig keychain, to an address based on the
+// keychain Scheme and Network.
+//
+// See deriveAddresses to derive many addresses at once.
 func deriveAddress(
+	ctx context.Context,
 	client bitcoin.CoinServiceClient,
 	keychain *Meta,
 	path DerivationPath,
 ) (string, error) {
-	xPub, err := keychain.ChangeXPub(path.ChangeIndex())
+	addrs, err := deriveAddresses(ctx, client, keychain, []DerivationPath{path})
 	if err != nil {
-		return "", errors.Wrapf(err,
-			"failed to get xPub for change index %d", path.ChangeIndex())
+		return "", err
 	}
 
-	child, err := childKDF(client, xPub, path.AddressIndex())
-	if err != nil {
-		return "", errors.Wrapf(err,
-			"failed to derive extended key %s at child index %d",
-			xPub, path.AddressIndex())
-	}
+	return addrs[0], nil
+}
 
-	addr, err := encodeAddress(
-		client, child.PublicKey, keychain.Main.Scheme, keychain.Main.Network)
+// encodeMultisigAddress is a helper to serialize a sortedmulti script of
+// public keys to an address, based on the multisig Scheme and Network.
+//
+// Scripts are not supported by bitcoin-lib-grpc, so the encoding is done
+// in-process.
+func encodeMultisigAddress(
+	publicKeys [][]byte,
+	threshold uint32,
+	scheme Scheme,
+	net chaincfg.Network,
+) (string, error) {
+	params, err := chaincfg.GetParams(net)
 	if err != nil {
-		return "", errors.Wrapf(err,
-			"failed to encode public key %s to %s address on %s",
-			hex.EncodeToString(child.PublicKey), keychain.Main.Scheme,
-			keychain.Main.Network)
+		return "", errors.Wrap(ErrUnrecognizedNetwork, fmt.Sprint(net))
 	}
 
-	log.WithFields(log.Fields{
-		"id":   keychain.Main.ID.String(),
-		"addr": addr,
-		"path": path,
-	}).Debug("[keystore] derive address")
+	// sortedmulti: keys are sorted lexicographically by their compressed
+	// serialization (BIP67).
+	sorted := make([][]byte, len(publicKeys))
+	for i, publicKey := range publicKeys {
+		pk, err := secp256k1.ParsePubKey(publicKey)
+		if err != nil {
+			return "", errors.Wrap(address.ErrInvalidPublicKey, err.Error())
+		}
 
-	// Feed address -> derivation path mapping
-	keychain.Addresses[addr] = path
+		sorted[i] = pk.SerializeCompressed()
+	}
 
-	// Feed derivation path -> public key mapping
-	keychain.Derivations[path] = hex.EncodeToString(child.PublicKey)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
 
-	return addr, nil
+	script, err := address.MultisigScript(int(threshold), sorted)
+	if err != nil {
+		return "", err
+	}
+
+	switch scheme {
+	case MultisigP2SH:
+		return address.EncodeP2SH(script, params)
+	case MultisigP2SHP2WSH:
+		return address.EncodeP2SHP2WSH(script, params)
+	case MultisigP2WSH:
+		return address.EncodeP2WSH(script, params)
+	default:
+		return "", errors.Wrap(ErrUnrecognizedScheme, fmt.Sprint(scheme))
+	}
 }
@@ -0,0 +1,185 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+var errRemoteCall = errors.New("unexpected call to bitcoin-lib-grpc")
+
+// localOnlyClient is a CoinServiceClient which fails on EncodeAddress and
+// GetAccountExtendedKey calls, to check the requests unsupported by
+// bitcoin-lib-grpc are served in-process.
+type localOnlyClient struct {
+	bitcoin.CoinServiceClient
+}
+
+func (c localOnlyClient) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	return nil, errRemoteCall
+}
+
+func (c localOnlyClient) GetAccountExtendedKey(
+	ctx context.Context,
+	in *bitcoin.GetAccountExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.GetAccountExtendedKeyResponse, error) {
+	return nil, errRemoteCall
+}
+
+func TestEncodeAddress_InProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		extendedKey string
+		scheme      Scheme
+		net         chaincfg.Network
+		derivation  []uint32
+		want        string
+	}{
+		{
+			// BIP86 test vector, account m/86'/0'/0'
+			name:        "bitcoin mainnet p2tr receive",
+			extendedKey: "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ",
+			scheme:      BIP86,
+			net:         chaincfg.BitcoinMainnet,
+			derivation:  []uint32{0, 0},
+			want:        "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr",
+		},
+		{
+			name:        "bitcoin mainnet p2tr receive index 1",
+			extendedKey: "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ",
+			scheme:      BIP86,
+			net:         chaincfg.BitcoinMainnet,
+			derivation:  []uint32{0, 1},
+			want:        "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh",
+		},
+		{
+			name:        "bitcoin mainnet p2tr change",
+			extendedKey: "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ",
+			scheme:      BIP86,
+			net:         chaincfg.BitcoinMainnet,
+			derivation:  []uint32{1, 0},
+			want:        "bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7",
+		},
+		{
+			// Bitcoin test networks share the same address versions.
+			name:        "bitcoin signet p2pkh receive",
+			extendedKey: "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+			scheme:      BIP44,
+			net:         chaincfg.BitcoinSignet,
+			derivation:  []uint32{0, 0},
+			want:        "mkpZhYtJu2r87Js3pDiWJDmPte2NRZ8bJV",
+		},
+		{
+			name:        "bitcoin testnet4 p2sh-p2wpkh receive",
+			extendedKey: "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			scheme:      BIP49,
+			net:         chaincfg.BitcoinTestnet4,
+			derivation:  []uint32{0, 0},
+			want:        "2MvuUMAG1NFQmmM69Writ6zTsYCnQHFG9BF",
+		},
+		{
+			// Bitcoin Cash addresses are encoded in the legacy format, which
+			// is the Bitcoin one.
+			name:        "bitcoin cash mainnet p2pkh receive",
+			extendedKey: "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+			scheme:      BIP44,
+			net:         chaincfg.BitcoinCashMainnet,
+			derivation:  []uint32{0, 0},
+			want:        "151krzHgfkNoH3XHBzEVi6tSn4db7pVjmR",
+		},
+		{
+			// Litecoin test networks share the P2PKH version of Bitcoin ones.
+			name:        "litecoin testnet p2pkh receive",
+			extendedKey: "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+			scheme:      BIP44,
+			net:         chaincfg.LitecoinTestnet,
+			derivation:  []uint32{0, 0},
+			want:        "mkpZhYtJu2r87Js3pDiWJDmPte2NRZ8bJV",
+		},
+	}
+
+	ctx := context.Background()
+	client := localOnlyClient{native.NewCoinServiceClient()}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child, err := client.DeriveExtendedKey(ctx, &bitcoin.DeriveExtendedKeyRequest{
+				ExtendedKey: tt.extendedKey,
+				Derivation:  tt.derivation,
+			})
+			if err != nil {
+				t.Fatalf("DeriveExtendedKey() unexpected error: %v", err)
+			}
+
+			got, err := encodeAddress(ctx, client, child.PublicKey, tt.scheme, tt.net)
+			if err != nil {
+				t.Fatalf("encodeAddress() unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("encodeAddress() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+
+	// Schemes and networks supported by bitcoin-lib-grpc are still encoded
+	// remotely.
+	_, err := encodeAddress(ctx, client, nil, BIP84, chaincfg.BitcoinMainnet)
+	if errors.Cause(err) != errRemoteCall {
+		t.Fatalf("encodeAddress() error = %v, wantErr %v", err, errRemoteCall)
+	}
+}
+
+func TestGetAccountExtendedKey_InProcess(t *testing.T) {
+	key, err := bip32.ParseExtendedKey(
+		"tpubDCxX2sYFS5bDkSe5GKKYHjBW7tgyN1R3UchpLJvdbf54ohxeGRtd8MbDUe1cguVHe4vnK68DsuD5MXjxi9EXx16rb9EnNsaF5KT99CinaJz")
+	if err != nil {
+		t.Fatalf("ParseExtendedKey() unexpected error: %v", err)
+	}
+
+	request := &FromChainCode{
+		PublicKey:    key.PublicKey.SerializeCompressed(),
+		ChainCode:    key.ChainCode,
+		AccountIndex: 7,
+	}
+
+	client := localOnlyClient{native.NewCoinServiceClient()}
+
+	resp, err := GetAccountExtendedKey(
+		context.Background(), client, chaincfg.BitcoinSignet, request)
+	if err != nil {
+		t.Fatalf("GetAccountExtendedKey() unexpected error: %v", err)
+	}
+
+	got, err := bip32.ParseExtendedKey(resp.ExtendedKey)
+	if err != nil {
+		t.Fatalf("ParseExtendedKey() unexpected error: %v", err)
+	}
+
+	if got.Depth != accountDepth || got.ChildNumber != bip32.HardenedKeyStart+7 ||
+		!got.PublicKey.IsEqual(key.PublicKey) {
+		t.Fatalf("GetAccountExtendedKey() got = %v, want key material of %v",
+			got, key)
+	}
+
+	// Networks supported by bitcoin-lib-grpc are still served remotely.
+	_, err = GetAccountExtendedKey(
+		context.Background(), client, chaincfg.BitcoinTestnet3, request)
+	if errors.Cause(err) != errRemoteCall {
+		t.Fatalf("GetAccountExtendedKey() error = %v, wantErr %v", err, errRemoteCall)
+	}
+}
@@ -0,0 +1,334 @@
+package keystore
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// derivationConcurrency bounds the number of concurrent CoinService calls
+// made to derive the addresses of a single request.
+var derivationConcurrency = 16
+
+// deriveAddresses derives the addresses of a registered keychain at the
+// given derivation paths, and returns them in the same order.
+//
+// The children of every chain extended public key of the keychain are
+// derived with a single DeriveExtendedKeys call per chain, and public keys
+// are encoded to addresses concurrently. The derivations are then recorded in
+// the keychain, in the order of paths, so that the result does not depend on
+// the order in which calls complete.
+func deriveAddresses(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	keychain *Meta,
+	paths []DerivationPath,
+) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	for _, path := range paths {
+		if err := keychain.checkChange(path.ChangeIndex()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Public keys at each path, one per cosigner.
+	publicKeys := make([][][]byte, len(paths))
+
+	for _, change := range keychain.Main.PathTemplate.Changes() {
+		var (
+			positions []int
+			indexes   []uint32
+		)
+
+		xPubs, err := changeXPubs(keychain, change)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, path := range paths {
+			if path.ChangeIndex() != change {
+				continue
+			}
+
+			// Public keys already known by the keychain are not derived
+			// again.
+			if known, ok := knownPublicKeys(keychain, path, len(xPubs)); ok {
+				publicKeys[i] = known
+				continue
+			}
+
+			positions = append(positions, i)
+			indexes = append(indexes, path.AddressIndex())
+		}
+
+		if len(indexes) == 0 {
+			continue
+		}
+
+		for _, xPub := range xPubs {
+			children, err := deriveChildren(ctx, client, xPub, indexes)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, child := range children {
+				publicKeys[positions[i]] = append(publicKeys[positions[i]], child.PublicKey)
+			}
+		}
+	}
+
+	addrs := make([]string, len(paths))
+
+	if keychain.Main.Scheme.IsMultisig() {
+		// Scripts are encoded in-process, see encodeMultisigAddress.
+		for i := range paths {
+			addr, err := encodeMultisigAddress(
+				publicKeys[i], keychain.Main.Threshold, keychain.Main.Scheme,
+				keychain.Main.Network)
+			if err != nil {
+				return nil, errors.Wrapf(err,
+					"failed to encode public keys %v to %s address on %s",
+					hexPublicKeys(publicKeys[i]), keychain.Main.Scheme,
+					keychain.Main.Network)
+			}
+
+			addrs[i] = addr
+		}
+	} else {
+		err := forEach(ctx, len(paths), func(ctx context.Context, i int) error {
+			addr, err := encodeAddress(
+				ctx, client, publicKeys[i][0], keychain.Main.Scheme,
+				keychain.Main.Network)
+			if err != nil {
+				return errors.Wrapf(err,
+					"failed to encode public key %s to %s address on %s",
+					hex.EncodeToString(publicKeys[i][0]), keychain.Main.Scheme,
+					keychain.Main.Network)
+			}
+
+			addrs[i] = addr
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The CoinService encodes addresses in the legacy format, which is not
+	// the standard one of every network.
+	for i, addr := range addrs {
+		formatted, err := keychain.Main.formatAddress(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to format address %s", addr)
+		}
+
+		addrs[i] = formatted
+	}
+
+	for i, path := range paths {
+		log.WithFields(log.Fields{
+			"id":   keychain.Main.ID.String(),
+			"addr": addrs[i],
+			"path": path,
+		}).Debug("[keystore] derive address")
+
+		metrics.ObserveDerivation(string(keychain.Main.Scheme))
+
+		// Feed address -> derivation path mapping
+		keychain.Addresses[addrs[i]] = path
+
+		// Feed derivation path -> public keys mapping, in cosigner order
+		keychain.Derivations[path] = hexPublicKeys(publicKeys[i])
+	}
+
+	return addrs, nil
+}
+
+// knownPublicKeys returns the public keys recorded by the keychain at path,
+// if there is one for each of the n extended public keys of the keychain.
+func knownPublicKeys(keychain *Meta, path DerivationPath, n int) ([][]byte, bool) {
+	hexKeys, ok := keychain.Derivations[path]
+	if !ok || len(hexKeys) != n {
+		return nil, false
+	}
+
+	publicKeys := make([][]byte, n)
+
+	for i, hexKey := range hexKeys {
+		publicKey, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, false
+		}
+
+		publicKeys[i] = publicKey
+	}
+
+	return publicKeys, true
+}
+
+// changeXPubs returns the extended public keys of a keychain for the
+// specified Change: the keys of all cosigners of a multisig keychain, or
+// the single key of any other keychain.
+func changeXPubs(keychain *Meta, change Change) ([]string, error) {
+	if keychain.Main.Scheme.IsMultisig() {
+		xPubs, err := keychain.ChangeXPubs(change)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to get xPubs for change index %d", change)
+		}
+
+		return xPubs, nil
+	}
+
+	xPub, err := keychain.ChangeXPub(change)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to get xPub for change index %d", change)
+	}
+
+	return []string{xPub}, nil
+}
+
+// deriveChildren derives the children of an extended public key at the
+// given child indexes, and returns them in the same order.
+//
+// CoinService implementations without DeriveExtendedKeys, such as older
+// versions of bitcoin-lib-grpc, are supported by deriving each child with
+// concurrent DeriveExtendedKey calls.
+func deriveChildren(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	xPub string,
+	indexes []uint32,
+) ([]*bitcoin.DeriveExtendedKeyResponse, error) {
+	if len(indexes) > 1 {
+		resp, err := client.DeriveExtendedKeys(ctx, &bitcoin.DeriveExtendedKeysRequest{
+			ExtendedKey:  xPub,
+			ChildIndexes: indexes,
+		})
+
+		switch {
+		case err == nil:
+			if len(resp.Keys) != len(indexes) {
+				return nil, errors.Wrapf(ErrInvalidDerivations,
+					"%d keys for %d child indexes", len(resp.Keys), len(indexes))
+			}
+
+			return resp.Keys, nil
+		case status.Code(err) != codes.Unimplemented:
+			return nil, errors.Wrapf(err,
+				"failed to derive extended key %s at child indexes %v",
+				xPub, indexes)
+		}
+	}
+
+	children := make([]*bitcoin.DeriveExtendedKeyResponse, len(indexes))
+
+	err := forEach(ctx, len(indexes), func(ctx context.Context, i int) error {
+		child, err := childKDF(ctx, client, xPub, indexes[i])
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to derive extended key %s at child index %d",
+				xPub, indexes[i])
+		}
+
+		children[i] = child
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+// forEach calls fn for every index in [0, n), with at most
+// derivationConcurrency concurrent calls.
+//
+// The first error is returned, after cancelling the context of the calls
+// still running and skipping the remaining indexes.
+func forEach(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	if n == 1 {
+		return fn(ctx, 0)
+	}
+
+	workers := derivationConcurrency
+	if n < workers {
+		workers = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	indexes := make(chan int)
+	fed := 0
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for ; fed < n; fed++ {
+		select {
+		case indexes <- fed:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// Without error, indexes are only skipped if the parent context is done.
+	if fed < n {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// hexPublicKeys hex-encodes serialized public keys.
+func hexPublicKeys(publicKeys [][]byte) []string {
+	encoded := make([]string, len(publicKeys))
+
+	for i, publicKey := range publicKeys {
+		encoded[i] = hex.EncodeToString(publicKey)
+	}
+
+	return encoded
+}
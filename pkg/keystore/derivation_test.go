@@ -0,0 +1,237 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubCoinService is a local CoinService, simulating the latency of a
+// round-trip to bitcoin-lib-grpc on every call.
+type stubCoinService struct {
+	bitcoin.CoinServiceClient
+
+	latency time.Duration
+
+	// unbatched rejects DeriveExtendedKeys calls, as older versions of
+	// bitcoin-lib-grpc do.
+	unbatched bool
+
+	calls int64
+}
+
+func newStubCoinService(latency time.Duration, unbatched bool) *stubCoinService {
+	return &stubCoinService{
+		CoinServiceClient: native.NewCoinServiceClient(),
+		latency:           latency,
+		unbatched:         unbatched,
+	}
+}
+
+func (c *stubCoinService) roundTrip() {
+	atomic.AddInt64(&c.calls, 1)
+	time.Sleep(c.latency)
+}
+
+func (c *stubCoinService) DeriveExtendedKey(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	c.roundTrip()
+	return c.CoinServiceClient.DeriveExtendedKey(ctx, in, opts...)
+}
+
+func (c *stubCoinService) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	c.roundTrip()
+
+	if c.unbatched {
+		return nil, status.Error(codes.Unimplemented, "unknown method DeriveExtendedKeys")
+	}
+
+	return c.CoinServiceClient.DeriveExtendedKeys(ctx, in, opts...)
+}
+
+func (c *stubCoinService) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	c.roundTrip()
+	return c.CoinServiceClient.EncodeAddress(ctx, in, opts...)
+}
+
+// createStubKeychain creates a BIP84 keychain of mockXPub, served by client,
+// with 100 observable addresses per chain.
+func createStubKeychain(t testing.TB, client bitcoin.CoinServiceClient) (Keystore, uuid.UUID) {
+	keystore := NewInMemoryKeystore(client)
+
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet,
+		100, 0, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	return keystore, info.ID
+}
+
+func TestDeriveAddresses(t *testing.T) {
+	ctx := context.Background()
+
+	// Addresses derived one at a time, which batched derivations must match.
+	serial, serialID := createStubKeychain(t, native.NewCoinServiceClient())
+
+	var want []AddressInfo
+
+	for i := uint32(0); i < 40; i++ {
+		addrs, err := serial.GetAllObservableAddresses(ctx, serialID, Internal, i, i)
+		if err != nil {
+			t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+		}
+
+		want = append(want, addrs...)
+	}
+
+	tests := []struct {
+		name      string
+		unbatched bool
+		wantCalls int64
+	}{
+		{
+			name: "batched",
+			// one DeriveExtendedKeys call, and one EncodeAddress call per
+			// address
+			wantCalls: 1 + 40,
+		},
+		{
+			name:      "unbatched",
+			unbatched: true,
+			// one rejected DeriveExtendedKeys call, then one
+			// DeriveExtendedKey and one EncodeAddress call per address
+			wantCalls: 1 + 40 + 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newStubCoinService(0, tt.unbatched)
+			keystore, id := createStubKeychain(t, client)
+
+			atomic.StoreInt64(&client.calls, 0)
+
+			got, err := keystore.GetAllObservableAddresses(ctx, id, Internal, 0, 39)
+			if err != nil {
+				t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("GetAllObservableAddresses() got = %v, want = %v", got, want)
+			}
+
+			if calls := atomic.LoadInt64(&client.calls); calls != tt.wantCalls {
+				t.Fatalf("GetAllObservableAddresses() made %d calls, want %d",
+					calls, tt.wantCalls)
+			}
+
+			// Derivations are recorded, whatever the order of completion.
+			for _, addr := range want {
+				path, err := keystore.GetDerivationPath(ctx, id, addr.Address)
+				if err != nil || path != addr.Derivation {
+					t.Fatalf("GetDerivationPath(%s) got = %v, %v, want = %v",
+						addr.Address, path, err, addr.Derivation)
+				}
+			}
+		})
+	}
+}
+
+func TestForEach(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	var done int64
+
+	err := forEach(context.Background(), 1000, func(ctx context.Context, i int) error {
+		if i == 10 {
+			return errFailed
+		}
+
+		atomic.AddInt64(&done, 1)
+
+		return nil
+	})
+	if err != errFailed {
+		t.Fatalf("forEach() error = %v, wantErr %v", err, errFailed)
+	}
+
+	if n := atomic.LoadInt64(&done); n >= 999 {
+		t.Fatalf("forEach() called fn %d times after an error", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = forEach(ctx, 100, func(ctx context.Context, i int) error { return nil })
+	if err != context.Canceled {
+		t.Fatalf("forEach() error = %v, wantErr %v", err, context.Canceled)
+	}
+}
+
+// BenchmarkGetAllObservableAddresses derives the 100 addresses of a fresh
+// keychain, with a CoinService round-trip of 1ms.
+func BenchmarkGetAllObservableAddresses(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		unbatched   bool
+		concurrency int
+	}{
+		{name: "serial", unbatched: true, concurrency: 1},
+		{name: "parallel", unbatched: true, concurrency: derivationConcurrency},
+		{name: "batched", concurrency: derivationConcurrency},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			defer func(concurrency int) {
+				derivationConcurrency = concurrency
+			}(derivationConcurrency)
+
+			derivationConcurrency = bm.concurrency
+
+			client := newStubCoinService(time.Millisecond, bm.unbatched)
+			keystore, id := createStubKeychain(b, client)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				addrs, err := keystore.GetAllObservableAddresses(
+					context.Background(), id, External, 0, 99)
+				if err != nil {
+					b.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+				}
+
+				if len(addrs) != 100 {
+					b.Fatalf("GetAllObservableAddresses() got %d addresses, want 100", len(addrs))
+				}
+			}
+		})
+	}
+}
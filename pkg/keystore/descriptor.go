@@ -2,26 +2,86 @@ package keystore
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// MakeDescriptor builds output descriptor strings for a given Change path
-// and Scheme.
+// MakeDescriptor builds the checksummed output descriptor string of a given
+// Change path, such as
+//   wpkh([d34db33f/84'/0'/0']xpub.../0/*)#checksum
+//
+// Key origins are only included for keys of desc that have one.
 //
 // References:
 //   https://github.com/bitcoin/bitcoin/blob/master/doc/descriptors.md
 //   https://github.com/bitcoin-core/HWI/blob/master/hwilib/descriptor.py
 //   https://github.com/bitcoin/bitcoin/blob/master/src/script/descriptor.cpp
-func MakeDescriptor(extendedPublicKey string, change Change, scheme Scheme) (string, error) {
-	switch scheme {
-	case BIP44:
-		return fmt.Sprintf("pkh(%s/%d/*)", extendedPublicKey, change), nil
-	case BIP49:
-		return fmt.Sprintf("sh(wpkh(%s/%d/*))", extendedPublicKey, change), nil
-	case BIP84:
-		return fmt.Sprintf("wpkh(%s/%d/*)", extendedPublicKey, change), nil
-	default:
-		return "", errors.Wrapf(ErrUnrecognizedScheme, "%v", scheme)
+func MakeDescriptor(desc *Descriptor, change Change) (string, error) {
+	return makeDescriptor(desc, fmt.Sprint(change))
+}
+
+// MakeMultipathDescriptor builds the checksummed output descriptor string of
+// both the external and internal chains, using a BIP389 multipath expression:
+//   wpkh([d34db33f/84'/0'/0']xpub.../<0;1>/*)#checksum
+//
+// Reference:
+//   https://github.com/bitcoin/bips/blob/master/bip-0389.mediawiki
+func MakeMultipathDescriptor(desc *Descriptor) (string, error) {
+	return makeDescriptor(desc, fmt.Sprintf("<%d;%d>", External, Internal))
+}
+
+// makeDescriptor builds the checksummed output descriptor of desc, where each
+// key expression is followed by /derivation/*.
+func makeDescriptor(desc *Descriptor, derivation string) (string, error) {
+	expr, err := descriptorExpression(desc, derivation)
+	if err != nil {
+		return "", err
+	}
+
+	return AddDescriptorChecksum(expr)
+}
+
+// descriptorExpression builds the output descriptor of desc, without
+// checksum, where each key expression is followed by /derivation/*, or
+// directly by /* if derivation is empty.
+//
+// Keys of multisig descriptors are sorted by extended public key, so that the
+// descriptor does not depend on the order of cosigners, like the script
+// produced by sortedmulti.
+func descriptorExpression(desc *Descriptor, derivation string) (string, error) {
+	for _, t := range descriptorTemplates {
+		if t.scheme != desc.Scheme {
+			continue
+		}
+
+		keys := make([]DescriptorKey, len(desc.Keys))
+		copy(keys, desc.Keys)
+
+		var args []string
+
+		if desc.Scheme.IsMultisig() {
+			sort.SliceStable(keys, func(i, j int) bool {
+				return keys[i].ExtendedPublicKey < keys[j].ExtendedPublicKey
+			})
+
+			args = append(args, fmt.Sprint(desc.Threshold))
+		} else if len(keys) != 1 {
+			return "", errors.Wrapf(ErrInvalidDescriptor,
+				"%s descriptors have exactly one key, got %d", desc.Scheme, len(keys))
+		}
+
+		for _, key := range keys {
+			if derivation == "" {
+				args = append(args, fmt.Sprintf("%s/*", key))
+			} else {
+				args = append(args, fmt.Sprintf("%s/%s/*", key, derivation))
+			}
+		}
+
+		return t.prefix + strings.Join(args, ",") + t.suffix, nil
 	}
+
+	return "", errors.Wrapf(ErrUnrecognizedScheme, "%v", desc.Scheme)
 }
@@ -0,0 +1,113 @@
+package keystore
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Reference:
+//   https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki#checksum
+
+const (
+	descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	descriptorChecksumLen = 8
+)
+
+var descriptorGenerator = [5]uint64{
+	0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd,
+}
+
+func descriptorPolymod(symbols []uint64) uint64 {
+	chk := uint64(1)
+
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= descriptorGenerator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+// DescriptorChecksum computes the 8 characters checksum of an output
+// descriptor, without the '#' separator.
+func DescriptorChecksum(descriptor string) (string, error) {
+	var symbols, groups []uint64
+
+	for _, c := range descriptor {
+		v := strings.IndexRune(descriptorInputCharset, c)
+		if v < 0 {
+			return "", errors.Wrapf(ErrInvalidDescriptor, "invalid character %q", c)
+		}
+
+		symbols = append(symbols, uint64(v&31))
+		groups = append(groups, uint64(v>>5))
+
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+
+	symbols = append(symbols, make([]uint64, descriptorChecksumLen)...)
+	checksum := descriptorPolymod(symbols) ^ 1
+
+	var sb strings.Builder
+	for i := 0; i < descriptorChecksumLen; i++ {
+		sb.WriteByte(descriptorChecksumCharset[(checksum>>(5*uint(7-i)))&31])
+	}
+
+	return sb.String(), nil
+}
+
+// AddDescriptorChecksum appends the '#' separator and checksum to an output
+// descriptor.
+func AddDescriptorChecksum(descriptor string) (string, error) {
+	checksum, err := DescriptorChecksum(descriptor)
+	if err != nil {
+		return "", err
+	}
+
+	return descriptor + "#" + checksum, nil
+}
+
+// stripDescriptorChecksum validates the checksum of an output descriptor, if
+// any, and returns the descriptor without it.
+func stripDescriptorChecksum(descriptor string) (string, error) {
+	pos := strings.LastIndexByte(descriptor, '#')
+	if pos < 0 {
+		return descriptor, nil
+	}
+
+	desc, checksum := descriptor[:pos], descriptor[pos+1:]
+
+	want, err := DescriptorChecksum(desc)
+	if err != nil {
+		return "", err
+	}
+
+	if checksum != want {
+		return "", errors.Wrapf(ErrInvalidDescriptorChecksum,
+			"got %s, want %s", checksum, want)
+	}
+
+	return desc, nil
+}
@@ -0,0 +1,341 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+// KeyOrigin describes where an account-level extended public key comes from:
+// the fingerprint of the master key, and the derivation path from the master
+// key to the account.
+//
+// It is serialized like the key origin of output descriptors, without the
+// brackets. For example: d34db33f/84'/0'/0'
+type KeyOrigin struct {
+	MasterFingerprint [4]byte
+	AccountPath       []uint32 // hardened indexes include bip32.HardenedKeyStart
+}
+
+// ParseKeyOrigin parses a key origin serialized as fingerprint/path. Both '
+// and h are accepted as hardened markers.
+func ParseKeyOrigin(s string) (*KeyOrigin, error) {
+	parts := strings.SplitN(s, "/", 2)
+
+	fingerprint, err := hex.DecodeString(parts[0])
+	if err != nil || len(fingerprint) != 4 {
+		return nil, errors.Wrapf(ErrInvalidKeyOrigin,
+			"invalid master key fingerprint %s", parts[0])
+	}
+
+	origin := &KeyOrigin{}
+	copy(origin.MasterFingerprint[:], fingerprint)
+
+	if len(parts) == 2 {
+		if origin.AccountPath, err = ParseAccountPath(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return origin, nil
+}
+
+// ParseAccountPath parses a BIP32 derivation path from the master key to an
+// account, such as 84'/0'/0'. The m/ prefix is optional, and both ' and h are
+// accepted as hardened markers.
+func ParseAccountPath(s string) ([]uint32, error) {
+	var path []uint32
+
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "m"), "/")
+	if s == "" {
+		return path, nil
+	}
+
+	for _, part := range strings.Split(s, "/") {
+		var offset uint32
+
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			part = part[:len(part)-1]
+			offset = bip32.HardenedKeyStart
+		}
+
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidKeyOrigin,
+				"invalid derivation path %s", s)
+		}
+
+		path = append(path, uint32(index)+offset)
+	}
+
+	return path, nil
+}
+
+// FormatAccountPath serializes a BIP32 derivation path like ParseAccountPath
+// expects it, without the m/ prefix and with ' as hardened marker.
+func FormatAccountPath(path []uint32) string {
+	parts := make([]string, len(path))
+
+	for i, index := range path {
+		if index >= bip32.HardenedKeyStart {
+			parts[i] = fmt.Sprintf("%d'", index-bip32.HardenedKeyStart)
+		} else {
+			parts[i] = fmt.Sprint(index)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// DefaultAccountPath returns the standard account derivation path of a
+// single-key Scheme, i.e. purpose' / coin_type' / account'.
+func DefaultAccountPath(scheme Scheme, net chaincfg.Network, index uint32) ([]uint32, error) {
+	var purpose uint32
+
+	switch scheme {
+	case BIP44:
+		purpose = 44
+	case BIP49:
+		purpose = 49
+	case BIP84:
+		purpose = 84
+	case BIP86:
+		purpose = 86
+	default:
+		return nil, errors.Wrapf(ErrUnrecognizedScheme,
+			"no standard account path for %s", scheme)
+	}
+
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return nil, err
+	}
+
+	return []uint32{
+		bip32.HardenedKeyStart + purpose,
+		bip32.HardenedKeyStart + params.HDCoinType,
+		bip32.HardenedKeyStart + index,
+	}, nil
+}
+
+func (o KeyOrigin) String() string {
+	if len(o.AccountPath) == 0 {
+		return hex.EncodeToString(o.MasterFingerprint[:])
+	}
+
+	return hex.EncodeToString(o.MasterFingerprint[:]) + "/" + FormatAccountPath(o.AccountPath)
+}
+
+func (o KeyOrigin) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *KeyOrigin) UnmarshalText(text []byte) error {
+	origin, err := ParseKeyOrigin(string(text))
+	if err != nil {
+		return err
+	}
+
+	*o = *origin
+
+	return nil
+}
+
+// AccountIndex returns the account index of a BIP44-like account path, which
+// is the last (hardened) index of the path.
+func (o KeyOrigin) AccountIndex() (uint32, bool) {
+	if len(o.AccountPath) == 0 {
+		return 0, false
+	}
+
+	index := o.AccountPath[len(o.AccountPath)-1]
+	if index < bip32.HardenedKeyStart {
+		return 0, false
+	}
+
+	return index - bip32.HardenedKeyStart, true
+}
+
+// DescriptorKey is an account-level key expression of an output descriptor.
+type DescriptorKey struct {
+	Origin            *KeyOrigin // nil if the descriptor has no key origin
+	ExtendedPublicKey string
+}
+
+// String serializes the key expression, with its key origin if any, and
+// without derivation steps.
+func (k DescriptorKey) String() string {
+	if k.Origin == nil {
+		return k.ExtendedPublicKey
+	}
+
+	return "[" + k.Origin.String() + "]" + k.ExtendedPublicKey
+}
+
+// Descriptor models the output descriptors supported by the keychain, once
+// parsed.
+type Descriptor struct {
+	Scheme    Scheme
+	Keys      []DescriptorKey // one key, or all cosigners of multisig descriptors
+	Threshold uint32          // multisig descriptors only
+}
+
+// descriptorTemplates maps the script expressions around key expressions to
+// the corresponding Scheme.
+var descriptorTemplates = []struct {
+	prefix string
+	suffix string
+	scheme Scheme
+}{
+	{prefix: "pkh(", suffix: ")", scheme: BIP44},
+	{prefix: "sh(wpkh(", suffix: "))", scheme: BIP49},
+	{prefix: "wpkh(", suffix: ")", scheme: BIP84},
+	{prefix: "tr(", suffix: ")", scheme: BIP86},
+	{prefix: "sh(sortedmulti(", suffix: "))", scheme: MultisigP2SH},
+	{prefix: "sh(wsh(sortedmulti(", suffix: ")))", scheme: MultisigP2SHP2WSH},
+	{prefix: "wsh(sortedmulti(", suffix: "))", scheme: MultisigP2WSH},
+}
+
+// ParseDescriptor parses an account output descriptor, such as
+//   wpkh([d34db33f/84'/0'/0']xpub.../0/*)#checksum
+//
+// The checksum is optional, but validated if present. Key expressions must be
+// account-level extended public keys, followed by either /0/*, /1/* or the
+// multipath expression /<0;1>/* (BIP389), since the keychain always observes
+// both the external and internal chains.
+//
+// References:
+//   https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki
+//   https://github.com/bitcoin/bips/blob/master/bip-0389.mediawiki
+func ParseDescriptor(descriptor string) (*Descriptor, error) {
+	desc, err := stripDescriptorChecksum(strings.TrimSpace(descriptor))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range descriptorTemplates {
+		if !strings.HasPrefix(desc, t.prefix) || !strings.HasSuffix(desc, t.suffix) {
+			continue
+		}
+
+		args := strings.Split(desc[len(t.prefix):len(desc)-len(t.suffix)], ",")
+
+		result := &Descriptor{Scheme: t.scheme}
+
+		if t.scheme.IsMultisig() {
+			threshold, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil || len(args) < 2 {
+				return nil, errors.Wrapf(ErrInvalidDescriptor,
+					"invalid multisig expression %s", desc)
+			}
+
+			result.Threshold = uint32(threshold)
+			args = args[1:]
+		} else if len(args) != 1 {
+			return nil, errors.Wrapf(ErrInvalidDescriptor,
+				"unexpected arguments in %s", desc)
+		}
+
+		for _, arg := range args {
+			key, err := parseDescriptorKey(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Keys = append(result.Keys, *key)
+		}
+
+		return result, nil
+	}
+
+	return nil, errors.Wrapf(ErrInvalidDescriptor, "unsupported descriptor %s", desc)
+}
+
+// parseDescriptorKey parses a key expression of an account output
+// descriptor.
+func parseDescriptorKey(expr string) (*DescriptorKey, error) {
+	key := &DescriptorKey{}
+
+	if strings.HasPrefix(expr, "[") {
+		end := strings.IndexByte(expr, ']')
+		if end < 0 {
+			return nil, errors.Wrapf(ErrInvalidDescriptor,
+				"unterminated key origin in %s", expr)
+		}
+
+		origin, err := ParseKeyOrigin(expr[1:end])
+		if err != nil {
+			return nil, err
+		}
+
+		key.Origin = origin
+		expr = expr[end+1:]
+	}
+
+	var ok bool
+
+	for _, suffix := range []string{"/<0;1>/*", "/0/*", "/1/*"} {
+		if strings.HasSuffix(expr, suffix) {
+			key.ExtendedPublicKey = strings.TrimSuffix(expr, suffix)
+			ok = true
+
+			break
+		}
+	}
+
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidDescriptor,
+			"key expression %s must end with /<0;1>/*, /0/* or /1/*", expr)
+	}
+
+	if _, err := bip32.ParseExtendedKey(key.ExtendedPublicKey); err != nil {
+		return nil, errors.Wrapf(ErrInvalidDescriptor,
+			"invalid extended public key %s: %v", key.ExtendedPublicKey, err)
+	}
+
+	return key, nil
+}
+
+// Network infers the Network of a descriptor from the version bytes of its
+// extended public keys.
+func (d *Descriptor) Network() (chaincfg.Network, error) {
+	var net chaincfg.Network
+
+	for _, key := range d.Keys {
+		xpub, err := bip32.ParseExtendedKey(key.ExtendedPublicKey)
+		if err != nil {
+			return "", errors.Wrapf(ErrInvalidDescriptor,
+				"invalid extended public key %s: %v", key.ExtendedPublicKey, err)
+		}
+
+		keyNet, err := chaincfg.NetworkFromHDPublicKeyID(xpub.Version)
+		if err != nil {
+			return "", err
+		}
+
+		if net != "" && keyNet != net {
+			return "", errors.Wrapf(ErrInvalidDescriptor,
+				"keys of different networks %s and %s", net, keyNet)
+		}
+
+		net = keyNet
+	}
+
+	return net, nil
+}
+
+// ExtendedPublicKeys returns the extended public keys of all key expressions
+// of the descriptor.
+func (d *Descriptor) ExtendedPublicKeys() []string {
+	keys := make([]string, len(d.Keys))
+	for i, key := range d.Keys {
+		keys[i] = key.ExtendedPublicKey
+	}
+
+	return keys
+}
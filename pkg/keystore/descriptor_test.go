@@ -4,53 +4,98 @@
 package keystore
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
 	"github.com/pkg/errors"
 )
 
 func TestMakeDescriptor(t *testing.T) {
+	origin := &KeyOrigin{
+		MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f},
+		AccountPath:       []uint32{0x80000054, 0x80000000, 0x80000000},
+	}
+
+	multisigOrigin := &KeyOrigin{
+		MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f},
+		AccountPath:       []uint32{0x80000030, 0x80000000, 0x80000000, 0x80000002},
+	}
+
 	tests := []struct {
-		name              string
-		extendedPublicKey string
-		scheme            Scheme
-		change            Change
-		want              string
-		wantErr           error
+		name    string
+		desc    *Descriptor
+		change  Change
+		want    string
+		wantErr error
 	}{
 		{
-			name:              "legacy",
-			extendedPublicKey: "deadbeef",
-			scheme:            BIP44,
-			change:            External,
-			want:              "pkh(deadbeef/0/*)",
+			name:   "legacy",
+			desc:   &Descriptor{Scheme: BIP44, Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change: External,
+			want:   "pkh(deadbeef/0/*)#vk02hn8g",
+		},
+		{
+			name:   "wrapped segwit",
+			desc:   &Descriptor{Scheme: BIP49, Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change: External,
+			want:   "sh(wpkh(deadbeef/0/*))#f83m7vju",
+		},
+		{
+			name:   "native segwit",
+			desc:   &Descriptor{Scheme: BIP84, Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change: External,
+			want:   "wpkh(deadbeef/0/*)#27vw2hu0",
 		},
 		{
-			name:              "wrapped segwit",
-			extendedPublicKey: "deadbeef",
-			scheme:            BIP49,
-			change:            External,
-			want:              "sh(wpkh(deadbeef/0/*))",
+			name:   "native segwit",
+			desc:   &Descriptor{Scheme: BIP84, Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change: Internal,
+			want:   "wpkh(deadbeef/1/*)#m2f0hzvh",
 		},
 		{
-			name:              "native segwit",
-			extendedPublicKey: "deadbeef",
-			scheme:            BIP84,
-			change:            External,
-			want:              "wpkh(deadbeef/0/*)",
+			name:   "taproot",
+			desc:   &Descriptor{Scheme: BIP86, Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change: External,
+			want:   "tr(deadbeef/0/*)#upaj7c52",
 		},
 		{
-			name:              "native segwit",
-			extendedPublicKey: "deadbeef",
-			scheme:            BIP84,
-			change:            Internal,
-			want:              "wpkh(deadbeef/1/*)",
+			name: "native segwit with key origin",
+			desc: &Descriptor{Scheme: BIP84, Keys: []DescriptorKey{
+				{Origin: origin, ExtendedPublicKey: "deadbeef"},
+			}},
+			change: External,
+			want:   "wpkh([d34db33f/84'/0'/0']deadbeef/0/*)#nkg4uzch",
+		},
+		{
+			name: "multisig with key origin",
+			desc: &Descriptor{Scheme: MultisigP2WSH, Threshold: 2, Keys: []DescriptorKey{
+				{Origin: multisigOrigin, ExtendedPublicKey: "deadbeef"},
+				{ExtendedPublicKey: "cafebabe"},
+			}},
+			change: External,
+			want:   "wsh(sortedmulti(2,cafebabe/0/*,[d34db33f/48'/0'/0'/2']deadbeef/0/*))#3szfp3ds",
+		},
+		{
+			name: "single-key with several keys",
+			desc: &Descriptor{Scheme: BIP84, Keys: []DescriptorKey{
+				{ExtendedPublicKey: "deadbeef"}, {ExtendedPublicKey: "cafebabe"},
+			}},
+			change:  External,
+			wantErr: ErrInvalidDescriptor,
+		},
+		{
+			name:    "unknown scheme",
+			desc:    &Descriptor{Scheme: "BIP0", Keys: []DescriptorKey{{ExtendedPublicKey: "deadbeef"}}},
+			change:  External,
+			wantErr: ErrUnrecognizedScheme,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := MakeDescriptor(tt.extendedPublicKey, tt.change, tt.scheme)
+			got, err := MakeDescriptor(tt.desc, tt.change)
 			if err != nil && tt.wantErr == nil {
 				t.Fatalf("MakeDescriptor() unexpected error: %v", err)
 			}
@@ -71,3 +116,274 @@ func TestMakeDescriptor(t *testing.T) {
 		})
 	}
 }
+
+func TestMakeMultipathDescriptor(t *testing.T) {
+	origin := &KeyOrigin{
+		MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f},
+		AccountPath:       []uint32{0x80000054, 0x80000000, 0x80000000},
+	}
+
+	got, err := MakeMultipathDescriptor(&Descriptor{
+		Scheme: BIP84,
+		Keys:   []DescriptorKey{{Origin: origin, ExtendedPublicKey: "deadbeef"}},
+	})
+	if err != nil {
+		t.Fatalf("MakeMultipathDescriptor() unexpected error: %v", err)
+	}
+
+	want := "wpkh([d34db33f/84'/0'/0']deadbeef/<0;1>/*)#z7383czc"
+	if got != want {
+		t.Fatalf("MakeMultipathDescriptor() got = %v, want = %v", got, want)
+	}
+}
+
+func TestDescriptorChecksum(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor string
+		want       string
+		wantErr    error
+	}{
+		{
+			name:       "raw",
+			descriptor: "raw(deadbeef)",
+			want:       "89f8spxm",
+		},
+		{
+			name:       "addr",
+			descriptor: "addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)",
+			want:       "02wpgw69",
+		},
+		{
+			name:       "invalid character",
+			descriptor: "raw(dé)",
+			wantErr:    ErrInvalidDescriptor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DescriptorChecksum(tt.descriptor)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("DescriptorChecksum() error = %v, wantErr = %v",
+					err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Fatalf("DescriptorChecksum() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDescriptor(t *testing.T) {
+	xpub := "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN"
+	tpub := "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba"
+	ttub := "ttub4dcAn4eGmoSp8i63VoJE1CkWKCY91Ebwjxv9v2kWxATDX3oskXmUoZ11zWjPppZQnsZZYjpHFHruvhtu7WfCKigXxL2rGkDMdFBt4BMVLqE"
+
+	origin := &KeyOrigin{
+		MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f},
+		AccountPath:       []uint32{0x80000054, 0x80000000, 0x80000000},
+	}
+
+	tests := []struct {
+		name       string
+		descriptor string
+		want       *Descriptor
+		wantNet    chaincfg.Network
+		wantErr    error
+	}{
+		{
+			name:       "multipath with origin and checksum",
+			descriptor: "wpkh([d34db33f/84'/0'/0']" + xpub + "/<0;1>/*)#u4skjk33",
+			want: &Descriptor{
+				Scheme: BIP84,
+				Keys:   []DescriptorKey{{Origin: origin, ExtendedPublicKey: xpub}},
+			},
+			wantNet: chaincfg.BitcoinMainnet,
+		},
+		{
+			name:       "hardened h marker",
+			descriptor: "wpkh([d34db33f/84h/0h/0h]" + xpub + "/0/*)",
+			want: &Descriptor{
+				Scheme: BIP84,
+				Keys:   []DescriptorKey{{Origin: origin, ExtendedPublicKey: xpub}},
+			},
+			wantNet: chaincfg.BitcoinMainnet,
+		},
+		{
+			name:       "wrapped segwit without origin",
+			descriptor: "sh(wpkh(" + tpub + "/1/*))",
+			want: &Descriptor{
+				Scheme: BIP49,
+				Keys:   []DescriptorKey{{ExtendedPublicKey: tpub}},
+			},
+			wantNet: chaincfg.BitcoinTestnet3,
+		},
+		{
+			name:       "litecoin testnet",
+			descriptor: "wpkh(" + ttub + "/0/*)",
+			want: &Descriptor{
+				Scheme: BIP84,
+				Keys:   []DescriptorKey{{ExtendedPublicKey: ttub}},
+			},
+			wantNet: chaincfg.LitecoinTestnet,
+		},
+		{
+			name:       "multisig",
+			descriptor: "sh(wsh(sortedmulti(2," + xpub + "/0/*," + xpub + "/0/*)))",
+			want: &Descriptor{
+				Scheme:    MultisigP2SHP2WSH,
+				Threshold: 2,
+				Keys: []DescriptorKey{
+					{ExtendedPublicKey: xpub}, {ExtendedPublicKey: xpub},
+				},
+			},
+			wantNet: chaincfg.BitcoinMainnet,
+		},
+		{
+			name:       "invalid checksum",
+			descriptor: "wpkh([d34db33f/84'/0'/0']" + xpub + "/<0;1>/*)#u4skjk34",
+			wantErr:    ErrInvalidDescriptorChecksum,
+		},
+		{
+			name:       "unsupported script",
+			descriptor: "combo(" + xpub + "/0/*)",
+			wantErr:    ErrInvalidDescriptor,
+		},
+		{
+			name:       "non-ranged key",
+			descriptor: "wpkh(" + xpub + ")",
+			wantErr:    ErrInvalidDescriptor,
+		},
+		{
+			name:       "invalid extended key",
+			descriptor: "wpkh(xpub1111/0/*)",
+			wantErr:    ErrInvalidDescriptor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDescriptor(tt.descriptor)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("ParseDescriptor() error = %v, wantErr = %v",
+					err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseDescriptor() got = %+v, want = %+v", got, tt.want)
+			}
+
+			net, err := got.Network()
+			if err != nil {
+				t.Fatalf("Network() unexpected error: %v", err)
+			}
+
+			if net != tt.wantNet {
+				t.Fatalf("Network() got = %v, want = %v", net, tt.wantNet)
+			}
+		})
+	}
+}
+
+func TestInMemoryKeystore_CreateFromDescriptor(t *testing.T) {
+	keystore := NewInMemoryKeystore(native.NewCoinServiceClient())
+
+	descriptor := "wpkh([d34db33f/84'/0'/2']" + cosigners[1] + "/<0;1>/*)"
+
+	info, err := keystore.CreateFromDescriptor(
+		context.Background(), descriptor, "", DefaultLookaheadSize, 0, "")
+	if err != nil {
+		t.Fatalf("CreateFromDescriptor() unexpected error: %v", err)
+	}
+
+	// The keychain must describe itself with the descriptor it was created
+	// from, checksum included.
+	if want, _ := AddDescriptorChecksum(descriptor); info.MultipathDescriptor != want {
+		t.Fatalf("CreateFromDescriptor() got multipath descriptor = %v, want = %v",
+			info.MultipathDescriptor, want)
+	}
+
+	if info.Scheme != BIP84 {
+		t.Fatalf("CreateFromDescriptor() got scheme = %v, want = %v",
+			info.Scheme, BIP84)
+	}
+
+	if info.Network != chaincfg.BitcoinMainnet {
+		t.Fatalf("CreateFromDescriptor() got network = %v, want = %v",
+			info.Network, chaincfg.BitcoinMainnet)
+	}
+
+	if info.AccountIndex != 2 {
+		t.Fatalf("CreateFromDescriptor() got account index = %v, want = 2",
+			info.AccountIndex)
+	}
+
+	if info.KeyOrigin == nil || info.KeyOrigin.String() != "d34db33f/84'/0'/2'" {
+		t.Fatalf("CreateFromDescriptor() got key origin = %v, want = d34db33f/84'/0'/2'",
+			info.KeyOrigin)
+	}
+
+	multisig, err := keystore.CreateFromDescriptor(
+		context.Background(), "wsh(sortedmulti(2,"+cosigners[0]+"/0/*,"+cosigners[1]+"/0/*,"+cosigners[2]+"/0/*))",
+		chaincfg.BitcoinMainnet, DefaultLookaheadSize, 0, "")
+	if err != nil {
+		t.Fatalf("CreateFromDescriptor() unexpected error: %v", err)
+	}
+
+	if multisig.Scheme != MultisigP2WSH || multisig.Threshold != 2 {
+		t.Fatalf("CreateFromDescriptor() got scheme = %v, threshold = %v",
+			multisig.Scheme, multisig.Threshold)
+	}
+}
+
+func TestParseAccountPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    []uint32
+		wantErr error
+	}{
+		{
+			name: "hardened",
+			path: "84'/0'/0'",
+			want: []uint32{0x80000054, 0x80000000, 0x80000000},
+		},
+		{
+			name: "master prefix and h marker",
+			path: "m/48h/1h/0h/2h",
+			want: []uint32{0x80000030, 0x80000001, 0x80000000, 0x80000002},
+		},
+		{
+			name: "not hardened",
+			path: "0/1",
+			want: []uint32{0, 1},
+		},
+		{
+			name:    "invalid index",
+			path:    "84'/zero'",
+			wantErr: ErrInvalidKeyOrigin,
+		},
+		{
+			name:    "index out of range",
+			path:    "2147483648",
+			wantErr: ErrInvalidKeyOrigin,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAccountPath(tt.path)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("ParseAccountPath() error = %v, wantErr = %v",
+					err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseAccountPath() got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
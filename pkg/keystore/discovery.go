@@ -0,0 +1,97 @@
+package keystore
+
+import (
+	"context"
+
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/pkg/errors"
+)
+
+// AddressUsageOracle reports whether addresses have transaction history,
+// typically by querying a blockchain explorer.
+type AddressUsageOracle interface {
+	// AddressesUsed returns whether each address has transaction history,
+	// in the same order as addresses.
+	AddressesUsed(ctx context.Context, addresses []string) ([]bool, error)
+}
+
+// keystoreDiscover scans the chains of the path template of a keychain,
+// from index 0 and by batches of LookaheadSize addresses, until
+// LookaheadSize consecutive addresses are unused according to oracle.
+//
+// Used addresses are marked as used and returned. All addresses derived
+// during the scan are returned too, so that backends can index them.
+func (m *Meta) keystoreDiscover(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	oracle AddressUsageOracle,
+) ([]AddressInfo, []AddressInfo, error) {
+	gapLimit := m.Main.LookaheadSize
+	if gapLimit == 0 {
+		gapLimit = DefaultLookaheadSize
+	}
+
+	var used, derived []AddressInfo
+
+	for _, change := range m.Main.PathTemplate.Changes() {
+		var gap uint32
+
+		for index := uint32(0); gap < gapLimit; index += gapLimit {
+			paths := make([]DerivationPath, gapLimit)
+			for i := range paths {
+				paths[i] = DerivationPath{uint32(change), index + uint32(i)}
+			}
+
+			addresses, err := deriveAddresses(ctx, client, m, paths)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			batch := make([]AddressInfo, gapLimit)
+			for i, path := range paths {
+				batch[i] = m.addressInfo(addresses[i], path)
+			}
+
+			derived = append(derived, batch...)
+
+			usage, err := oracle.AddressesUsed(ctx, addresses)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to query address usage")
+			}
+
+			if len(usage) != len(addresses) {
+				return nil, nil, errors.Wrapf(ErrInvalidAddressUsage,
+					"%d results for %d addresses", len(usage), len(addresses))
+			}
+
+			for i, isUsed := range usage {
+				if !isUsed {
+					gap++
+
+					if gap == gapLimit {
+						break
+					}
+
+					continue
+				}
+
+				gap = 0
+
+				if err := m.keystoreMarkPathAsUsed(batch[i].Derivation); err != nil {
+					return nil, nil, err
+				}
+
+				used = append(used, batch[i])
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":      m.Main.ID.String(),
+		"used":    len(used),
+		"derived": len(derived),
+	}).Info("[keystore] discover keychain")
+
+	return used, derived, nil
+}
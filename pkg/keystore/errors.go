@@ -23,6 +23,55 @@ var (
 	// address-to-derivations mapping in the keystore.
 	ErrAddressNotFound = errors.New("address not found")
 
+	// ErrInvalidMultisig indicates that the threshold or the number of
+	// cosigners of a multisig keychain is invalid.
+	ErrInvalidMultisig = errors.New("invalid multisig")
+
+	// ErrInvalidDescriptor indicates that an output descriptor is malformed,
+	// or describes outputs that are not supported by the keychain.
+	ErrInvalidDescriptor = errors.New("invalid descriptor")
+
+	// ErrInvalidDescriptorChecksum indicates that the checksum of an output
+	// descriptor does not match its content.
+	ErrInvalidDescriptorChecksum = errors.New("invalid descriptor checksum")
+
+	// ErrInvalidExtendedKey indicates that an extended public key is
+	// malformed, or that its version bytes do not match the network or the
+	// scheme of the keychain.
+	ErrInvalidExtendedKey = errors.New("invalid extended public key")
+
+	// ErrInvalidKeyOrigin indicates that a master key fingerprint or an
+	// account derivation path is malformed.
+	ErrInvalidKeyOrigin = errors.New("invalid key origin")
+
+	// ErrInvalidDerivationPath indicates that a serialized DerivationPath is
+	// malformed.
+	ErrInvalidDerivationPath = errors.New("invalid derivation path")
+
+	// ErrInvalidPathTemplate indicates that a PathTemplate is malformed.
+	ErrInvalidPathTemplate = errors.New("invalid path template")
+
+	// ErrInvalidAddressFormat indicates that an AddressFormat is unknown, or
+	// not supported by the network of the keychain.
+	ErrInvalidAddressFormat = errors.New("invalid address format")
+
+	// ErrInvalidCursor indicates that a ListKeychains cursor is malformed, or
+	// was not returned by the same backend.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidAddressUsage indicates that an AddressUsageOracle did not
+	// return the usage of every address it was queried for.
+	ErrInvalidAddressUsage = errors.New("invalid address usage")
+
+	// ErrInvalidDerivations indicates that the CoinService did not return a
+	// child extended key for every child index it was requested to derive.
+	ErrInvalidDerivations = errors.New("invalid derivations")
+
+	// ErrConcurrentModification indicates that a keychain was modified
+	// concurrently too many times while being updated, so the update was
+	// given up.
+	ErrConcurrentModification = errors.New("concurrent modification of keychain")
+
 	// ErrDerivationNotFound indicates that an derivation was not found in the
 	// derivation-to-xpub mapping in the keystore.
 	ErrDerivationNotFound = errors.New("derivation not found")
@@ -1,70 +1,177 @@
 package keystore
 
 import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
 	"github.com/google/uuid"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
 )
 
 // Schema is a map between keychain ID and the keystore information.
-type schema map[uuid.UUID]*Meta
+type schema map[uuid.UUID]*lockedMeta
+
+// lockedMeta guards the keystore information of a single keychain, so that
+// concurrent operations on different keychains do not contend.
+type lockedMeta struct {
+	sync.Mutex
+	meta *Meta
+
+	// deleted is set, under the lock, when the keychain is deleted or
+	// replaced, for the operations which got the keychain before.
+	deleted bool
+}
 
 // InMemoryKeystore implements the Keystore interface where the storage
-// is an in-memory map. Useful for unit-tests.
+// is an in-memory map. Useful for unit-tests and single-node setups.
+//
+// It is safe for concurrent use. The map is guarded by a RWMutex, and each
+// keychain by its own Mutex. The address index is guarded by another
+// RWMutex, which is always acquired last.
 //
 // It also includes a client to communicate with a bitcoin-lib-grpc gRPC server
 // for protocol-level operations.
 type InMemoryKeystore struct {
+	mu     sync.RWMutex
 	db     schema
 	client bitcoin.CoinServiceClient
+
+	// searchLimit is the number of addresses derived to discover unknown
+	// addresses, see AddressSearcher.
+	searchLimit uint32
+
+	ownersMu sync.RWMutex
+	owners   map[string]AddressOwner
 }
 
 // NewInMemoryKeystore returns an instance of InMemoryKeystore which implements
-// the Keystore interface.
-func NewInMemoryKeystore() Keystore {
+// the Keystore interface, and uses the given CoinServiceClient for
+// protocol-level operations.
+func NewInMemoryKeystore(client bitcoin.CoinServiceClient) Keystore {
 	return &InMemoryKeystore{
 		db:     schema{},
-		client: bitcoin.NewBitcoinClient(),
+		client: client,
+		owners: map[string]AddressOwner{},
 	}
 }
 
-func (s *InMemoryKeystore) Get(id uuid.UUID) (KeychainInfo, error) {
+// withMeta runs fn with the keystore information of a keychain, while
+// holding the lock of the keychain.
+func (s *InMemoryKeystore) withMeta(id uuid.UUID, fn func(meta *Meta) error) error {
+	s.mu.RLock()
 	document, ok := s.db[id]
+	s.mu.RUnlock()
+
 	if !ok {
-		return KeychainInfo{}, ErrKeychainNotFound
+		return ErrKeychainNotFound
 	}
 
-	return document.Main, nil
-}
+	document.Lock()
+	defer document.Unlock()
 
-func (s *InMemoryKeystore) Delete(id uuid.UUID) error {
-	_, ok := s.db[id]
-	if !ok {
+	// The keychain may have been deleted while waiting for its lock.
+	if document.deleted {
 		return ErrKeychainNotFound
 	}
 
-	delete(s.db, id)
+	return fn(document.meta)
+}
 
-	return nil
+// put stores the keystore information of a keychain, replacing any previous
+// keychain with the same ID.
+func (s *InMemoryKeystore) put(meta *Meta) {
+	s.mu.Lock()
+	previous, ok := s.db[meta.Main.ID]
+	s.db[meta.Main.ID] = &lockedMeta{meta: meta}
+	s.mu.Unlock()
+
+	if ok {
+		previous.Lock()
+		previous.deleted = true
+		s.unindex(previous.meta)
+		previous.Unlock()
+	}
 }
 
-func (s *InMemoryKeystore) Reset(id uuid.UUID) error {
-	meta, ok := s.db[id]
+// index adds derived addresses of a keychain to the address index.
+func (s *InMemoryKeystore) index(id uuid.UUID, addrs []AddressInfo) {
+	s.ownersMu.Lock()
+	defer s.ownersMu.Unlock()
+
+	for _, addr := range addrs {
+		s.owners[addr.Address] = AddressOwner{
+			KeychainID: id,
+			Derivation: addr.Derivation,
+			Path:       addr.Path,
+		}
+	}
+}
+
+// unindex removes all addresses of a keychain from the address index.
+func (s *InMemoryKeystore) unindex(meta *Meta) {
+	s.ownersMu.Lock()
+	defer s.ownersMu.Unlock()
+
+	for addr := range meta.Addresses {
+		if s.owners[addr].KeychainID == meta.Main.ID {
+			delete(s.owners, addr)
+		}
+	}
+}
+
+func (s *InMemoryKeystore) Get(ctx context.Context, id uuid.UUID) (KeychainInfo, error) {
+	var info KeychainInfo
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		info = meta.Main
+		return nil
+	})
+
+	return info, err
+}
+
+func (s *InMemoryKeystore) Delete(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	document, ok := s.db[id]
+	delete(s.db, id)
+	s.mu.Unlock()
+
 	if !ok {
 		return ErrKeychainNotFound
 	}
 
-	meta.ResetKeychainMeta()
+	document.Lock()
+	document.deleted = true
+	s.unindex(document.meta)
+	document.Unlock()
 
 	return nil
 }
 
+func (s *InMemoryKeystore) Reset(ctx context.Context, id uuid.UUID) error {
+	return s.withMeta(id, func(meta *Meta) error {
+		s.unindex(meta)
+		meta.ResetKeychainMeta()
+		return nil
+	})
+}
+
 func (s *InMemoryKeystore) Create(
-	extendedPublicKey string, fromChainCode *FromChainCode, scheme Scheme, net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+	ctx context.Context, extendedPublicKey string, fromChainCode *FromChainCode,
+	origin *KeyOrigin, template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
 ) (KeychainInfo, error) {
 	meta, err := keystoreCreate(
+		ctx,
 		extendedPublicKey,
 		fromChainCode,
+		origin,
+		template,
+		format,
 		scheme,
 		net,
 		lookaheadSize,
@@ -77,83 +184,281 @@ func (s *InMemoryKeystore) Create(
 		return KeychainInfo{}, err
 	}
 
-	s.db[meta.Main.ID] = &meta
+	s.put(&meta)
 
 	return meta.Main, nil
 }
 
-func (s InMemoryKeystore) GetFreshAddress(id uuid.UUID, change Change) (*AddressInfo, error) {
-	addrs, err := s.GetFreshAddresses(id, change, 1)
+func (s *InMemoryKeystore) CreateMultisig(
+	ctx context.Context, extendedPublicKeys []string, threshold uint32,
+	template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateMultisig(
+		ctx,
+		extendedPublicKeys,
+		nil,
+		template,
+		format,
+		threshold,
+		scheme,
+		net,
+		lookaheadSize,
+		index,
+		metadata,
+		s.client,
+	)
+
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	s.put(&meta)
+
+	return meta.Main, nil
+}
+
+func (s *InMemoryKeystore) CreateFromDescriptor(
+	ctx context.Context, descriptor string, net chaincfg.Network, lookaheadSize uint32,
+	index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateFromDescriptor(
+		ctx, descriptor, net, lookaheadSize, index, metadata, s.client)
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	s.put(&meta)
+
+	return meta.Main, nil
+}
+
+func (s *InMemoryKeystore) GetFreshAddress(ctx context.Context, id uuid.UUID, change Change) (*AddressInfo, error) {
+	addrs, err := s.GetFreshAddresses(ctx, id, change, 1)
 	if err != nil {
 		return nil, err
 	}
 	return &addrs[0], err
 }
 
-func (s InMemoryKeystore) GetFreshAddresses(
-	id uuid.UUID, change Change, size uint32,
+func (s *InMemoryKeystore) GetFreshAddresses(
+	ctx context.Context, id uuid.UUID, change Change, size uint32,
 ) ([]AddressInfo, error) {
 	addrs := []AddressInfo{}
 
-	meta, ok := s.db[id]
-	if !ok {
-		return addrs, ErrKeychainNotFound
-	}
-	addrs, err := meta.keystoreGetFreshAddresses(s.client, change, size)
-	if err != nil {
-		return addrs, err
-	}
+	err := s.withMeta(id, func(meta *Meta) error {
+		var err error
+		addrs, err = meta.keystoreGetFreshAddresses(ctx, s.client, change, size)
+		if err != nil {
+			return err
+		}
 
-	return addrs, nil
+		s.index(id, addrs)
+		return nil
+	})
+
+	return addrs, err
 }
 
-func (s *InMemoryKeystore) MarkPathAsUsed(id uuid.UUID, path DerivationPath) error {
-	// Get keychain by ID
-	meta, ok := s.db[id]
-	if !ok {
-		return ErrKeychainNotFound
-	}
+func (s *InMemoryKeystore) MarkPathAsUsed(ctx context.Context, id uuid.UUID, path DerivationPath) error {
+	return s.withMeta(id, func(meta *Meta) error {
+		return meta.keystoreMarkPathAsUsed(path)
+	})
+}
 
-	err := meta.keystoreMarkPathAsUsed(path)
-	if err != nil {
+func (s *InMemoryKeystore) GetAllObservableAddresses(
+	ctx context.Context, id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
+) ([]AddressInfo, error) {
+	var addrs []AddressInfo
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		var err error
+		addrs, err = meta.keystoreGetAllObservableAddresses(
+			ctx, s.client, change, fromIndex, toIndex,
+		)
+		if err != nil {
+			return err
+		}
+
+		s.index(id, addrs)
+		return nil
+	})
+
+	return addrs, err
+}
+
+func (s *InMemoryKeystore) GetDerivationPath(ctx context.Context, id uuid.UUID, address string) (DerivationPath, error) {
+	var path DerivationPath
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		var err error
+		path, err = meta.keystoreGetDerivationPath(address)
 		return err
-	}
+	})
 
-	return nil
+	return path, err
 }
 
-func (s *InMemoryKeystore) GetAllObservableAddresses(
-	id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
+func (s *InMemoryKeystore) MarkAddressAsUsed(ctx context.Context, id uuid.UUID, address string) error {
+	return keystoreMarkAddressAsUsed(ctx, s, id, address)
+}
+
+func (s *InMemoryKeystore) DiscoverKeychain(
+	ctx context.Context, id uuid.UUID, oracle AddressUsageOracle,
 ) ([]AddressInfo, error) {
-	meta, ok := s.db[id]
-	if !ok {
-		return nil, ErrKeychainNotFound
-	}
-	return meta.keystoreGetAllObservableAddresses(
-		s.client, change, fromIndex, toIndex,
-	)
+	var used []AddressInfo
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		var (
+			derived []AddressInfo
+			err     error
+		)
+
+		used, derived, err = meta.keystoreDiscover(ctx, s.client, oracle)
+		if err != nil {
+			return err
+		}
+
+		s.index(id, derived)
+		return nil
+	})
+
+	return used, err
 }
 
-func (s InMemoryKeystore) GetDerivationPath(id uuid.UUID, address string) (DerivationPath, error) {
-	meta, ok := s.db[id]
-	if !ok {
-		return DerivationPath{}, ErrKeychainNotFound
+// SetAddressSearchLimit enables the discovery of unknown addresses marked as
+// used, see AddressSearcher.
+func (s *InMemoryKeystore) SetAddressSearchLimit(limit uint32) {
+	s.searchLimit = limit
+}
+
+func (s *InMemoryKeystore) MarkAddressesAsUsed(
+	ctx context.Context, id uuid.UUID, addresses []string,
+) ([]MarkResult, error) {
+	var results []MarkResult
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		var (
+			discovered []AddressInfo
+			err        error
+		)
+
+		results, discovered, err = meta.keystoreMarkAddressesAsUsed(
+			ctx, s.client, addresses, meta.Addresses, s.searchLimit,
+		)
+		if err != nil {
+			return err
+		}
+
+		s.index(id, discovered)
+		return nil
+	})
+
+	return results, err
+}
+
+// GetAddressesPublicKeys reads the derivation-to-publicKey mapping in the keystore,
+// and returns the public keys corresponding to given derivations.
+func (s *InMemoryKeystore) GetAddressesPublicKeys(ctx context.Context, id uuid.UUID, derivations []DerivationPath) ([][]string, error) {
+	var publicKeys [][]string
+
+	err := s.withMeta(id, func(meta *Meta) error {
+		var err error
+		publicKeys, err = meta.keystoreGetAddressesPublicKeys(derivations)
+		return err
+	})
+
+	return publicKeys, err
+}
+
+func (s *InMemoryKeystore) FindAddressOwner(ctx context.Context, addresses []string) ([]*AddressOwner, error) {
+	s.ownersMu.RLock()
+	defer s.ownersMu.RUnlock()
+
+	owners := make([]*AddressOwner, len(addresses))
+
+	for i, addr := range addresses {
+		if owner, ok := s.owners[addr]; ok {
+			owners[i] = &owner
+		}
 	}
 
-	return meta.keystoreGetDerivationPath(address)
+	return owners, nil
 }
 
-func (s *InMemoryKeystore) MarkAddressAsUsed(id uuid.UUID, address string) error {
-	return keystoreMarkAddressAsUsed(s, id, address)
+// AddressCounts returns the number of addresses of every keychain, see
+// metrics.Store.
+func (s *InMemoryKeystore) AddressCounts(ctx context.Context) ([]int, error) {
+	s.mu.RLock()
+	documents := make([]*lockedMeta, 0, len(s.db))
+	for _, document := range s.db {
+		documents = append(documents, document)
+	}
+	s.mu.RUnlock()
+
+	counts := make([]int, len(documents))
+
+	for i, document := range documents {
+		document.Lock()
+		counts[i] = len(document.meta.Addresses)
+		document.Unlock()
+	}
+
+	return counts, nil
 }
 
-// GetAddressesPublicKeys reads the derivation-to-publicKey mapping in the keystore,
-// and returns extendend public keys corresponding to given derivations.
-func (s *InMemoryKeystore) GetAddressesPublicKeys(id uuid.UUID, derivations []DerivationPath) ([]string, error) {
-	meta, ok := s.db[id]
-	if !ok {
-		return nil, ErrKeychainNotFound
+// ListKeychains lists keychains in the order of their IDs. The cursor is the
+// ID of the last keychain of the previous page.
+func (s *InMemoryKeystore) ListKeychains(
+	ctx context.Context, filter KeychainFilter, cursor string, limit uint32,
+) ([]KeychainInfo, string, error) {
+	var after uuid.UUID
+
+	if cursor != "" {
+		id, err := uuid.Parse(cursor)
+		if err != nil {
+			return nil, "", errors.Wrap(ErrInvalidCursor, cursor)
+		}
+
+		after = id
+	}
+
+	s.mu.RLock()
+
+	documents := make([]*lockedMeta, 0, len(s.db))
+	for id, document := range s.db {
+		if bytes.Compare(id[:], after[:]) > 0 {
+			documents = append(documents, document)
+		}
+	}
+
+	s.mu.RUnlock()
+
+	// IDs never change, so they can be read without holding the lock of the
+	// keychain.
+	sort.Slice(documents, func(i, j int) bool {
+		a, b := documents[i].meta.Main.ID, documents[j].meta.Main.ID
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	infos := []KeychainInfo{}
+
+	for _, document := range documents {
+		document.Lock()
+		info := document.meta.Main
+		document.Unlock()
+
+		if !filter.Match(info) {
+			continue
+		}
+
+		// The page is full, and there is at least one more keychain.
+		if uint32(len(infos)) == pageSize(limit) {
+			return infos, infos[len(infos)-1].ID.String(), nil
+		}
+
+		infos = append(infos, info)
 	}
 
-	return meta.keystoreGetAddressesPublicKeys(derivations)
+	return infos, "", nil
 }
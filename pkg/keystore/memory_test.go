@@ -9,14 +9,27 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/oracle"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 )
 
+// mockXPub and mockXPub2 are valid extended public keys, since the keystore
+// checks their version bytes. They are never derived by mockBitcoinClient.
+//
+// mockZPub is the SLIP-0132 serialization of mockXPub for BIP84 keychains.
+const (
+	mockXPub  = "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+	mockZPub  = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	mockXPub2 = "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"
+)
+
 type mockBitcoinClient struct{}
 
 func (c mockBitcoinClient) ValidateAddress(
@@ -35,6 +48,10 @@ func (c mockBitcoinClient) DeriveExtendedKey(
 	in *bitcoin.DeriveExtendedKeyRequest,
 	opts ...grpc.CallOption,
 ) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	extendedKey := in.ExtendedKey
 	publicKey := []byte{0xDE, 0xAD, 0xBE, 0xEF}
 	chainCode := []byte{0xCA, 0xFE, 0xBA, 0xBE}
@@ -52,13 +69,35 @@ func (c mockBitcoinClient) DeriveExtendedKey(
 	}, nil
 }
 
+func (c mockBitcoinClient) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	resp := &bitcoin.DeriveExtendedKeysResponse{}
+
+	for _, index := range in.ChildIndexes {
+		child, err := c.DeriveExtendedKey(ctx, &bitcoin.DeriveExtendedKeyRequest{
+			ExtendedKey: in.ExtendedKey,
+			Derivation:  []uint32{index},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Keys = append(resp.Keys, child)
+	}
+
+	return resp, nil
+}
+
 func (c mockBitcoinClient) GetAccountExtendedKey(
 	ctx context.Context,
 	in *bitcoin.GetAccountExtendedKeyRequest,
 	opts ...grpc.CallOption,
 ) (*bitcoin.GetAccountExtendedKeyResponse, error) {
 	return &bitcoin.GetAccountExtendedKeyResponse{
-		ExtendedKey: "xpub1111",
+		ExtendedKey: mockXPub,
 	}, nil
 }
 
@@ -89,6 +128,7 @@ func NewMockInMemoryKeystore() Keystore {
 	return &InMemoryKeystore{
 		db:     schema{},
 		client: mockBitcoinClient{},
+		owners: map[string]AddressOwner{},
 	}
 }
 
@@ -103,7 +143,7 @@ func TestInMemoryKeystore_UUID(t *testing.T) {
 		info          string
 	}{
 		name:        "native segwit",
-		extendedKey: "xpub1111",
+		extendedKey: mockXPub,
 		scheme:      BIP84,
 		network:     chaincfg.BitcoinMainnet,
 		index:       1,
@@ -113,7 +153,7 @@ func TestInMemoryKeystore_UUID(t *testing.T) {
 	keystore := NewMockInMemoryKeystore()
 
 	info1, err := keystore.Create(
-		test.extendedKey, test.fromChainCode, test.scheme, test.network,
+		context.Background(), test.extendedKey, test.fromChainCode, nil, "", "", test.scheme, test.network,
 		DefaultLookaheadSize, test.index, test.info,
 	)
 	if err != nil {
@@ -121,7 +161,7 @@ func TestInMemoryKeystore_UUID(t *testing.T) {
 	}
 
 	info2, err := keystore.Create(
-		test.extendedKey, test.fromChainCode, test.scheme, test.network,
+		context.Background(), test.extendedKey, test.fromChainCode, nil, "", "", test.scheme, test.network,
 		DefaultLookaheadSize, test.index, test.info,
 	)
 	if err != nil {
@@ -133,7 +173,7 @@ func TestInMemoryKeystore_UUID(t *testing.T) {
 	}
 
 	info3, err := keystore.Create(
-		"xpub2222", test.fromChainCode, test.scheme, test.network,
+		context.Background(), mockXPub2, test.fromChainCode, nil, "", "", test.scheme, test.network,
 		DefaultLookaheadSize, test.index, test.info,
 	)
 
@@ -144,6 +184,35 @@ func TestInMemoryKeystore_UUID(t *testing.T) {
 	if reflect.DeepEqual(info1, info3) {
 		t.Fatalf("UUID must be different")
 	}
+
+	// Bitcoin test networks share the tpub version bytes, so that the same
+	// key can be registered on each of them.
+	const mockTPub = "tpubDCxX2sYFS5bDkSe5GKKYHjBW7tgyN1R3UchpLJvdbf54ohxeGRtd8MbDUe1cguVHe4vnK68DsuD5MXjxi9EXx16rb9EnNsaF5KT99CinaJz"
+
+	testnet, err := keystore.Create(
+		context.Background(), mockTPub, nil, nil, "", "", BIP84, chaincfg.BitcoinTestnet3,
+		DefaultLookaheadSize, test.index, test.info,
+	)
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	signet, err := keystore.Create(
+		context.Background(), mockTPub, nil, nil, "", "", BIP84, chaincfg.BitcoinSignet,
+		DefaultLookaheadSize, test.index, test.info,
+	)
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	if testnet.ID == signet.ID {
+		t.Fatalf("UUID must be different on each network")
+	}
+
+	got, err := keystore.Get(context.Background(), testnet.ID)
+	if err != nil || got.Network != chaincfg.BitcoinTestnet3 {
+		t.Fatalf("Get() got network = %s, %v, want %s", got.Network, err, chaincfg.BitcoinTestnet3)
+	}
 }
 
 func TestInMemoryKeystore_GetCreate(t *testing.T) {
@@ -151,6 +220,7 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 		name          string
 		extendedKey   string
 		fromChainCode *FromChainCode
+		origin        *KeyOrigin
 		scheme        Scheme
 		network       chaincfg.Network
 		index         uint32
@@ -160,19 +230,20 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 	}{
 		{
 			name:        "native segwit",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			scheme:      BIP84,
 			network:     chaincfg.BitcoinMainnet,
 			index:       1,
 			info:        "",
 			want: KeychainInfo{
-				ExternalDescriptor:          "wpkh(xpub1111/0/*)",
-				InternalDescriptor:          "wpkh(xpub1111/1/*)",
-				ExtendedPublicKey:           "xpub1111",
-				SLIP32ExtendedPublicKey:     "xpub1111",
-				ExternalXPub:                "xpub1111->0",
+				ExternalDescriptor:          "wpkh(" + mockXPub + "/0/*)#kj7aqcx6",
+				InternalDescriptor:          "wpkh(" + mockXPub + "/1/*)#8xmuadkz",
+				MultipathDescriptor:         "wpkh(" + mockXPub + "/<0;1>/*)#3r0wrtd9",
+				ExtendedPublicKey:           mockXPub,
+				SLIP32ExtendedPublicKey:     mockZPub,
+				ExternalXPub:                mockXPub + "->0",
 				MaxConsecutiveExternalIndex: 0,
-				InternalXPub:                "xpub1111->1",
+				InternalXPub:                mockXPub + "->1",
 				MaxConsecutiveInternalIndex: 0,
 				LookaheadSize:               20,
 				Scheme:                      "BIP84",
@@ -189,13 +260,14 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 			index:         2,
 			info:          "random info",
 			want: KeychainInfo{
-				ExternalDescriptor:          "wpkh(xpub1111/0/*)",
-				InternalDescriptor:          "wpkh(xpub1111/1/*)",
-				ExtendedPublicKey:           "xpub1111",
-				SLIP32ExtendedPublicKey:     "xpub1111",
-				ExternalXPub:                "xpub1111->0",
+				ExternalDescriptor:          "wpkh(" + mockXPub + "/0/*)#kj7aqcx6",
+				InternalDescriptor:          "wpkh(" + mockXPub + "/1/*)#8xmuadkz",
+				MultipathDescriptor:         "wpkh(" + mockXPub + "/<0;1>/*)#3r0wrtd9",
+				ExtendedPublicKey:           mockXPub,
+				SLIP32ExtendedPublicKey:     mockZPub,
+				ExternalXPub:                mockXPub + "->0",
 				MaxConsecutiveExternalIndex: 0,
-				InternalXPub:                "xpub1111->1",
+				InternalXPub:                mockXPub + "->1",
 				MaxConsecutiveInternalIndex: 0,
 				LookaheadSize:               20,
 				Scheme:                      "BIP84",
@@ -204,6 +276,62 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 				Metadata:                    "random info",
 			},
 		},
+		{
+			name:        "native segwit (from zpub)",
+			extendedKey: mockZPub,
+			scheme:      BIP84,
+			network:     chaincfg.BitcoinMainnet,
+			index:       1,
+			want: KeychainInfo{
+				ExternalDescriptor:          "wpkh(" + mockXPub + "/0/*)#kj7aqcx6",
+				InternalDescriptor:          "wpkh(" + mockXPub + "/1/*)#8xmuadkz",
+				MultipathDescriptor:         "wpkh(" + mockXPub + "/<0;1>/*)#3r0wrtd9",
+				ExtendedPublicKey:           mockXPub,
+				SLIP32ExtendedPublicKey:     mockZPub,
+				ExternalXPub:                mockXPub + "->0",
+				MaxConsecutiveExternalIndex: 0,
+				InternalXPub:                mockXPub + "->1",
+				MaxConsecutiveInternalIndex: 0,
+				LookaheadSize:               20,
+				Scheme:                      "BIP84",
+				Network:                     chaincfg.BitcoinMainnet,
+				AccountIndex:                1,
+			},
+		},
+		{
+			name:        "wrapped segwit (from zpub)",
+			extendedKey: mockZPub,
+			scheme:      BIP49,
+			network:     chaincfg.BitcoinMainnet,
+			wantErr:     ErrInvalidExtendedKey,
+		},
+		{
+			name:        "native segwit (with master fingerprint)",
+			extendedKey: mockXPub,
+			origin:      &KeyOrigin{MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f}},
+			scheme:      BIP84,
+			network:     chaincfg.BitcoinMainnet,
+			index:       1,
+			want: KeychainInfo{
+				ExternalDescriptor:          "wpkh([d34db33f/84'/0'/1']" + mockXPub + "/0/*)#gg9t5hd9",
+				InternalDescriptor:          "wpkh([d34db33f/84'/0'/1']" + mockXPub + "/1/*)#euq2fzaa",
+				MultipathDescriptor:         "wpkh([d34db33f/84'/0'/1']" + mockXPub + "/<0;1>/*)#kcsmh0yv",
+				ExtendedPublicKey:           mockXPub,
+				SLIP32ExtendedPublicKey:     mockZPub,
+				ExternalXPub:                mockXPub + "->0",
+				MaxConsecutiveExternalIndex: 0,
+				InternalXPub:                mockXPub + "->1",
+				MaxConsecutiveInternalIndex: 0,
+				LookaheadSize:               20,
+				Scheme:                      "BIP84",
+				Network:                     chaincfg.BitcoinMainnet,
+				AccountIndex:                1,
+				KeyOrigin: &KeyOrigin{
+					MasterFingerprint: [4]byte{0xd3, 0x4d, 0xb3, 0x3f},
+					AccountPath:       []uint32{0x80000054, 0x80000000, 0x80000001},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -211,7 +339,7 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 			keystore := NewMockInMemoryKeystore()
 
 			gotInfo, err := keystore.Create(
-				tt.extendedKey, tt.fromChainCode, tt.scheme, tt.network,
+				context.Background(), tt.extendedKey, tt.fromChainCode, tt.origin, "", "", tt.scheme, tt.network,
 				DefaultLookaheadSize, tt.index, tt.info,
 			)
 			if err != nil && tt.wantErr == nil {
@@ -236,7 +364,7 @@ func TestInMemoryKeystore_GetCreate(t *testing.T) {
 					gotInfo, tt.want)
 			}
 
-			gotDB, dbErr := keystore.Get(gotInfo.ID)
+			gotDB, dbErr := keystore.Get(context.Background(), gotInfo.ID)
 			if dbErr != nil && err == nil {
 				t.Fatalf("Get() unexpected error: %v", dbErr)
 			}
@@ -271,25 +399,25 @@ func TestInMemoryKeystore_GetFreshAddress(t *testing.T) {
 	}{
 		{
 			name:        "p2pkh mainnet",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			scheme:      BIP84,
 			change:      External,
 			network:     chaincfg.BitcoinMainnet,
-			want:        &AddressInfo{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Change: External},
+			want:        &AddressInfo{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Path: []uint32{0, 0}, Change: External},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			keystore := NewMockInMemoryKeystore()
 			info, err := keystore.Create(
-				tt.extendedKey, nil, tt.scheme, tt.network, DefaultLookaheadSize,
+				context.Background(), tt.extendedKey, nil, nil, "", "", tt.scheme, tt.network, DefaultLookaheadSize,
 				1, "",
 			)
 			if err != nil {
 				panic(err)
 			}
 
-			got, err := keystore.GetFreshAddress(info.ID, tt.change)
+			got, err := keystore.GetFreshAddress(context.Background(), info.ID, tt.change)
 			if err != nil && tt.wantErr == nil {
 				t.Fatalf("GetFreshAddress() unexpected error: %v", err)
 			}
@@ -325,7 +453,7 @@ func TestInMemoryKeystore_GetFreshAddresses(t *testing.T) {
 	}{
 		{
 			name:        "empty",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			scheme:      BIP84,
 			change:      External,
 			network:     chaincfg.BitcoinMainnet,
@@ -334,17 +462,17 @@ func TestInMemoryKeystore_GetFreshAddresses(t *testing.T) {
 		},
 		{
 			name:        "p2pkh mainnet multi",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			scheme:      BIP84,
 			change:      External,
 			network:     chaincfg.BitcoinMainnet,
 			size:        5,
 			want: []AddressInfo{
-				{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Change: External},
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External},
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 2}, Change: External},
-				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Change: External},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Change: External},
+				{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Path: []uint32{0, 0}, Change: External},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External},
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 2}, Path: []uint32{0, 2}, Change: External},
+				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Path: []uint32{0, 3}, Change: External},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Path: []uint32{0, 4}, Change: External},
 			},
 		},
 	}
@@ -352,14 +480,14 @@ func TestInMemoryKeystore_GetFreshAddresses(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			keystore := NewMockInMemoryKeystore()
 			info, err := keystore.Create(
-				tt.extendedKey, nil, tt.scheme, tt.network, DefaultLookaheadSize,
+				context.Background(), tt.extendedKey, nil, nil, "", "", tt.scheme, tt.network, DefaultLookaheadSize,
 				1, "",
 			)
 			if err != nil {
 				panic(err)
 			}
 
-			got, err := keystore.GetFreshAddresses(info.ID, tt.change, tt.size)
+			got, err := keystore.GetFreshAddresses(context.Background(), info.ID, tt.change, tt.size)
 			if err != nil && tt.wantErr == nil {
 				t.Fatalf("GetFreshAddresses() unexpected error: %v", err)
 			}
@@ -386,7 +514,7 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 	keystore := NewMockInMemoryKeystore()
 
 	info, err := keystore.Create(
-		"xpub1111", nil, BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
 	if err != nil {
 		panic(err)
 	}
@@ -406,13 +534,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: External,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External}, // should have no gaps
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 2}, Change: External},
-				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Change: External},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Change: External},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Change: External},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External}, // should have no gaps
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 2}, Path: []uint32{0, 2}, Change: External},
+				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Path: []uint32{0, 3}, Change: External},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Path: []uint32{0, 4}, Change: External},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Path: []uint32{0, 5}, Change: External},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External},
 		},
 		{
 			name:   "mark 0/2 as used",
@@ -420,13 +548,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: External,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External}, // should detect the gap
-				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Change: External},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Change: External},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Change: External},
-				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 6}, Change: External},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External}, // should detect the gap
+				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Path: []uint32{0, 3}, Change: External},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Path: []uint32{0, 4}, Change: External},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Path: []uint32{0, 5}, Change: External},
+				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 6}, Path: []uint32{0, 6}, Change: External},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External},
 		},
 		{
 			name:   "mark 0/1 as used",
@@ -434,13 +562,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: External,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Change: External}, // should have no gaps
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Change: External},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Change: External},
-				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 6}, Change: External},
-				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 7}, Change: External},
+				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Path: []uint32{0, 3}, Change: External}, // should have no gaps
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 4}, Path: []uint32{0, 4}, Change: External},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 5}, Path: []uint32{0, 5}, Change: External},
+				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 6}, Path: []uint32{0, 6}, Change: External},
+				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 7}, Path: []uint32{0, 7}, Change: External},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Change: External},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 3}, Path: []uint32{0, 3}, Change: External},
 		},
 		{
 			// internal chain should be unaffected by previous mutations
@@ -449,13 +577,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: Internal,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Change: Internal},
-				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 3}, Change: Internal},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Change: Internal},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Change: Internal},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
+				{Address: "deadbeef03-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 3}, Path: []uint32{1, 3}, Change: Internal},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Path: []uint32{1, 4}, Change: Internal},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Path: []uint32{1, 5}, Change: Internal},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
 		},
 		{
 			name:   "mark 1/3 as used",
@@ -463,13 +591,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: Internal,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Change: Internal},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Change: Internal},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Change: Internal},
-				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 6}, Change: Internal},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Path: []uint32{1, 4}, Change: Internal},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Path: []uint32{1, 5}, Change: Internal},
+				{Address: "deadbeef06-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 6}, Path: []uint32{1, 6}, Change: Internal},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
 		},
 		{
 			name:   "mark 1/6 as used",
@@ -477,13 +605,13 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: Internal,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Change: Internal},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Change: Internal},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Change: Internal},
-				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 7}, Change: Internal},
+				{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Path: []uint32{1, 4}, Change: Internal},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Path: []uint32{1, 5}, Change: Internal},
+				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 7}, Path: []uint32{1, 7}, Change: Internal},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Change: Internal},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 1}, Path: []uint32{1, 1}, Change: Internal},
 		},
 		{
 			name:   "mark 1/1 as used",
@@ -491,23 +619,23 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 			change: Internal,
 			size:   5,
 			wantFreshAddresses: []AddressInfo{
-				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Change: Internal},
-				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Change: Internal},
-				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Change: Internal},
-				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 7}, Change: Internal},
-				{Address: "deadbeef08-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 8}, Change: Internal},
+				{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
+				{Address: "deadbeef04-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 4}, Path: []uint32{1, 4}, Change: Internal},
+				{Address: "deadbeef05-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 5}, Path: []uint32{1, 5}, Change: Internal},
+				{Address: "deadbeef07-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 7}, Path: []uint32{1, 7}, Change: Internal},
+				{Address: "deadbeef08-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 8}, Path: []uint32{1, 8}, Change: Internal},
 			},
-			wantFreshAddress: &AddressInfo{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Change: Internal},
+			wantFreshAddress: &AddressInfo{Address: "deadbeef02-BIP84-bitcoin_mainnet", Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
 		},
 	}
 
 	for _, tt := range workflow {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := keystore.MarkPathAsUsed(info.ID, tt.path); err != nil {
+			if err := keystore.MarkPathAsUsed(context.Background(), info.ID, tt.path); err != nil {
 				t.Fatalf("MarkPathAsUsed() unexpected error: %v", err)
 			}
 
-			gotBulk, err := keystore.GetFreshAddresses(info.ID, tt.change, tt.size)
+			gotBulk, err := keystore.GetFreshAddresses(context.Background(), info.ID, tt.change, tt.size)
 			if err != nil {
 				t.Fatalf("GetFreshAddresses() unexpected error: %v", err)
 			}
@@ -517,7 +645,7 @@ func TestInMemoryKeystore_MarkPathAsUsed(t *testing.T) {
 					gotBulk, tt.wantFreshAddresses)
 			}
 
-			got, err := keystore.GetFreshAddress(info.ID, tt.change)
+			got, err := keystore.GetFreshAddress(context.Background(), info.ID, tt.change)
 			if err != nil {
 				t.Fatalf("GetFreshAddress() unexpected error: %v", err)
 			}
@@ -539,12 +667,12 @@ func TestInMemoryKeystore_GetAddressesPublicKeys(t *testing.T) {
 		network     chaincfg.Network
 		size        uint32
 		derivations []DerivationPath
-		want        []string
+		want        [][]string
 		wantErr     error
 	}{
 		{
 			name:        "p2pkh mainnet multi (change: external)",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			change:      External,
 			scheme:      BIP84,
 			network:     chaincfg.BitcoinMainnet,
@@ -556,18 +684,18 @@ func TestInMemoryKeystore_GetAddressesPublicKeys(t *testing.T) {
 				{0, 3},
 				{0, 4},
 			},
-			want: []string{
-				"deadbeef00",
-				"deadbeef01",
-				"deadbeef02",
-				"deadbeef03",
-				"deadbeef04",
+			want: [][]string{
+				{"deadbeef00"},
+				{"deadbeef01"},
+				{"deadbeef02"},
+				{"deadbeef03"},
+				{"deadbeef04"},
 			},
 		},
 		// internal chain should return the same public keys
 		{
 			name:        "p2pkh mainnet multi (change: internal)",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			change:      Internal,
 			scheme:      BIP84,
 			network:     chaincfg.BitcoinMainnet,
@@ -579,17 +707,17 @@ func TestInMemoryKeystore_GetAddressesPublicKeys(t *testing.T) {
 				{1, 3},
 				{1, 4},
 			},
-			want: []string{
-				"deadbeef00",
-				"deadbeef01",
-				"deadbeef02",
-				"deadbeef03",
-				"deadbeef04",
+			want: [][]string{
+				{"deadbeef00"},
+				{"deadbeef01"},
+				{"deadbeef02"},
+				{"deadbeef03"},
+				{"deadbeef04"},
 			},
 		},
 		{
 			name:        "p2pkh mainnet multi (wrong given derivations)",
-			extendedKey: "xpub1111",
+			extendedKey: mockXPub,
 			change:      Internal,
 			scheme:      BIP84,
 			network:     chaincfg.BitcoinMainnet,
@@ -608,18 +736,18 @@ func TestInMemoryKeystore_GetAddressesPublicKeys(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			keystore := NewMockInMemoryKeystore()
 			info, err := keystore.Create(
-				tt.extendedKey, nil, tt.scheme, tt.network, DefaultLookaheadSize, 1, "")
+				context.Background(), tt.extendedKey, nil, nil, "", "", tt.scheme, tt.network, DefaultLookaheadSize, 1, "")
 			if err != nil {
 				panic(err)
 			}
 
 			// Firstly, call get fresh addresses to derive addresses
-			_, err = keystore.GetFreshAddresses(info.ID, tt.change, tt.size)
+			_, err = keystore.GetFreshAddresses(context.Background(), info.ID, tt.change, tt.size)
 			if err != nil {
 				t.Fatalf("GetFreshAddresses() unexpected error: %v", err)
 			}
 
-			got, err := keystore.GetAddressesPublicKeys(info.ID, tt.derivations)
+			got, err := keystore.GetAddressesPublicKeys(context.Background(), info.ID, tt.derivations)
 			if err != nil && tt.wantErr == nil {
 				t.Fatalf("GetAddressesPublicKeys() unexpected error: %v", err)
 			}
@@ -646,7 +774,7 @@ func TestInMemoryKeystore_Reset(t *testing.T) {
 	keystore := NewMockInMemoryKeystore()
 
 	info, err := keystore.Create(
-		"xpub1111", nil, BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
 	if err != nil {
 		panic(err)
 	}
@@ -663,18 +791,18 @@ func TestInMemoryKeystore_Reset(t *testing.T) {
 			name:                        "mark 0/0 as used then reset",
 			path:                        DerivationPath{0, 0},
 			change:                      External,
-			wantFreshAddressBeforeReset: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Change: External},
-			wantFreshAddressAfterReset:  &AddressInfo{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Change: External},
+			wantFreshAddressBeforeReset: &AddressInfo{Address: "deadbeef01-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}, Change: External},
+			wantFreshAddressAfterReset:  &AddressInfo{Address: "deadbeef00-BIP84-bitcoin_mainnet", Derivation: DerivationPath{0, 0}, Path: []uint32{0, 0}, Change: External},
 		},
 	}
 
 	for _, tt := range workflow {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := keystore.MarkPathAsUsed(info.ID, tt.path); err != nil {
+			if err := keystore.MarkPathAsUsed(context.Background(), info.ID, tt.path); err != nil {
 				t.Fatalf("MarkPathAsUsed() unexpected error: %v", err)
 			}
 
-			got, err := keystore.GetFreshAddress(info.ID, tt.change)
+			got, err := keystore.GetFreshAddress(context.Background(), info.ID, tt.change)
 			if err != nil {
 				t.Fatalf("GetFreshAddress() unexpected error: %v", err)
 			}
@@ -684,12 +812,12 @@ func TestInMemoryKeystore_Reset(t *testing.T) {
 					got, tt.wantFreshAddressBeforeReset)
 			}
 
-			err = keystore.Reset(info.ID)
+			err = keystore.Reset(context.Background(), info.ID)
 			if err != nil {
 				t.Fatalf("Reset() unexpected error: %v", err)
 			}
 
-			gotAfterReset, err := keystore.GetFreshAddress(info.ID, tt.change)
+			gotAfterReset, err := keystore.GetFreshAddress(context.Background(), info.ID, tt.change)
 			if err != nil {
 				t.Fatalf("GetFreshAddress() unexpected error: %v", err)
 			}
@@ -701,3 +829,835 @@ func TestInMemoryKeystore_Reset(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryKeystore_GetAllObservableAddresses(t *testing.T) {
+	keystore := NewMockInMemoryKeystore()
+
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP44, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		fromIndex uint32
+		toIndex   uint32
+		want      int
+	}{
+		{
+			name:      "single address",
+			fromIndex: 3,
+			toIndex:   3,
+			want:      1,
+		},
+		{
+			name:      "to past max observable index",
+			fromIndex: 18,
+			toIndex:   100,
+			want:      2,
+		},
+		{
+			name:      "from past max observable index",
+			fromIndex: 30,
+			toIndex:   100,
+			want:      0,
+		},
+		{
+			name:      "from above to",
+			fromIndex: 5,
+			toIndex:   2,
+			want:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keystore.GetAllObservableAddresses(
+				context.Background(), info.ID, External, tt.fromIndex, tt.toIndex)
+			if err != nil {
+				t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+			}
+
+			if got == nil || len(got) != tt.want {
+				t.Fatalf("GetAllObservableAddresses() got %d addresses, want %d", len(got), tt.want)
+			}
+
+			for i, addr := range got {
+				if addr.Derivation != (DerivationPath{0, tt.fromIndex + uint32(i)}) {
+					t.Fatalf("GetAllObservableAddresses() got = %v", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryKeystore_MarkAddressesAsUsed(t *testing.T) {
+	keystore := NewMockInMemoryKeystore()
+
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	addrs, err := keystore.GetAllObservableAddresses(context.Background(), info.ID, External, 0, 3)
+	if err != nil {
+		t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+	}
+
+	// Addresses of the mock client are the same on both chains, so the
+	// expected derivation paths are read from the keystore.
+	paths := map[string]DerivationPath{}
+
+	for _, addr := range addrs {
+		path, err := keystore.GetDerivationPath(context.Background(), info.ID, addr.Address)
+		if err != nil {
+			t.Fatalf("GetDerivationPath() unexpected error: %v", err)
+		}
+
+		paths[addr.Address] = path
+	}
+
+	workflow := []struct {
+		name      string
+		addresses []string
+		want      []MarkStatus
+		wantMax   uint32
+	}{
+		{
+			name:      "mark with a gap, a duplicate and an unknown address",
+			addresses: []string{addrs[0].Address, addrs[2].Address, addrs[0].Address, "unknown"},
+			want:      []MarkStatus{Marked, Marked, AlreadyUsed, MarkUnknownAddress},
+			wantMax:   1,
+		},
+		{
+			name:      "mark used addresses again",
+			addresses: []string{addrs[2].Address, addrs[0].Address},
+			want:      []MarkStatus{AlreadyUsed, AlreadyUsed},
+			wantMax:   1,
+		},
+		{
+			name:      "fill the gap",
+			addresses: []string{addrs[1].Address},
+			want:      []MarkStatus{Marked},
+			wantMax:   3,
+		},
+		{
+			name:    "empty batch",
+			want:    []MarkStatus{},
+			wantMax: 3,
+		},
+	}
+
+	for _, tt := range workflow {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := keystore.MarkAddressesAsUsed(context.Background(), info.ID, tt.addresses)
+			if err != nil {
+				t.Fatalf("MarkAddressesAsUsed() unexpected error: %v", err)
+			}
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("MarkAddressesAsUsed() got %d results, want %d", len(results), len(tt.want))
+			}
+
+			for i, result := range results {
+				want := MarkResult{
+					Address:    tt.addresses[i],
+					Derivation: paths[tt.addresses[i]],
+					Status:     tt.want[i],
+				}
+
+				if want.Status != MarkUnknownAddress {
+					want.Path = want.Derivation.ToSlice()
+				}
+
+				if !reflect.DeepEqual(result, want) {
+					t.Fatalf("MarkAddressesAsUsed() result %d = %v, want %v", i, result, want)
+				}
+			}
+
+			got, err := keystore.Get(context.Background(), info.ID)
+			if err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+
+			meta := Meta{Main: got}
+
+			max, err := meta.MaxConsecutiveIndex(paths[addrs[0].Address].ChangeIndex())
+			if err != nil {
+				t.Fatalf("MaxConsecutiveIndex() unexpected error: %v", err)
+			}
+
+			if max != tt.wantMax {
+				t.Fatalf("MaxConsecutiveIndex() = %d, want %d", max, tt.wantMax)
+			}
+		})
+	}
+
+	_, err = keystore.MarkAddressesAsUsed(context.Background(), uuid.New(), []string{addrs[0].Address})
+	if errors.Cause(err) != ErrKeychainNotFound {
+		t.Fatalf("MarkAddressesAsUsed() error = %v, want %v", err, ErrKeychainNotFound)
+	}
+}
+
+func TestInMemoryKeystore_DiscoverAddresses(t *testing.T) {
+	// mockAddress is the address at the given index of both chains.
+	mockAddress := func(index uint32) string {
+		return fmt.Sprintf("deadbeef%02x-BIP84-bitcoin_mainnet", index)
+	}
+
+	tests := []struct {
+		name        string
+		searchLimit uint32
+		index       uint32
+		want        MarkResult
+		wantOwner   bool
+	}{
+		{
+			name:        "discovery disabled",
+			searchLimit: 0,
+			index:       25,
+			want:        MarkResult{Address: mockAddress(25), Status: MarkUnknownAddress},
+		},
+		{
+			name:        "within the search limit",
+			searchLimit: 10,
+			index:       25,
+			want: MarkResult{
+				Address:    mockAddress(25),
+				Derivation: DerivationPath{0, 25},
+				Path:       []uint32{0, 25},
+				Status:     Marked,
+			},
+			wantOwner: true,
+		},
+		{
+			name:        "at the search limit",
+			searchLimit: 10,
+			index:       29,
+			want: MarkResult{
+				Address:    mockAddress(29),
+				Derivation: DerivationPath{0, 29},
+				Path:       []uint32{0, 29},
+				Status:     Marked,
+			},
+			wantOwner: true,
+		},
+		{
+			name:        "beyond the search limit",
+			searchLimit: 10,
+			index:       30,
+			want:        MarkResult{Address: mockAddress(30), Status: MarkUnknownAddress},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keystore := NewMockInMemoryKeystore()
+			keystore.(AddressSearcher).SetAddressSearchLimit(tt.searchLimit)
+
+			// The observable range of both chains is [0..19].
+			info, err := keystore.Create(
+				context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+			if err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+
+			results, err := keystore.MarkAddressesAsUsed(
+				context.Background(), info.ID, []string{mockAddress(tt.index)})
+			if err != nil {
+				t.Fatalf("MarkAddressesAsUsed() unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(results, []MarkResult{tt.want}) {
+				t.Fatalf("MarkAddressesAsUsed() = %v, want %v", results, []MarkResult{tt.want})
+			}
+
+			owners, err := keystore.FindAddressOwner(
+				context.Background(), []string{mockAddress(tt.index)})
+			if err != nil {
+				t.Fatalf("FindAddressOwner() unexpected error: %v", err)
+			}
+
+			if (owners[0] != nil) != tt.wantOwner {
+				t.Fatalf("FindAddressOwner() = %v, want owner %v", owners[0], tt.wantOwner)
+			}
+
+			// Addresses derived while searching are not recorded.
+			_, err = keystore.GetDerivationPath(
+				context.Background(), info.ID, mockAddress(tt.index-1))
+			if errors.Cause(err) != ErrAddressNotFound {
+				t.Fatalf("GetDerivationPath() error = %v, want %v", err, ErrAddressNotFound)
+			}
+
+			err = keystore.MarkAddressAsUsed(context.Background(), info.ID, mockAddress(tt.index))
+			if tt.want.Status == MarkUnknownAddress && errors.Cause(err) != ErrAddressNotFound {
+				t.Fatalf("MarkAddressAsUsed() error = %v, want %v", err, ErrAddressNotFound)
+			}
+
+			if tt.want.Status == Marked && err != nil {
+				t.Fatalf("MarkAddressAsUsed() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMeta_MarkAddressesAsUsedFailure(t *testing.T) {
+	keystore := NewMockInMemoryKeystore().(*InMemoryKeystore)
+
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	meta := keystore.db[info.ID].meta
+
+	// The address at index 25 is discovered and marked, then the mark of
+	// the address at an invalid derivation path fails.
+	discovered := "deadbeef19-BIP84-bitcoin_mainnet"
+	paths := map[string]DerivationPath{"invalid": {5, 0}}
+
+	_, _, err = meta.keystoreMarkAddressesAsUsed(
+		context.Background(), keystore.client, []string{discovered, "invalid"}, paths, 10)
+	if errors.Cause(err) != ErrUnrecognizedChange {
+		t.Fatalf("keystoreMarkAddressesAsUsed() error = %v, wantErr %v", err, ErrUnrecognizedChange)
+	}
+
+	if !reflect.DeepEqual(meta.Main, info) {
+		t.Fatalf("keystoreMarkAddressesAsUsed() changed keychain to %v, want %v", meta.Main, info)
+	}
+
+	if len(meta.Addresses) != 0 || len(meta.Derivations) != 0 {
+		t.Fatalf("keystoreMarkAddressesAsUsed() recorded addresses %v, derivations %v",
+			meta.Addresses, meta.Derivations)
+	}
+}
+
+// oracleFunc is an AddressUsageOracle implemented by a function.
+type oracleFunc func(ctx context.Context, addresses []string) ([]bool, error)
+
+func (f oracleFunc) AddressesUsed(ctx context.Context, addresses []string) ([]bool, error) {
+	return f(ctx, addresses)
+}
+
+func TestInMemoryKeystore_DiscoverKeychain(t *testing.T) {
+	// Addresses of the mock client are the same on both chains.
+	mockAddress := func(index uint32) string {
+		return fmt.Sprintf("deadbeef%02x-BIP84-bitcoin_mainnet", index)
+	}
+
+	tests := []struct {
+		name    string
+		oracle  AddressUsageOracle
+		want    []AddressInfo
+		wantErr error
+	}{
+		{
+			name:   "no used address",
+			oracle: oracle.NewMemory(),
+			want:   nil,
+		},
+		{
+			// The gap between 2 and 7 is shorter than the lookahead, unlike
+			// the one between 7 and 13.
+			name:   "used addresses within the gap limit",
+			oracle: oracle.NewMemory(mockAddress(2), mockAddress(7), mockAddress(13)),
+			want: []AddressInfo{
+				{Address: mockAddress(2), Derivation: DerivationPath{0, 2}, Path: []uint32{0, 2}, Change: External},
+				{Address: mockAddress(7), Derivation: DerivationPath{0, 7}, Path: []uint32{0, 7}, Change: External},
+				{Address: mockAddress(2), Derivation: DerivationPath{1, 2}, Path: []uint32{1, 2}, Change: Internal},
+				{Address: mockAddress(7), Derivation: DerivationPath{1, 7}, Path: []uint32{1, 7}, Change: Internal},
+			},
+		},
+		{
+			name: "invalid usage",
+			oracle: oracleFunc(func(ctx context.Context, addresses []string) ([]bool, error) {
+				return []bool{true}, nil
+			}),
+			wantErr: ErrInvalidAddressUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keystore := NewMockInMemoryKeystore()
+
+			info, err := keystore.Create(
+				context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, 5, 1, "")
+			if err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+
+			got, err := keystore.DiscoverKeychain(context.Background(), info.ID, tt.oracle)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("DiscoverKeychain() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DiscoverKeychain() = %v, want %v", got, tt.want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			for _, change := range []Change{External, Internal} {
+				fresh, err := keystore.GetFreshAddress(context.Background(), info.ID, change)
+				if err != nil {
+					t.Fatalf("GetFreshAddress() unexpected error: %v", err)
+				}
+
+				if fresh.Derivation.AddressIndex() != 0 {
+					t.Fatalf("GetFreshAddress() = %v, want index 0", fresh.Derivation)
+				}
+			}
+
+			owners, err := keystore.FindAddressOwner(context.Background(), []string{mockAddress(4)})
+			if err != nil {
+				t.Fatalf("FindAddressOwner() unexpected error: %v", err)
+			}
+
+			if owners[0] == nil {
+				t.Fatalf("FindAddressOwner() = nil, want addresses scanned to be indexed")
+			}
+		})
+	}
+
+	_, err := NewMockInMemoryKeystore().DiscoverKeychain(
+		context.Background(), uuid.New(), oracle.NewMemory())
+	if errors.Cause(err) != ErrKeychainNotFound {
+		t.Fatalf("DiscoverKeychain() error = %v, want %v", err, ErrKeychainNotFound)
+	}
+}
+
+// TestInMemoryKeystore_Concurrent exercises the keystore from concurrent
+// goroutines, and is meant to be run with the race detector.
+func TestInMemoryKeystore_Concurrent(t *testing.T) {
+	const workers = 20
+
+	keystore := NewMockInMemoryKeystore()
+
+	var ids []KeychainInfo
+
+	for _, xpub := range []string{mockXPub, mockXPub2} {
+		info, err := keystore.Create(
+			context.Background(), xpub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, workers, 1, "")
+		if err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		ids = append(ids, info)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, len(ids)*workers*3)
+
+	for _, info := range ids {
+		addrs, err := keystore.GetAllObservableAddresses(context.Background(), info.ID, External, 0, workers-1)
+		if err != nil {
+			t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+		}
+
+		for _, addr := range addrs {
+			wg.Add(3)
+
+			go func(info KeychainInfo, address string) {
+				defer wg.Done()
+				errs <- keystore.MarkAddressAsUsed(context.Background(), info.ID, address)
+			}(info, addr.Address)
+
+			go func(info KeychainInfo) {
+				defer wg.Done()
+				_, err := keystore.GetAllObservableAddresses(context.Background(), info.ID, External, 0, 2*workers)
+				errs <- err
+			}(info)
+
+			go func(info KeychainInfo) {
+				defer wg.Done()
+
+				if _, err := keystore.Get(context.Background(), info.ID); err != nil {
+					errs <- err
+					return
+				}
+
+				_, err := keystore.GetFreshAddresses(context.Background(), info.ID, External, 5)
+				errs <- err
+			}(info)
+		}
+	}
+
+	// Create and delete another keychain, while the others are updated.
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < workers; i++ {
+			info, err := keystore.Create(
+				context.Background(), mockXPub, nil, nil, "", "", BIP44, chaincfg.BitcoinMainnet, workers, 1, "")
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if err := keystore.Delete(context.Background(), info.ID); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, info := range ids {
+		got, err := keystore.GetFreshAddress(context.Background(), info.ID, External)
+		if err != nil {
+			t.Fatalf("GetFreshAddress() unexpected error: %v", err)
+		}
+
+		want := DerivationPath{0, workers}
+		if got.Derivation != want {
+			t.Fatalf("GetFreshAddress() got derivation %v, want %v",
+				got.Derivation, want)
+		}
+	}
+}
+
+// TestInMemoryKeystore_DeleteRace deletes keychains while their addresses
+// are derived, and checks that no address of a deleted keychain is left in
+// the address index.
+func TestInMemoryKeystore_DeleteRace(t *testing.T) {
+	const workers = 8
+
+	keystore := NewMockInMemoryKeystore().(*InMemoryKeystore)
+
+	for i := 0; i < 100; i++ {
+		info, err := keystore.Create(
+			context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+		if err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		var wg sync.WaitGroup
+
+		start := make(chan struct{})
+		errs := make(chan error, workers+1)
+
+		for j := 0; j < workers; j++ {
+			wg.Add(1)
+
+			go func(size uint32) {
+				defer wg.Done()
+				<-start
+
+				// Calls may run before or after the deletion.
+				_, err := keystore.GetFreshAddresses(context.Background(), info.ID, External, size)
+				if err != nil && errors.Cause(err) != ErrKeychainNotFound {
+					errs <- errors.Errorf("GetFreshAddresses() unexpected error: %v", err)
+				}
+			}(uint32(j + 1))
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if err := keystore.Delete(context.Background(), info.ID); err != nil {
+				errs <- errors.Errorf("Delete() unexpected error: %v", err)
+			}
+		}()
+
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		for addr, owner := range keystore.owners {
+			if owner.KeychainID == info.ID {
+				t.Fatalf("address %s of deleted keychain %s is indexed", addr, info.ID)
+			}
+		}
+	}
+
+	// The race above is unlikely on a single CPU, so a call which got the
+	// keychain before its deletion, and locks it after, is also simulated.
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	document := keystore.db[info.ID]
+
+	if err := keystore.Delete(context.Background(), info.ID); err != nil {
+		t.Fatalf("Delete() unexpected error: %v", err)
+	}
+
+	keystore.db[info.ID] = document
+
+	_, err = keystore.GetFreshAddresses(context.Background(), info.ID, External, 1)
+	if errors.Cause(err) != ErrKeychainNotFound {
+		t.Fatalf("GetFreshAddresses() error = %v, wantErr %v", err, ErrKeychainNotFound)
+	}
+
+	if len(keystore.owners) != 0 {
+		t.Fatalf("addresses of deleted keychains are indexed: %v", keystore.owners)
+	}
+}
+
+func TestInMemoryKeystore_CancelledContext(t *testing.T) {
+	keystore := NewMockInMemoryKeystore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := keystore.Create(
+		ctx, mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, ""); errors.Cause(err) != context.Canceled {
+		t.Fatalf("Create() error = %v, wantErr %v", err, context.Canceled)
+	}
+
+	info, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	if _, err := keystore.GetFreshAddresses(ctx, info.ID, External, 2); errors.Cause(err) != context.Canceled {
+		t.Fatalf("GetFreshAddresses() error = %v, wantErr %v", err, context.Canceled)
+	}
+
+	if _, err := keystore.GetAllObservableAddresses(ctx, info.ID, External, 0, 4); errors.Cause(err) != context.Canceled {
+		t.Fatalf("GetAllObservableAddresses() error = %v, wantErr %v", err, context.Canceled)
+	}
+}
+
+func TestInMemoryKeystore_FindAddressOwner(t *testing.T) {
+	keystore := NewMockInMemoryKeystore()
+
+	segwit, err := keystore.Create(
+		context.Background(), mockXPub, nil, nil, "", "", BIP84, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	legacy, err := keystore.Create(
+		context.Background(), mockXPub2, nil, nil, "", "", BIP44, chaincfg.BitcoinMainnet, DefaultLookaheadSize, 1, "")
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	if _, err := keystore.GetFreshAddresses(context.Background(), segwit.ID, External, 2); err != nil {
+		t.Fatalf("GetFreshAddresses() unexpected error: %v", err)
+	}
+
+	if _, err := keystore.GetAllObservableAddresses(context.Background(), legacy.ID, Internal, 3, 4); err != nil {
+		t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+	}
+
+	addresses := []string{
+		"deadbeef01-BIP84-bitcoin_mainnet",
+		"unknown",
+		"deadbeef03-BIP44-bitcoin_mainnet",
+		"deadbeef05-BIP44-bitcoin_mainnet",
+	}
+
+	workflow := []struct {
+		name   string
+		update func() error
+		want   []*AddressOwner
+	}{
+		{
+			name:   "derived addresses",
+			update: func() error { return nil },
+			want: []*AddressOwner{
+				{KeychainID: segwit.ID, Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}},
+				nil,
+				{KeychainID: legacy.ID, Derivation: DerivationPath{1, 3}, Path: []uint32{1, 3}},
+				nil,
+			},
+		},
+		{
+			name:   "delete keychain",
+			update: func() error { return keystore.Delete(context.Background(), legacy.ID) },
+			want: []*AddressOwner{
+				{KeychainID: segwit.ID, Derivation: DerivationPath{0, 1}, Path: []uint32{0, 1}},
+				nil,
+				nil,
+				nil,
+			},
+		},
+		{
+			name:   "reset keychain",
+			update: func() error { return keystore.Reset(context.Background(), segwit.ID) },
+			want:   []*AddressOwner{nil, nil, nil, nil},
+		},
+	}
+
+	for _, tt := range workflow {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.update(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := keystore.FindAddressOwner(context.Background(), addresses)
+			if err != nil {
+				t.Fatalf("FindAddressOwner() unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FindAddressOwner() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryKeystore_ListKeychains(t *testing.T) {
+	const mockTPub = "tpubDCxX2sYFS5bDkSe5GKKYHjBW7tgyN1R3UchpLJvdbf54ohxeGRtd8MbDUe1cguVHe4vnK68DsuD5MXjxi9EXx16rb9EnNsaF5KT99CinaJz"
+
+	keystore := NewMockInMemoryKeystore()
+
+	keychains := []struct {
+		extendedKey string
+		scheme      Scheme
+		network     chaincfg.Network
+		metadata    string
+	}{
+		{mockXPub, BIP44, chaincfg.BitcoinMainnet, "wd:workspace1"},
+		{mockXPub, BIP49, chaincfg.BitcoinMainnet, "wd:workspace1"},
+		{mockXPub, BIP84, chaincfg.BitcoinMainnet, "wd:workspace2"},
+		{mockXPub2, BIP84, chaincfg.BitcoinMainnet, ""},
+		{mockTPub, BIP84, chaincfg.BitcoinTestnet3, "wd:workspace1"},
+	}
+
+	for _, k := range keychains {
+		if _, err := keystore.Create(
+			context.Background(), k.extendedKey, nil, nil, "", "", k.scheme, k.network, DefaultLookaheadSize, 0, k.metadata,
+		); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		filter    KeychainFilter
+		limit     uint32
+		wantCount int
+		wantPages int
+	}{
+		{
+			name:      "all keychains",
+			limit:     0,
+			wantCount: 5,
+			wantPages: 1,
+		},
+		{
+			name:      "all keychains by pages",
+			limit:     2,
+			wantCount: 5,
+			wantPages: 3,
+		},
+		{
+			name:      "by network",
+			filter:    KeychainFilter{Network: chaincfg.BitcoinTestnet3},
+			limit:     2,
+			wantCount: 1,
+			wantPages: 1,
+		},
+		{
+			name:      "by scheme",
+			filter:    KeychainFilter{Scheme: BIP84},
+			limit:     1,
+			wantCount: 3,
+			wantPages: 3,
+		},
+		{
+			name:      "by metadata prefix",
+			filter:    KeychainFilter{MetadataPrefix: "wd:workspace1"},
+			limit:     10,
+			wantCount: 3,
+			wantPages: 1,
+		},
+		{
+			name: "by all filters",
+			filter: KeychainFilter{
+				Network:        chaincfg.BitcoinMainnet,
+				Scheme:         BIP84,
+				MetadataPrefix: "wd:",
+			},
+			wantCount: 1,
+			wantPages: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got    []KeychainInfo
+				cursor string
+				pages  int
+			)
+
+			for {
+				infos, next, err := keystore.ListKeychains(context.Background(), tt.filter, cursor, tt.limit)
+				if err != nil {
+					t.Fatalf("ListKeychains() unexpected error: %v", err)
+				}
+
+				if tt.limit != 0 && uint32(len(infos)) > tt.limit {
+					t.Fatalf("ListKeychains() got %d keychains, want at most %d",
+						len(infos), tt.limit)
+				}
+
+				got = append(got, infos...)
+				pages++
+
+				if next == "" {
+					break
+				}
+
+				cursor = next
+			}
+
+			if len(got) != tt.wantCount {
+				t.Fatalf("ListKeychains() got %d keychains, want %d", len(got), tt.wantCount)
+			}
+
+			if pages != tt.wantPages {
+				t.Fatalf("ListKeychains() got %d pages, want %d", pages, tt.wantPages)
+			}
+
+			seen := map[string]bool{}
+
+			for _, info := range got {
+				if !tt.filter.Match(info) {
+					t.Fatalf("ListKeychains() got keychain %v not matching filter", info.ID)
+				}
+
+				if seen[info.ID.String()] {
+					t.Fatalf("ListKeychains() got keychain %v twice", info.ID)
+				}
+
+				seen[info.ID.String()] = true
+			}
+		})
+	}
+
+	if _, _, err := keystore.ListKeychains(context.Background(), KeychainFilter{}, "invalid", 0); errors.Cause(err) != ErrInvalidCursor {
+		t.Fatalf("ListKeychains() error = %v, wantErr %v", err, ErrInvalidCursor)
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+)
+
+var cosigners = []string{
+	"xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+	"xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+	"xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ",
+}
+
+func TestInMemoryKeystore_CreateMultisig(t *testing.T) {
+	tests := []struct {
+		name               string
+		extendedPublicKeys []string
+		threshold          uint32
+		scheme             Scheme
+		wantDescriptor     string
+		wantErr            error
+	}{
+		{
+			name:               "2-of-3 p2wsh",
+			extendedPublicKeys: cosigners,
+			threshold:          2,
+			scheme:             MultisigP2WSH,
+			wantDescriptor:     "wsh(sortedmulti(2," + cosigners[2] + "/0/*," + cosigners[1] + "/0/*," + cosigners[0] + "/0/*))#3s36u7jk",
+		},
+		{
+			name:               "2-of-3 p2sh-p2wsh",
+			extendedPublicKeys: cosigners,
+			threshold:          2,
+			scheme:             MultisigP2SHP2WSH,
+			wantDescriptor:     "sh(wsh(sortedmulti(2," + cosigners[2] + "/0/*," + cosigners[1] + "/0/*," + cosigners[0] + "/0/*)))#mgtq80qu",
+		},
+		{
+			name:               "1-of-2 p2sh",
+			extendedPublicKeys: cosigners[:2],
+			threshold:          1,
+			scheme:             MultisigP2SH,
+			wantDescriptor:     "sh(sortedmulti(1," + cosigners[1] + "/0/*," + cosigners[0] + "/0/*))#3uxjfl0z",
+		},
+		{
+			name:               "threshold above cosigners",
+			extendedPublicKeys: cosigners,
+			threshold:          4,
+			scheme:             MultisigP2WSH,
+			wantErr:            ErrInvalidMultisig,
+		},
+		{
+			name:               "duplicate cosigner",
+			extendedPublicKeys: []string{cosigners[0], cosigners[0]},
+			threshold:          2,
+			scheme:             MultisigP2WSH,
+			wantErr:            ErrInvalidMultisig,
+		},
+		{
+			// Zpub serialization of cosigners[1].
+			name:               "duplicate cosigner in slip-0132 format",
+			extendedPublicKeys: []string{cosigners[1], "Zpub72vG5KcRLKBJTi517jZQKb3htapKHBkt5svW1qHzNDwYapfZ9eQvi7LKqSJf7frSXihrRpvonQKz2exZwzT333snwEUbXHscfwSwBKPYd5N"},
+			threshold:          1,
+			scheme:             MultisigP2WSH,
+			wantErr:            ErrInvalidMultisig,
+		},
+		{
+			name:               "single-key scheme",
+			extendedPublicKeys: cosigners,
+			threshold:          2,
+			scheme:             BIP84,
+			wantErr:            ErrUnrecognizedScheme,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keystore := NewInMemoryKeystore(native.NewCoinServiceClient())
+
+			got, err := keystore.CreateMultisig(
+				context.Background(), tt.extendedPublicKeys, tt.threshold, "", "", tt.scheme,
+				chaincfg.BitcoinMainnet, DefaultLookaheadSize, 0, "")
+			if err != nil && errors.Cause(err) != tt.wantErr {
+				t.Fatalf("CreateMultisig() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+
+			if err == nil && tt.wantErr != nil {
+				t.Fatalf("CreateMultisig() got no error, want '%v'", tt.wantErr)
+			}
+
+			if got.ExternalDescriptor != tt.wantDescriptor {
+				t.Fatalf("CreateMultisig() got descriptor = %v, want = %v",
+					got.ExternalDescriptor, tt.wantDescriptor)
+			}
+		})
+	}
+}
+
+func TestInMemoryKeystore_MultisigAddresses(t *testing.T) {
+	keystore := NewInMemoryKeystore(native.NewCoinServiceClient())
+
+	info, err := keystore.CreateMultisig(
+		context.Background(), cosigners, 2, "", "", MultisigP2WSH, chaincfg.BitcoinMainnet,
+		DefaultLookaheadSize, 0, "")
+	if err != nil {
+		t.Fatalf("CreateMultisig() unexpected error: %v", err)
+	}
+
+	// The order of cosigners must not change the keychain.
+	reversed := []string{cosigners[2], cosigners[1], cosigners[0]}
+
+	other, err := keystore.CreateMultisig(
+		context.Background(), reversed, 2, "", "", MultisigP2WSH, chaincfg.BitcoinMainnet,
+		DefaultLookaheadSize, 0, "")
+	if err != nil {
+		t.Fatalf("CreateMultisig() unexpected error: %v", err)
+	}
+
+	if info.ID != other.ID {
+		t.Fatalf("CreateMultisig() got ID = %v, want = %v", other.ID, info.ID)
+	}
+
+	if err := keystore.MarkPathAsUsed(context.Background(), info.ID, DerivationPath{0, 0}); err != nil {
+		t.Fatalf("MarkPathAsUsed() unexpected error: %v", err)
+	}
+
+	fresh, err := keystore.GetFreshAddress(context.Background(), info.ID, External)
+	if err != nil {
+		t.Fatalf("GetFreshAddress() unexpected error: %v", err)
+	}
+
+	if fresh.Derivation != (DerivationPath{0, 1}) {
+		t.Fatalf("GetFreshAddress() got derivation = %v, want = %v",
+			fresh.Derivation, DerivationPath{0, 1})
+	}
+
+	observable, err := keystore.GetAllObservableAddresses(context.Background(), info.ID, Internal, 0, 3)
+	if err != nil {
+		t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+	}
+
+	// Reference address computed independently from the cosigners.
+	if want := "bc1q0cfzdr4hgdrw6u5j8hcq9g9ddzyy3nwnrh8wlzvy2hengyff34sq9uxa6p"; observable[3].Address != want {
+		t.Fatalf("GetAllObservableAddresses() got = %v, want = %v",
+			observable[3].Address, want)
+	}
+
+	// Derive the first external address after all other derivations, which
+	// must not alter it.
+	addrs, err := keystore.GetAllObservableAddresses(context.Background(), info.ID, External, 0, 0)
+	if err != nil {
+		t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+	}
+
+	if want := "bc1q6aayj6rp0zzj474awz354xcnrdre7ffwx6fgeeal57myy286slzqvgzn5z"; addrs[0].Address != want {
+		t.Fatalf("GetAllObservableAddresses() got = %v, want = %v",
+			addrs[0].Address, want)
+	}
+
+	path, err := keystore.GetDerivationPath(context.Background(), info.ID, addrs[0].Address)
+	if err != nil || path != (DerivationPath{0, 0}) {
+		t.Fatalf("GetDerivationPath() got = %v (%v), want = %v",
+			path, err, DerivationPath{0, 0})
+	}
+
+	publicKeys, err := keystore.GetAddressesPublicKeys(
+		context.Background(), info.ID, []DerivationPath{{0, 0}, {1, 3}})
+	if err != nil {
+		t.Fatalf("GetAddressesPublicKeys() unexpected error: %v", err)
+	}
+
+	for _, keys := range publicKeys {
+		if len(keys) != len(cosigners) {
+			t.Fatalf("GetAddressesPublicKeys() got %d keys, want %d",
+				len(keys), len(cosigners))
+		}
+	}
+}
@@ -1,16 +1,46 @@
 package keystore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/ledgerhq/bitcoin-keychain/log"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
 )
 
+// mainKeyPattern matches the keys of the KeychainInfo of keychains.
+const mainKeyPattern = "keychain:{*}"
+
 // RedisKeystore implements the Keystore interface where the storage
-// is an in-memory map. Useful for unit-tests.
+// is a redis database.
+//
+// Each keychain is stored under three keys, so that the payload of every
+// operation does not grow with the number of derived addresses:
+//
+//   keychain:{<id>}              KeychainInfo, serialized to JSON
+//   keychain:{<id>}:addresses    hash of address -> derivation path
+//   keychain:{<id>}:derivations  hash of derivation path -> public keys
+//
+// Derived addresses are also added to the address index, shared by all
+// keychains.
+//
+// Redis cluster is not supported: although the braces keep the keys of a
+// keychain in the same hash slot, the transactions updating them also write
+// the address index and the legacy key of the keychain, which are in other
+// slots.
+//
+// Keychains stored in the legacy format, where the whole Meta is a single
+// JSON value under the keychain UUID, can still be read. They are converted
+// on their first update, or by MigrateLegacyKeychains.
 //
 // It also includes a client to communicate with a bitcoin-lib-grpc server
 // for protocol-level operations.
@@ -20,8 +50,9 @@ type RedisKeystore struct {
 
 // RedisKeystore returns an instance of RedisKeystore which implements
 // the Keystore interface.
-func NewRedisKeystore(redisOpts *redis.Options) (*RedisKeystore, error) {
+func NewRedisKeystore(redisOpts *redis.Options, client bitcoin.CoinServiceClient) (*RedisKeystore, error) {
 	rdb := redis.NewClient(redisOpts)
+	rdb.AddHook(metrics.RedisHook{})
 
 	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
 		return nil, fmt.Errorf("Pinging redis failed: %w", err)
@@ -29,40 +60,461 @@ func NewRedisKeystore(redisOpts *redis.Options) (*RedisKeystore, error) {
 
 	baseKeystore := baseRedisKeystore{
 		db:     rdb,
-		client: bitcoin.NewBitcoinClient(),
+		client: client,
 	}
 
 	return &RedisKeystore{baseKeystore}, nil
 }
 
-func (s *RedisKeystore) Delete(id uuid.UUID) error {
+func mainKey(id uuid.UUID) string {
+	return fmt.Sprintf("keychain:{%s}", id)
+}
+
+func addressesKey(id uuid.UUID) string {
+	return mainKey(id) + ":addresses"
+}
+
+func derivationsKey(id uuid.UUID) string {
+	return mainKey(id) + ":derivations"
+}
+
+func legacyKey(id uuid.UUID) string {
+	return id.String()
+}
+
+// keychainKeys returns all the keys in which a keychain may be stored.
+func keychainKeys(id uuid.UUID) []string {
+	return []string{
+		mainKey(id), addressesKey(id), derivationsKey(id), legacyKey(id),
+	}
+}
+
+// loadMeta reads the KeychainInfo of a keychain. The Addresses and
+// Derivations mappings are left empty, so that they only collect the entries
+// derived by the operation, except for keychains still stored in the legacy
+// format, which are read in full.
+//
+// The second return value is true for legacy keychains.
+func loadMeta(ctx context.Context, c redis.Cmdable, id uuid.UUID) (*Meta, bool, error) {
+	var info KeychainInfo
+
+	err := get(ctx, c, mainKey(id), &info)
+	if err == nil {
+		return &Meta{
+			Main:        info,
+			Derivations: map[DerivationPath][]string{},
+			Addresses:   map[string]DerivationPath{},
+		}, false, nil
+	}
+
+	if err != redis.Nil {
+		return nil, false, err
+	}
+
 	var meta Meta
 
-	err := get(s.db, id.String(), &meta)
+	err = get(ctx, c, legacyKey(id), &meta)
+	if err == redis.Nil {
+		return nil, false, ErrKeychainNotFound
+	}
+
 	if err != nil {
-		return ErrKeychainNotFound
+		return nil, false, err
 	}
 
-	s.db.Del(context.Background(), id.String())
+	return &meta, true, nil
+}
+
+// derivationPaths returns the derivation paths of the given addresses which
+// were derived by a keychain.
+func derivationPaths(
+	ctx context.Context, c redis.Cmdable, meta *Meta, legacy bool, addresses []string,
+) (map[string]DerivationPath, error) {
+	if legacy || len(addresses) == 0 {
+		return meta.Addresses, nil
+	}
+
+	values, err := c.HMGet(ctx, addressesKey(meta.Main.ID), addresses...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]DerivationPath, len(addresses))
+
+	for i, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var path DerivationPath
+		if err := path.UnmarshalText([]byte(text)); err != nil {
+			return nil, err
+		}
+
+		paths[addresses[i]] = path
+	}
+
+	return paths, nil
+}
+
+// derivedAddresses returns all addresses derived by a keychain.
+func derivedAddresses(ctx context.Context, c redis.Cmdable, meta *Meta, legacy bool) ([]string, error) {
+	if !legacy {
+		return c.HKeys(ctx, addressesKey(meta.Main.ID)).Result()
+	}
+
+	addrs := make([]string, 0, len(meta.Addresses))
+	for addr := range meta.Addresses {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// clearMeta queues the deletion of a keychain in a transaction, if the
+// keychain exists, including its addresses in the address index.
+func clearMeta(tx *redis.Tx, redistx *redisTransaction, id uuid.UUID) error {
+	meta, legacy, err := loadMeta(redistx.context, tx, id)
+	if err == ErrKeychainNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	addrs, err := derivedAddresses(redistx.context, tx, meta, legacy)
+	if err != nil {
+		return err
+	}
+
+	if err := redistx.unindexAddresses(addrs); err != nil {
+		return err
+	}
+
+	for _, key := range keychainKeys(id) {
+		if err := redistx.del(key); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (s *RedisKeystore) Reset(id uuid.UUID) error {
-	var meta Meta
+// saveMeta queues the writes of a keychain in a transaction. The entries of
+// the Addresses and Derivations mappings are added to the existing ones, and
+// to the address index. The KeychainInfo is only written if saveMain is true.
+//
+// Legacy keychains are converted to the normalized format.
+func saveMeta(redistx *redisTransaction, meta *Meta, legacy bool, saveMain bool) error {
+	id := meta.Main.ID
+
+	if legacy {
+		if err := redistx.del(legacyKey(id)); err != nil {
+			return err
+		}
+	}
+
+	if legacy || saveMain {
+		if err := redistx.set(mainKey(id), meta.Main); err != nil {
+			return err
+		}
+	}
+
+	addresses := make(map[string]interface{}, len(meta.Addresses))
+	for addr, path := range meta.Addresses {
+		text, _ := path.MarshalText()
+		addresses[addr] = string(text)
+	}
+
+	if err := redistx.hset(addressesKey(id), addresses); err != nil {
+		return err
+	}
+
+	if err := redistx.indexAddresses(id, meta.Main.PathTemplate, meta.Addresses); err != nil {
+		return err
+	}
+
+	derivations := make(map[string]interface{}, len(meta.Derivations))
+	for path, publicKeys := range meta.Derivations {
+		text, _ := path.MarshalText()
+		derivations[string(text)] = publicKeys
+	}
+
+	return redistx.hset(derivationsKey(id), derivations)
+}
+
+// put stores a keychain, replacing any keychain with the same ID.
+func (s *RedisKeystore) put(ctx context.Context, meta *Meta) error {
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		redistx := newRedisTransaction(redisContext, tx)
+
+		if err := clearMeta(tx, redistx, meta.Main.ID); err != nil {
+			return err
+		}
+
+		if err := saveMeta(redistx, meta, false, true); err != nil {
+			return err
+		}
+
+		return redistx.exec()
+	}
+
+	return redisContext.watch(redisUpdate, keychainKeys(meta.Main.ID)...)
+}
+
+// update runs fn on a keychain in an optimistic transaction, and stores the
+// changes made by fn.
+func (s *RedisKeystore) update(ctx context.Context, id uuid.UUID, fn func(meta *Meta) error) error {
+	return s.updateTx(ctx, id, func(tx *redis.Tx, meta *Meta, legacy bool) error {
+		return fn(meta)
+	})
+}
+
+// updateTx is like update, for functions which also read other keys of the
+// keychain in the transaction.
+func (s *RedisKeystore) updateTx(
+	ctx context.Context, id uuid.UUID, fn func(tx *redis.Tx, meta *Meta, legacy bool) error,
+) error {
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		meta, legacy, err := loadMeta(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		before, err := json.Marshal(meta.Main)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(tx, meta, legacy); err != nil {
+			return err
+		}
+
+		after, err := json.Marshal(meta.Main)
+		if err != nil {
+			return err
+		}
+
+		redistx := newRedisTransaction(redisContext, tx)
+
+		// The KeychainInfo, which is watched by the transactions on the
+		// keychain, is only written if it changed, so that transactions only
+		// storing derivations do not abort each other.
+		if err := saveMeta(redistx, meta, legacy, !bytes.Equal(before, after)); err != nil {
+			return err
+		}
+
+		return redistx.exec()
+	}
+
+	return redisContext.watch(redisUpdate, mainKey(id), legacyKey(id))
+}
+
+// derive runs fn, which derives addresses without changing the state of the
+// keychain, outside of a transaction. Polling addresses which are already
+// stored is thus read-only, and concurrent pollers do not abort each other.
+// If fn derived addresses which are not stored yet, it is run again with
+// update, to store them.
+func (s *RedisKeystore) derive(ctx context.Context, id uuid.UUID, fn func(meta *Meta) error) error {
+	meta, legacy, err := loadMeta(ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	known := len(meta.Derivations)
+
+	if err := fn(meta); err != nil {
+		return err
+	}
+
+	stored, err := derivationsStored(ctx, s.db, meta, legacy, known)
+	if err != nil {
+		return err
+	}
+
+	if stored {
+		return nil
+	}
+
+	return s.update(ctx, id, fn)
+}
+
+// derivationsStored reports whether the addresses recorded in a keychain read
+// by loadMeta are all stored, known being the number of derivations read with
+// the keychain.
+func derivationsStored(
+	ctx context.Context, c redis.Cmdable, meta *Meta, legacy bool, known int,
+) (bool, error) {
+	if legacy {
+		return len(meta.Derivations) == known, nil
+	}
+
+	if len(meta.Addresses) == 0 {
+		return true, nil
+	}
+
+	addrs := make([]string, 0, len(meta.Addresses))
+	for addr := range meta.Addresses {
+		addrs = append(addrs, addr)
+	}
+
+	values, err := c.HMGet(ctx, addressesKey(meta.Main.ID), addrs...).Result()
+	if err != nil {
+		return false, err
+	}
+
+	for _, value := range values {
+		if value == nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *RedisKeystore) Get(ctx context.Context, id uuid.UUID) (KeychainInfo, error) {
+	meta, _, err := loadMeta(ctx, s.db, id)
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	return meta.Main, nil
+}
+
+func (s *RedisKeystore) Create(
+	ctx context.Context, extendedPublicKey string, fromChainCode *FromChainCode,
+	origin *KeyOrigin, template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreate(
+		ctx,
+		extendedPublicKey,
+		fromChainCode,
+		origin,
+		template,
+		format,
+		scheme,
+		net,
+		lookaheadSize,
+		index,
+		metadata,
+		s.client,
+	)
 
-	err := get(s.db, id.String(), &meta)
 	if err != nil {
-		return ErrKeychainNotFound
+		return KeychainInfo{}, err
 	}
 
-	meta.ResetKeychainMeta()
+	if err := s.put(ctx, &meta); err != nil {
+		return KeychainInfo{}, err
+	}
 
-	return set(s.db, id.String(), meta)
+	return meta.Main, nil
 }
 
-func (s *RedisKeystore) GetFreshAddress(id uuid.UUID, change Change) (*AddressInfo, error) {
-	addrs, err := s.GetFreshAddresses(id, change, 1)
+func (s *RedisKeystore) CreateMultisig(
+	ctx context.Context, extendedPublicKeys []string, threshold uint32,
+	template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateMultisig(
+		ctx,
+		extendedPublicKeys,
+		nil,
+		template,
+		format,
+		threshold,
+		scheme,
+		net,
+		lookaheadSize,
+		index,
+		metadata,
+		s.client,
+	)
+
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if err := s.put(ctx, &meta); err != nil {
+		return KeychainInfo{}, err
+	}
+
+	return meta.Main, nil
+}
+
+func (s *RedisKeystore) CreateFromDescriptor(
+	ctx context.Context, descriptor string, net chaincfg.Network, lookaheadSize uint32,
+	index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateFromDescriptor(
+		ctx, descriptor, net, lookaheadSize, index, metadata, s.client)
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if err := s.put(ctx, &meta); err != nil {
+		return KeychainInfo{}, err
+	}
+
+	return meta.Main, nil
+}
+
+func (s *RedisKeystore) Delete(ctx context.Context, id uuid.UUID) error {
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		if _, _, err := loadMeta(ctx, tx, id); err != nil {
+			return err
+		}
+
+		redistx := newRedisTransaction(redisContext, tx)
+
+		if err := clearMeta(tx, redistx, id); err != nil {
+			return err
+		}
+
+		return redistx.exec()
+	}
+
+	return redisContext.watch(redisUpdate, mainKey(id), legacyKey(id))
+}
+
+func (s *RedisKeystore) Reset(ctx context.Context, id uuid.UUID) error {
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		meta, _, err := loadMeta(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		redistx := newRedisTransaction(redisContext, tx)
+
+		if err := clearMeta(tx, redistx, id); err != nil {
+			return err
+		}
+
+		meta.ResetKeychainMeta()
+
+		if err := saveMeta(redistx, meta, false, true); err != nil {
+			return err
+		}
+
+		return redistx.exec()
+	}
+
+	return redisContext.watch(redisUpdate, mainKey(id), legacyKey(id))
+}
+
+func (s *RedisKeystore) GetFreshAddress(ctx context.Context, id uuid.UUID, change Change) (*AddressInfo, error) {
+	addrs, err := s.GetFreshAddresses(ctx, id, change, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -70,69 +522,290 @@ func (s *RedisKeystore) GetFreshAddress(id uuid.UUID, change Change) (*AddressIn
 }
 
 func (s *RedisKeystore) GetFreshAddresses(
-	id uuid.UUID, change Change, size uint32,
+	ctx context.Context, id uuid.UUID, change Change, size uint32,
 ) ([]AddressInfo, error) {
-	var meta Meta
+	var res []AddressInfo
 
-	err := get(s.db, id.String(), &meta)
+	err := s.derive(ctx, id, func(meta *Meta) error {
+		addrs, err := meta.keystoreGetFreshAddresses(ctx, s.client, change, size)
+		res = addrs
+		return err
+	})
 	if err != nil {
-		return []AddressInfo{}, ErrKeychainNotFound
+		return nil, err
 	}
 
-	addrs, err := meta.keystoreGetFreshAddresses(s.client, change, size)
-	if err != nil {
-		return addrs, err
-	}
+	return res, nil
+}
+
+func (s *RedisKeystore) MarkPathAsUsed(ctx context.Context, id uuid.UUID, path DerivationPath) error {
+	return s.update(ctx, id, func(meta *Meta) error {
+		return meta.keystoreMarkPathAsUsed(path)
+	})
+}
+
+func (s *RedisKeystore) GetAllObservableAddresses(
+	ctx context.Context, id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
+) ([]AddressInfo, error) {
+	var res []AddressInfo
 
-	if err := set(s.db, id.String(), meta); err != nil {
+	err := s.derive(ctx, id, func(meta *Meta) error {
+		addrs, err := meta.keystoreGetAllObservableAddresses(
+			ctx, s.client, change, fromIndex, toIndex,
+		)
+		res = addrs
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return addrs, nil
+	return res, nil
 }
 
-func (s *RedisKeystore) MarkPathAsUsed(id uuid.UUID, path DerivationPath) error {
-	// Get keychain by ID
-	var meta Meta
-
-	err := get(s.db, id.String(), &meta)
+func (s *RedisKeystore) GetDerivationPath(ctx context.Context, id uuid.UUID, address string) (DerivationPath, error) {
+	meta, legacy, err := loadMeta(ctx, s.db, id)
 	if err != nil {
-		return ErrKeychainNotFound
+		return DerivationPath{}, err
+	}
+
+	if legacy {
+		return meta.keystoreGetDerivationPath(address)
+	}
+
+	val, err := s.db.HGet(ctx, addressesKey(id), meta.Main.normalizeAddress(address)).Result()
+	if err == redis.Nil {
+		return DerivationPath{}, ErrAddressNotFound
 	}
 
-	err = meta.keystoreMarkPathAsUsed(path)
 	if err != nil {
-		return err
+		return DerivationPath{}, err
 	}
 
-	return set(s.db, id.String(), meta)
+	var path DerivationPath
+	if err := path.UnmarshalText([]byte(val)); err != nil {
+		return DerivationPath{}, err
+	}
+
+	return path, nil
 }
 
-func (s *RedisKeystore) GetAllObservableAddresses(
-	id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
+func (s *RedisKeystore) MarkAddressAsUsed(ctx context.Context, id uuid.UUID, address string) error {
+	return keystoreMarkAddressAsUsed(ctx, s, id, address)
+}
+
+func (s *RedisKeystore) DiscoverKeychain(
+	ctx context.Context, id uuid.UUID, oracle AddressUsageOracle,
 ) ([]AddressInfo, error) {
-	var meta Meta
+	var res []AddressInfo
 
-	err := get(s.db, id.String(), &meta)
+	// Addresses derived are stored and indexed with the keychain.
+	err := s.update(ctx, id, func(meta *Meta) error {
+		used, _, err := meta.keystoreDiscover(ctx, s.client, oracle)
+		res = used
+		return err
+	})
 	if err != nil {
-		return nil, ErrKeychainNotFound
+		return nil, err
 	}
 
-	addrs, err := meta.keystoreGetAllObservableAddresses(
-		s.client, change, fromIndex, toIndex,
-	)
+	return res, nil
+}
+
+// MarkAddressesAsUsed marks addresses as used in a single transaction,
+// which also reads the derivation paths of the addresses.
+func (s *RedisKeystore) MarkAddressesAsUsed(
+	ctx context.Context, id uuid.UUID, addresses []string,
+) ([]MarkResult, error) {
+	var results []MarkResult
+
+	err := s.updateTx(ctx, id, func(tx *redis.Tx, meta *Meta, legacy bool) error {
+		paths, err := derivationPaths(ctx, tx, meta, legacy, meta.Main.normalizeAddresses(addresses))
+		if err != nil {
+			return err
+		}
+
+		// Addresses discovered are stored and indexed with the keychain.
+		results, _, err = meta.keystoreMarkAddressesAsUsed(
+			ctx, s.client, addresses, paths, s.searchLimit,
+		)
+		return err
+	})
 	if err != nil {
-		return addrs, err
+		return nil, err
 	}
 
-	err = set(s.db, id.String(), meta)
+	return results, nil
+}
+
+// GetAddressesPublicKeys reads the derivation-to-publicKey mapping in the keystore,
+// and returns the public keys corresponding to given derivations.
+func (s *RedisKeystore) GetAddressesPublicKeys(ctx context.Context, id uuid.UUID, derivations []DerivationPath) ([][]string, error) {
+	meta, legacy, err := loadMeta(ctx, s.db, id)
 	if err != nil {
-		return addrs, err
+		return nil, err
 	}
 
-	return addrs, nil
+	if legacy {
+		return meta.keystoreGetAddressesPublicKeys(derivations)
+	}
+
+	if len(derivations) == 0 {
+		return [][]string{}, nil
+	}
+
+	fields := make([]string, len(derivations))
+	for i, derivation := range derivations {
+		text, _ := derivation.MarshalText()
+		fields[i] = string(text)
+	}
+
+	vals, err := s.db.HMGet(ctx, derivationsKey(id), fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys := make([][]string, len(derivations))
+
+	for i, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			return nil, ErrDerivationNotFound
+		}
+
+		if err := json.Unmarshal([]byte(str), &publicKeys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return publicKeys, nil
+}
+
+// MigrateLegacyKeychains converts all keychains stored in the legacy format
+// to the normalized format, and returns the number of converted keychains.
+//
+// Legacy keychains are otherwise converted on their first update, so this is
+// only needed to complete a migration. It must not be used on a database
+// shared with a WDKeystore, which still uses the legacy format.
+func (s *RedisKeystore) MigrateLegacyKeychains(ctx context.Context) (int, error) {
+	count := 0
+
+	iter := s.db.Scan(ctx, 0, legacyKeyPattern, 0).Iterator()
+	for iter.Next(ctx) {
+		id, err := uuid.Parse(iter.Val())
+		if err != nil {
+			continue
+		}
+
+		// A no-op update stores the keychain in the normalized format.
+		err = s.update(ctx, id, func(meta *Meta) error { return nil })
+		if errors.Cause(err) == ErrKeychainNotFound {
+			// Deleted since the key was scanned.
+			continue
+		}
+
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to migrate keychain %s", id)
+		}
+
+		log.WithFields(log.Fields{
+			"id": id.String(),
+		}).Info("[keystore] migrated legacy keychain")
+
+		count++
+	}
+
+	return count, iter.Err()
 }
 
-func (s *RedisKeystore) MarkAddressAsUsed(id uuid.UUID, address string) error {
-	return keystoreMarkAddressAsUsed(s, id, address)
+// AddressCounts returns the number of addresses of every keychain, see
+// metrics.Store. It scans the whole keyspace, so it should only be called by
+// infrequent jobs, like the periodic collection of the metrics.
+func (s *RedisKeystore) AddressCounts(ctx context.Context) ([]int, error) {
+	var keys []string
+
+	iter := s.db.Scan(ctx, 0, mainKeyPattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, 0, len(keys))
+
+	if len(keys) > 0 {
+		pipe := s.db.Pipeline()
+
+		cmds := make([]*redis.IntCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.HLen(ctx, key+":addresses")
+		}
+
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+
+		for _, cmd := range cmds {
+			counts = append(counts, int(cmd.Val()))
+		}
+	}
+
+	legacy, err := legacyAddressCounts(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(counts, legacy...), nil
+}
+
+// ListKeychains lists keychains with SCAN, first the keychains in the
+// normalized format, then the legacy ones.
+//
+// The cursor is the SCAN cursor, prefixed by the format of the keychains
+// being scanned.
+func (s *RedisKeystore) ListKeychains(
+	ctx context.Context, filter KeychainFilter, cursor string, limit uint32,
+) ([]KeychainInfo, string, error) {
+	legacy := false
+
+	var scanCursor uint64
+
+	if cursor != "" {
+		parts := strings.SplitN(cursor, ":", 2)
+		if len(parts) != 2 || (parts[0] != "main" && parts[0] != "legacy") {
+			return nil, "", errors.Wrap(ErrInvalidCursor, cursor)
+		}
+
+		var err error
+		if scanCursor, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+			return nil, "", errors.Wrap(ErrInvalidCursor, cursor)
+		}
+
+		legacy = parts[0] == "legacy"
+	}
+
+	pattern := mainKeyPattern
+	if legacy {
+		pattern = legacyKeyPattern
+	}
+
+	infos, scanCursor, err := scanKeychains(
+		ctx, s.db, pattern, filter, scanCursor, pageSize(limit), legacy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case scanCursor != 0 && legacy:
+		return infos, fmt.Sprintf("legacy:%d", scanCursor), nil
+	case scanCursor != 0:
+		return infos, fmt.Sprintf("main:%d", scanCursor), nil
+	case !legacy:
+		// Legacy keychains are listed from the next page.
+		return infos, "legacy:0", nil
+	default:
+		return infos, "", nil
+	}
 }
@@ -4,25 +4,62 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-
+	"math/rand"
 	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/ledgerhq/bitcoin-keychain/log"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// redisTxMaxAttempts bounds the number of attempts of an optimistic
+	// transaction, when the watched keys are modified concurrently.
+	redisTxMaxAttempts = 20
+
+	// redisTxBackoff is the base delay between two attempts of an optimistic
+	// transaction. The actual delay is randomized, and grows linearly with
+	// the number of attempts, to spread out concurrent writers.
+	redisTxBackoff = time.Millisecond
+
+	// ownersKey is the key of the address index shared by all keychains of
+	// redis keystores, a hash of address -> AddressOwner.
+	ownersKey = "keychain:owners"
+
+	// legacyKeyPattern matches the keys of keychains stored as a single JSON
+	// value, which are UUIDs.
+	legacyKeyPattern = "????????-????-????-????-????????????"
 )
 
 type baseRedisKeystore struct {
 	db     *redis.Client
 	client bitcoin.CoinServiceClient
+
+	// searchLimit is the number of addresses derived to discover unknown
+	// addresses, see AddressSearcher.
+	searchLimit uint32
 }
 
-func (s *baseRedisKeystore) Get(id uuid.UUID) (KeychainInfo, error) {
+// SetAddressSearchLimit enables the discovery of unknown addresses marked as
+// used, see AddressSearcher.
+func (s *baseRedisKeystore) SetAddressSearchLimit(limit uint32) {
+	s.searchLimit = limit
+}
+
+// Ping checks that redis is reachable.
+func (s *baseRedisKeystore) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx).Err()
+}
+
+func (s *baseRedisKeystore) Get(ctx context.Context, id uuid.UUID) (KeychainInfo, error) {
 	var meta Meta
 
-	err := get(s.db, id.String(), &meta)
+	err := get(ctx, s.db, id.String(), &meta)
 	if err != nil {
 		return KeychainInfo{}, ErrKeychainNotFound
 	}
@@ -31,12 +68,48 @@ func (s *baseRedisKeystore) Get(id uuid.UUID) (KeychainInfo, error) {
 }
 
 func (s *baseRedisKeystore) Create(
-	extendedPublicKey string, fromChainCode *FromChainCode, scheme Scheme,
+	ctx context.Context, extendedPublicKey string, fromChainCode *FromChainCode,
+	origin *KeyOrigin, template PathTemplate, format AddressFormat, scheme Scheme,
 	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
 ) (KeychainInfo, error) {
 	meta, err := keystoreCreate(
+		ctx,
 		extendedPublicKey,
 		fromChainCode,
+		origin,
+		template,
+		format,
+		scheme,
+		net,
+		lookaheadSize,
+		index,
+		metadata,
+		s.client,
+	)
+
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if err := set(ctx, s.db, meta.Main.ID.String(), meta); err != nil {
+		return KeychainInfo{}, err
+	}
+
+	return meta.Main, nil
+}
+
+func (s *baseRedisKeystore) CreateMultisig(
+	ctx context.Context, extendedPublicKeys []string, threshold uint32,
+	template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateMultisig(
+		ctx,
+		extendedPublicKeys,
+		nil,
+		template,
+		format,
+		threshold,
 		scheme,
 		net,
 		lookaheadSize,
@@ -49,16 +122,33 @@ func (s *baseRedisKeystore) Create(
 		return KeychainInfo{}, err
 	}
 
-	if err := set(s.db, meta.Main.ID.String(), meta); err != nil {
+	if err := set(ctx, s.db, meta.Main.ID.String(), meta); err != nil {
 		return KeychainInfo{}, err
 	}
 
 	return meta.Main, nil
 }
 
-func (s *baseRedisKeystore) GetDerivationPath(id uuid.UUID, address string) (DerivationPath, error) {
+func (s *baseRedisKeystore) CreateFromDescriptor(
+	ctx context.Context, descriptor string, net chaincfg.Network, lookaheadSize uint32,
+	index uint32, metadata string,
+) (KeychainInfo, error) {
+	meta, err := keystoreCreateFromDescriptor(
+		ctx, descriptor, net, lookaheadSize, index, metadata, s.client)
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if err := set(ctx, s.db, meta.Main.ID.String(), meta); err != nil {
+		return KeychainInfo{}, err
+	}
+
+	return meta.Main, nil
+}
+
+func (s *baseRedisKeystore) GetDerivationPath(ctx context.Context, id uuid.UUID, address string) (DerivationPath, error) {
 	var meta Meta
-	err := get(s.db, id.String(), &meta)
+	err := get(ctx, s.db, id.String(), &meta)
 	if err != nil {
 		return DerivationPath{}, ErrKeychainNotFound
 	}
@@ -66,10 +156,10 @@ func (s *baseRedisKeystore) GetDerivationPath(id uuid.UUID, address string) (Der
 	return meta.keystoreGetDerivationPath(address)
 }
 
-func (s *baseRedisKeystore) GetAddressesPublicKeys(id uuid.UUID, derivations []DerivationPath) ([]string, error) {
+func (s *baseRedisKeystore) GetAddressesPublicKeys(ctx context.Context, id uuid.UUID, derivations []DerivationPath) ([][]string, error) {
 	var meta Meta
 
-	err := get(s.db, id.String(), &meta)
+	err := get(ctx, s.db, id.String(), &meta)
 	if err != nil {
 		return nil, ErrKeychainNotFound
 	}
@@ -77,6 +167,159 @@ func (s *baseRedisKeystore) GetAddressesPublicKeys(id uuid.UUID, derivations []D
 	return meta.keystoreGetAddressesPublicKeys(derivations)
 }
 
+func (s *baseRedisKeystore) FindAddressOwner(ctx context.Context, addresses []string) ([]*AddressOwner, error) {
+	owners := make([]*AddressOwner, len(addresses))
+
+	if len(addresses) == 0 {
+		return owners, nil
+	}
+
+	vals, err := s.db.HMGet(ctx, ownersKey, addresses...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		var owner AddressOwner
+		if err := json.Unmarshal([]byte(str), &owner); err != nil {
+			return nil, errors.Wrapf(err, "invalid owner of address %s", addresses[i])
+		}
+
+		// Addresses indexed before path templates were derived with the
+		// default one.
+		if owner.Path == nil {
+			owner.Path = DefaultPathTemplate.Path(owner.Derivation)
+		}
+
+		owners[i] = &owner
+	}
+
+	return owners, nil
+}
+
+// ListKeychains lists the keychains stored as a single JSON value under their
+// UUID. The cursor is the redis SCAN cursor.
+func (s *baseRedisKeystore) ListKeychains(
+	ctx context.Context, filter KeychainFilter, cursor string, limit uint32,
+) ([]KeychainInfo, string, error) {
+	var scanCursor uint64
+
+	if cursor != "" {
+		var err error
+		if scanCursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", errors.Wrap(ErrInvalidCursor, cursor)
+		}
+	}
+
+	infos, scanCursor, err := scanKeychains(
+		ctx, s.db, legacyKeyPattern, filter, scanCursor, pageSize(limit), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if scanCursor == 0 {
+		return infos, "", nil
+	}
+
+	return infos, strconv.FormatUint(scanCursor, 10), nil
+}
+
+// AddressCounts returns the number of addresses of every keychain, see
+// metrics.Store. It scans the whole keyspace, so it should only be called by
+// infrequent jobs, like the periodic collection of the metrics.
+func (s *baseRedisKeystore) AddressCounts(ctx context.Context) ([]int, error) {
+	return legacyAddressCounts(ctx, s.db)
+}
+
+// legacyAddressCounts returns the number of addresses of every keychain
+// stored as a single JSON value under its UUID.
+func legacyAddressCounts(ctx context.Context, c *redis.Client) ([]int, error) {
+	counts := []int{}
+
+	iter := c.Scan(ctx, 0, legacyKeyPattern, 0).Iterator()
+	for iter.Next(ctx) {
+		var meta Meta
+
+		err := get(ctx, c, iter.Val(), &meta)
+		if err == redis.Nil {
+			// Deleted since the key was scanned.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, len(meta.Addresses))
+	}
+
+	return counts, iter.Err()
+}
+
+// scanKeychains lists the keychains stored under keys matching a pattern
+// with SCAN, starting at the given cursor, until at least limit keychains
+// are selected by the filter, or until the end of the iteration. It returns
+// the SCAN cursor to resume from, which is 0 at the end of the iteration.
+//
+// Keys store a KeychainInfo, or a whole Meta for the legacy format.
+func scanKeychains(
+	ctx context.Context, c *redis.Client, pattern string, filter KeychainFilter,
+	cursor uint64, limit uint32, legacy bool,
+) ([]KeychainInfo, uint64, error) {
+	infos := []KeychainInfo{}
+
+	for {
+		keys, next, err := c.Scan(ctx, cursor, pattern, int64(limit)).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(keys) > 0 {
+			vals, err := c.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, 0, err
+			}
+
+			for i, val := range vals {
+				str, ok := val.(string)
+				if !ok {
+					// Deleted since the key was scanned.
+					continue
+				}
+
+				var info KeychainInfo
+
+				if legacy {
+					var meta Meta
+					err = json.Unmarshal([]byte(str), &meta)
+					info = meta.Main
+				} else {
+					err = json.Unmarshal([]byte(str), &info)
+				}
+
+				if err != nil {
+					return nil, 0, errors.Wrapf(err, "invalid keychain at key %s", keys[i])
+				}
+
+				if filter.Match(info) {
+					infos = append(infos, info)
+				}
+			}
+		}
+
+		cursor = next
+
+		if cursor == 0 || uint32(len(infos)) >= limit {
+			return infos, cursor, nil
+		}
+	}
+}
+
 func unmarshall(val string, dest interface{}) error {
 	err := json.Unmarshal([]byte(val), dest)
 	if err != nil {
@@ -102,7 +345,7 @@ func marshall(value interface{}) (string, error) {
 	return redisValue, nil
 }
 
-func set(c *redis.Client, key string, value interface{}) error {
+func set(ctx context.Context, c *redis.Client, key string, value interface{}) error {
 	redisValue, err := marshall(value)
 
 	if err != nil {
@@ -110,11 +353,13 @@ func set(c *redis.Client, key string, value interface{}) error {
 	}
 
 	log.Debug(fmt.Sprintf("Setting redis key[%s]:[%s]", key, redisValue))
-	return c.Set(context.Background(), key, redisValue, 0).Err()
+	return c.Set(ctx, key, redisValue, 0).Err()
 }
 
-func get(c *redis.Client, key string, dest interface{}) error {
-	p, err := c.Get(context.Background(), key).Result()
+// get reads and unmarshalls the value of a key. Reads of an optimistic
+// transaction should use the *redis.Tx of the transaction.
+func get(ctx context.Context, c redis.Cmdable, key string, dest interface{}) error {
+	p, err := c.Get(ctx, key).Result()
 	if err != nil {
 		return err
 	}
@@ -134,9 +379,9 @@ type redisTransaction struct {
 	pipe    redis.Pipeliner
 }
 
-func newRedisContext(db *redis.Client) *redisContext {
+func newRedisContext(ctx context.Context, db *redis.Client) *redisContext {
 	return &redisContext{
-		context: context.Background(),
+		context: ctx,
 		db:      db,
 	}
 }
@@ -157,6 +402,48 @@ func (r *redisTransaction) set(key string, value interface{}) error {
 	return r.pipe.Set(r.context, key, redisValue, 0).Err()
 }
 
+// hset sets fields of a hash. Values which are not strings are marshalled.
+func (r *redisTransaction) hset(key string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]interface{}, len(values))
+
+	for field, value := range values {
+		redisValue, err := marshall(value)
+		if err != nil {
+			return err
+		}
+
+		fields[field] = redisValue
+	}
+
+	return r.pipe.HSet(r.context, key, fields).Err()
+}
+
+// indexAddresses adds derived addresses of a keychain to the address index.
+func (r *redisTransaction) indexAddresses(
+	id uuid.UUID, template PathTemplate, addrs map[string]DerivationPath,
+) error {
+	owners := make(map[string]interface{}, len(addrs))
+
+	for addr, path := range addrs {
+		owners[addr] = AddressOwner{KeychainID: id, Derivation: path, Path: template.Path(path)}
+	}
+
+	return r.hset(ownersKey, owners)
+}
+
+// unindexAddresses removes addresses from the address index.
+func (r *redisTransaction) unindexAddresses(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	return r.pipe.HDel(r.context, ownersKey, addrs...).Err()
+}
+
 func (r *redisTransaction) del(key string) error {
 	return r.pipe.Del(r.context, key, key).Err()
 }
@@ -166,6 +453,29 @@ func (r *redisTransaction) exec() error {
 	return err
 }
 
-func (r *redisContext) watch(fn func(*redis.Tx) error, key string) error {
-	return r.db.Watch(r.context, fn, key)
+// watch runs fn in an optimistic transaction, watching the given keys.
+//
+// If any key is modified by another client before the transaction is
+// executed, fn is run again from scratch, up to redisTxMaxAttempts times.
+// ErrConcurrentModification is returned when all attempts failed, and the
+// error of the context if it is done while waiting for the next attempt.
+func (r *redisContext) watch(fn func(*redis.Tx) error, keys ...string) error {
+	for attempt := 1; attempt <= redisTxMaxAttempts; attempt++ {
+		err := r.db.Watch(r.context, fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+
+		log.Debug(fmt.Sprintf("Transaction on redis keys%v failed, attempt %d/%d",
+			keys, attempt, redisTxMaxAttempts))
+
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(attempt) * int64(redisTxBackoff)))):
+		case <-r.context.Done():
+			return r.context.Err()
+		}
+	}
+
+	return errors.Wrapf(ErrConcurrentModification,
+		"redis keys %v, after %d attempts", keys, redisTxMaxAttempts)
 }
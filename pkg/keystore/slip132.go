@@ -0,0 +1,116 @@
+package keystore
+
+import (
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+// SLIP132Version returns the SLIP-0132 version bytes of the extended public
+// keys of a Scheme on a given Network, such as zpub for BIP84 on Bitcoin
+// mainnet.
+//
+// The standard version bytes are returned if SLIP-0132 does not define any,
+// for example for BIP44 and BIP86.
+//
+// Reference:
+//   https://github.com/satoshilabs/slips/blob/master/slip-0132.md
+func SLIP132Version(scheme Scheme, net chaincfg.Network) ([4]byte, error) {
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return [4]byte{}, err
+	}
+
+	var version [4]byte
+
+	switch scheme {
+	case BIP44, BIP86, MultisigP2SH:
+		version = params.HDPublicKeyID
+	case BIP49:
+		version = params.SLIP132HDPublicKeyIDs.P2SHP2WPKH
+	case BIP84:
+		version = params.SLIP132HDPublicKeyIDs.P2WPKH
+	case MultisigP2SHP2WSH:
+		version = params.SLIP132HDPublicKeyIDs.P2SHP2WSH
+	case MultisigP2WSH:
+		version = params.SLIP132HDPublicKeyIDs.P2WSH
+	default:
+		return [4]byte{}, errors.Wrapf(ErrUnrecognizedScheme, "%v", scheme)
+	}
+
+	if version == [4]byte{} {
+		version = params.HDPublicKeyID
+	}
+
+	return version, nil
+}
+
+// ToSLIP132 serializes an extended public key with the SLIP-0132 version
+// bytes of a Scheme on a given Network. See SLIP132Version.
+func ToSLIP132(extendedPublicKey string, scheme Scheme, net chaincfg.Network) (string, error) {
+	key, err := parseExtendedPublicKey(extendedPublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	if key.Version, err = SLIP132Version(scheme, net); err != nil {
+		return "", err
+	}
+
+	return key.String(), nil
+}
+
+// FromSLIP132 serializes an extended public key with the standard version
+// bytes of a given Network, such as xpub for Bitcoin mainnet.
+//
+// Both standard and SLIP-0132 serializations are accepted, as long as they
+// match the Network, and the Scheme in the case of SLIP-0132. For example, a
+// zpub is accepted for a BIP84 keychain, but not for a BIP49 one. The aliases
+// of the standard version are also accepted, such as xpub for Litecoin
+// mainnet, see chaincfg.Params.HDPublicKeyIDAliases.
+func FromSLIP132(extendedPublicKey string, scheme Scheme, net chaincfg.Network) (string, error) {
+	key, err := parseExtendedPublicKey(extendedPublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	params, err := chaincfg.GetParams(net)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := SLIP132Version(scheme, net)
+	if err != nil {
+		return "", err
+	}
+
+	if key.Version != params.HDPublicKeyID && key.Version != version &&
+		!containsVersion(params.HDPublicKeyIDAliases, key.Version) {
+		return "", errors.Wrapf(ErrInvalidExtendedKey,
+			"version %x of %s does not match scheme %s on %s",
+			key.Version, extendedPublicKey, scheme, net)
+	}
+
+	key.Version = params.HDPublicKeyID
+
+	return key.String(), nil
+}
+
+func containsVersion(versions [][4]byte, version [4]byte) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseExtendedPublicKey(extendedPublicKey string) (*bip32.ExtendedKey, error) {
+	key, err := bip32.ParseExtendedKey(extendedPublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidExtendedKey, "%s: %v", extendedPublicKey, err)
+	}
+
+	return key, nil
+}
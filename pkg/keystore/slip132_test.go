@@ -0,0 +1,204 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+)
+
+func TestSLIP132(t *testing.T) {
+	tests := []struct {
+		name     string
+		standard string
+		slip132  string
+		scheme   Scheme
+		network  chaincfg.Network
+	}{
+		{
+			// BIP84 test vector, account 0.
+			name:     "bitcoin zpub",
+			standard: "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V",
+			slip132:  "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs",
+			scheme:   BIP84,
+			network:  chaincfg.BitcoinMainnet,
+		},
+		{
+			// BIP49 test vector, account 0.
+			name:     "bitcoin ypub",
+			standard: "xpub6C6nQwHaWbSrzs5tZ1q7m5R9cPK9eYpNMFesiXsYrgc1P8bvLLAet9JfHjYXKjToD8cBRswJXXbbFpXgwsswVPAZzKMa1jUp2kVkGVUaJa7",
+			slip132:  "ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP",
+			scheme:   BIP49,
+			network:  chaincfg.BitcoinMainnet,
+		},
+		{
+			name:     "bitcoin xpub",
+			standard: "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+			slip132:  "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+			scheme:   BIP44,
+			network:  chaincfg.BitcoinMainnet,
+		},
+		{
+			name:     "bitcoin Zpub",
+			standard: "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+			slip132:  "Zpub72vG5KcRLKBJTi517jZQKb3htapKHBkt5svW1qHzNDwYapfZ9eQvi7LKqSJf7frSXihrRpvonQKz2exZwzT333snwEUbXHscfwSwBKPYd5N",
+			scheme:   MultisigP2WSH,
+			network:  chaincfg.BitcoinMainnet,
+		},
+		{
+			name:     "bitcoin testnet upub",
+			standard: "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			slip132:  "upub5DkWPzTWxWGNox4JWPtxtY34EWoN3brQmq9eBGL6ANg7srbhLM1PrPo8EHayjmqrMj3uLSUFgs4Y6v1uJsaZevBmpGHZAMjSKE4xqqSQJyq",
+			scheme:   BIP49,
+			network:  chaincfg.BitcoinTestnet3,
+		},
+		{
+			name:     "bitcoin signet upub",
+			standard: "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			slip132:  "upub5DkWPzTWxWGNox4JWPtxtY34EWoN3brQmq9eBGL6ANg7srbhLM1PrPo8EHayjmqrMj3uLSUFgs4Y6v1uJsaZevBmpGHZAMjSKE4xqqSQJyq",
+			scheme:   BIP49,
+			network:  chaincfg.BitcoinSignet,
+		},
+		{
+			name:     "litecoin Mtub",
+			standard: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			slip132:  "Mtub2s2PqMHLt2rKmpKTiFyaqSCtVCEMfK5WyDj3Thvk3F1sHNhEnwer87zG7HqT5YttRx88US3vDWdrVH5TZfEj542fpKZP8L4nXgxob6LRJcE",
+			scheme:   BIP49,
+			network:  chaincfg.LitecoinMainnet,
+		},
+		{
+			// SLIP-0132 defines no version for Litecoin native segwit.
+			name:     "litecoin Ltub",
+			standard: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			slip132:  "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			scheme:   BIP84,
+			network:  chaincfg.LitecoinMainnet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToSLIP132(tt.standard, tt.scheme, tt.network)
+			if err != nil {
+				t.Fatalf("ToSLIP132() unexpected error: %v", err)
+			}
+
+			if got != tt.slip132 {
+				t.Fatalf("ToSLIP132() got = %v, want = %v", got, tt.slip132)
+			}
+
+			for _, key := range []string{tt.standard, tt.slip132} {
+				got, err = FromSLIP132(key, tt.scheme, tt.network)
+				if err != nil {
+					t.Fatalf("FromSLIP132() unexpected error: %v", err)
+				}
+
+				if got != tt.standard {
+					t.Fatalf("FromSLIP132() got = %v, want = %v", got, tt.standard)
+				}
+			}
+		})
+	}
+}
+
+// TestFromSLIP132_Aliases checks that Litecoin keys serialized with the
+// Bitcoin versions, as some wallets do, are still accepted.
+func TestFromSLIP132_Aliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		standard string
+		scheme   Scheme
+		network  chaincfg.Network
+	}{
+		{
+			name:     "xpub for a litecoin keychain",
+			key:      "xpub6Bm253QPquzqg9rQHRBxmV1AERmLxuZsvpzqrEHkHjoHVuFCtGzYgQqgjDMqREqiS6bk8q4oupdvVu6mw4jGRtZeeqyVsjVFmU8pHx8Wg34",
+			standard: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			scheme:   BIP84,
+			network:  chaincfg.LitecoinMainnet,
+		},
+		{
+			name:     "xpub for a litecoin wrapped segwit keychain",
+			key:      "xpub6Bm253QPquzqg9rQHRBxmV1AERmLxuZsvpzqrEHkHjoHVuFCtGzYgQqgjDMqREqiS6bk8q4oupdvVu6mw4jGRtZeeqyVsjVFmU8pHx8Wg34",
+			standard: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			scheme:   BIP49,
+			network:  chaincfg.LitecoinMainnet,
+		},
+		{
+			name:     "tpub for a litecoin testnet keychain",
+			key:      "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			standard: "ttub4dcAn4eGmoSp8i63VoJE1CkWKCY91Ebwjxv9v2kWxATDX3oskXmUoZ11zWjPppZQnsZZYjpHFHruvhtu7WfCKigXxL2rGkDMdFBt4BMVLqE",
+			scheme:   BIP84,
+			network:  chaincfg.LitecoinTestnet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromSLIP132(tt.key, tt.scheme, tt.network)
+			if err != nil {
+				t.Fatalf("FromSLIP132() unexpected error: %v", err)
+			}
+
+			if got != tt.standard {
+				t.Fatalf("FromSLIP132() got = %v, want = %v", got, tt.standard)
+			}
+		})
+	}
+}
+
+func TestFromSLIP132_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		scheme  Scheme
+		network chaincfg.Network
+		wantErr error
+	}{
+		{
+			name:    "zpub for a wrapped segwit keychain",
+			key:     "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs",
+			scheme:  BIP49,
+			network: chaincfg.BitcoinMainnet,
+			wantErr: ErrInvalidExtendedKey,
+		},
+		{
+			name:    "tpub for a mainnet keychain",
+			key:     "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			scheme:  BIP49,
+			network: chaincfg.BitcoinMainnet,
+			wantErr: ErrInvalidExtendedKey,
+		},
+		{
+			name:    "ypub for a litecoin keychain",
+			key:     "ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP",
+			scheme:  BIP49,
+			network: chaincfg.LitecoinMainnet,
+			wantErr: ErrInvalidExtendedKey,
+		},
+		{
+			name:    "malformed key",
+			key:     "xpub1111",
+			scheme:  BIP84,
+			network: chaincfg.BitcoinMainnet,
+			wantErr: ErrInvalidExtendedKey,
+		},
+		{
+			name:    "unknown scheme",
+			key:     "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V",
+			scheme:  "BIP0",
+			network: chaincfg.BitcoinMainnet,
+			wantErr: ErrUnrecognizedScheme,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromSLIP132(tt.key, tt.scheme, tt.network)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("FromSLIP132() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package keystore
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/ledgerhq/bitcoin-keychain/log"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
 	"github.com/pkg/errors"
 )
@@ -17,23 +19,56 @@ import (
 // Currently, there are two Keystore implementations available:
 //   InMemoryKeystore: useful for unit-tests
 //   RedisKeystore:    for production
+//
+// Every method takes the context of the request being served, which bounds
+// the storage backend calls and the CoinService calls made for derivations.
 type Keystore interface {
 	// Get returns a previously stored keychain information based on the provided
 	// keychain UUID.
 	//
 	// Returns an error if the keychain UUID is missing in the keystore.
-	Get(id uuid.UUID) (KeychainInfo, error)
+	Get(ctx context.Context, id uuid.UUID) (KeychainInfo, error)
 	// Delete removes a keychain corresponding to a UUID from the keystore.
-	Delete(id uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
 	// Reset removes derivations and addresses of a keychain corresponding to a UUID from the keystore.
-	Reset(id uuid.UUID) error
+	Reset(ctx context.Context, id uuid.UUID) error
 	// Create parses a populates the keystore with the corresponding
 	// keychain information, based on the provided extended public key, Scheme,
 	// and Network information.
 	//
 	// Only initial state is populated, so no addresses will be inserted into the
 	// keystore by this method.
-	Create(extendedPublicKey string, fromChainCode *FromChainCode, scheme Scheme, net chaincfg.Network,
+	//
+	// The optional origin gives the master key fingerprint and the account
+	// path of the extended public key, which are then included in the
+	// descriptors. If its AccountPath is empty, the standard account path of
+	// the Scheme is assumed, see DefaultAccountPath.
+	//
+	// The template defines the chains of the keychain relative to the
+	// extended public key, see PathTemplate. The empty template is the
+	// DefaultPathTemplate, of account extended public keys. The format of
+	// addresses is only chosen on networks with several address formats, see
+	// AddressFormat.
+	Create(ctx context.Context, extendedPublicKey string, fromChainCode *FromChainCode,
+		origin *KeyOrigin, template PathTemplate, format AddressFormat, scheme Scheme,
+		net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string) (KeychainInfo, error)
+	// CreateMultisig populates the keystore with a threshold-of-n multisig
+	// keychain, based on the extended public keys of all cosigners, a
+	// multisig Scheme and Network information.
+	//
+	// Cosigners are sorted in the descriptors (sortedmulti), so the order of
+	// extendedPublicKeys does not change the keychain ID nor the addresses.
+	// The template applies to the extended public keys of all cosigners.
+	CreateMultisig(ctx context.Context, extendedPublicKeys []string, threshold uint32,
+		template PathTemplate, format AddressFormat, scheme Scheme,
+		net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string) (KeychainInfo, error)
+	// CreateFromDescriptor populates the keystore with the keychain described
+	// by an account output descriptor, see ParseDescriptor.
+	//
+	// The Scheme is inferred from the descriptor. The Network is inferred from
+	// the extended public keys, unless net is not empty. The key origin
+	// information of the descriptor, if any, is stored in the KeychainInfo.
+	CreateFromDescriptor(ctx context.Context, descriptor string, net chaincfg.Network,
 		lookaheadSize uint32, index uint32, metadata string) (KeychainInfo, error)
 	// GetFreshAddress retrieves an unused address from the keystore at a
 	// given Change index, for the keychain corresponding to the provided keychain
@@ -41,13 +76,13 @@ type Keystore interface {
 	//
 	// See GetFreshAddresses for getting fresh addresses in bulk, and for further
 	// details.
-	GetFreshAddress(id uuid.UUID, change Change) (*AddressInfo, error)
+	GetFreshAddress(ctx context.Context, id uuid.UUID, change Change) (*AddressInfo, error)
 	// GetFreshAddresses retrieves bulk fresh addresses from the keystore.
 	//
 	// In addition to ensuring that issued addresses are always fresh (unused), the
 	// method also detects gaps in used addresses and includes it in fresh address
 	// list.
-	GetFreshAddresses(id uuid.UUID, change Change, size uint32) ([]AddressInfo, error)
+	GetFreshAddresses(ctx context.Context, id uuid.UUID, change Change, size uint32) ([]AddressInfo, error)
 	// MarkPathAsUsed sets a given derivation path as used. It records bookkeeping
 	// information about gaps in the derivation.
 	//
@@ -57,28 +92,75 @@ type Keystore interface {
 	// detected and saved in the keystore. For this we rely on two main fields:
 	//   MaxConsecutiveIndex   -> the largest consecutive index without any gaps
 	//   NonConsecutiveIndexes -> list of used indexes that introduced gaps
-	MarkPathAsUsed(id uuid.UUID, path DerivationPath) error
+	MarkPathAsUsed(ctx context.Context, id uuid.UUID, path DerivationPath) error
 	// MarkAddressAsUsed is a helper to directly mark an address as used. It
 	// internally fetches the derivation path of the address from the keystore,
 	// and then marks this DerivationPath value as used.
-	MarkAddressAsUsed(id uuid.UUID, address string) error
+	MarkAddressAsUsed(ctx context.Context, id uuid.UUID, address string) error
+	// MarkAddressesAsUsed marks a batch of addresses as used, in a single
+	// update of the keychain: either all marks are saved, or none.
+	//
+	// There is one MarkResult per address, in the same order. Addresses that
+	// were never derived by the keychain are reported as MarkUnknownAddress
+	// instead of failing the whole batch.
+	MarkAddressesAsUsed(ctx context.Context, id uuid.UUID, addresses []string) ([]MarkResult, error)
+	// DiscoverKeychain scans the chains of a keychain from index 0, and marks as used the addresses with transaction history
+	// according to oracle, until LookaheadSize consecutive addresses are
+	// unused on each chain. It returns the used addresses.
+	//
+	// It restores the state of a keychain after ResetKeychain, in a single
+	// update of the keychain.
+	DiscoverKeychain(ctx context.Context, id uuid.UUID, oracle AddressUsageOracle) ([]AddressInfo, error)
 	// GetAllObservableAddresses returns all addresses with derivation path in
 	// the range [fromIndex..toIndex] (inclusive)
 	// if toIndex is 0, we use lookAheadSize (exclusive)
-	GetAllObservableAddresses(id uuid.UUID, change Change,
+	GetAllObservableAddresses(ctx context.Context, id uuid.UUID, change Change,
 		fromIndex uint32, toIndex uint32) ([]AddressInfo, error)
 	// GetDerivationPath reads the address-to-derivations mapping in the keystore,
 	// and returns the DerivationPath corresponding to the specified address.
-	GetDerivationPath(id uuid.UUID, address string) (DerivationPath, error)
+	GetDerivationPath(ctx context.Context, id uuid.UUID, address string) (DerivationPath, error)
 	// GetAddressesPublicKeys reads the derivation-to-publicKey mapping in the keystore,
-	// and returns extendend public keys corresponding to given derivations.
-	GetAddressesPublicKeys(id uuid.UUID, derivations []DerivationPath) ([]string, error)
+	// and returns the public keys corresponding to given derivations.
+	//
+	// There is one public key per derivation for single-key keychains, and
+	// one per cosigner (in cosigner order) for multisig keychains.
+	GetAddressesPublicKeys(ctx context.Context, id uuid.UUID, derivations []DerivationPath) ([][]string, error)
+	// FindAddressOwner looks up the keychains from which the given addresses
+	// were derived, in an address index maintained by the keystore across all
+	// keychains.
+	//
+	// There is one entry per address, in the same order, which is nil if the
+	// address was never derived by any keychain.
+	FindAddressOwner(ctx context.Context, addresses []string) ([]*AddressOwner, error)
+	// ListKeychains returns a page of the registered keychains selected by the
+	// filter, starting at the given cursor, which is empty for the first page.
+	//
+	// It also returns the cursor of the next page, which is empty once all
+	// keychains have been listed. A limit of 0 is replaced by
+	// DefaultPageSize. Backends based on redis SCAN may return slightly more
+	// keychains than limit.
+	ListKeychains(ctx context.Context, filter KeychainFilter, cursor string, limit uint32) ([]KeychainInfo, string, error)
+}
+
+// AddressSearcher is implemented by keystores which can discover addresses
+// beyond the observable range of a keychain, when they are marked as used.
+type AddressSearcher interface {
+	// SetAddressSearchLimit sets the number of addresses derived past the
+	// observable range of each chain, when looking for an unknown address
+	// marked as used. Discovery is disabled with a limit of 0, the default.
+	//
+	// It must be called before the keystore is used.
+	SetAddressSearchLimit(limit uint32)
 }
 
 // DefaultLookaheadSize defines the zone of addresses that the keychain must
 // observe.
 const DefaultLookaheadSize = 20
 
+// DefaultPageSize is the number of keychains per page of ListKeychains, when
+// no limit is given.
+const DefaultPageSize = 100
+
 // Scheme defines the scheme on which a keychain entry is based.
 type Scheme string
 
@@ -91,8 +173,32 @@ const (
 
 	// BIP84 indicates that the keychain scheme is native segwit.
 	BIP84 Scheme = "BIP84"
+
+	// BIP86 indicates that the keychain scheme is taproot.
+	BIP86 Scheme = "BIP86"
+
+	// MultisigP2SH indicates that the keychain scheme is legacy multisig.
+	MultisigP2SH Scheme = "MULTISIG_P2SH"
+
+	// MultisigP2SHP2WSH indicates that the keychain scheme is segwit
+	// multisig.
+	MultisigP2SHP2WSH Scheme = "MULTISIG_P2SH_P2WSH"
+
+	// MultisigP2WSH indicates that the keychain scheme is native segwit
+	// multisig.
+	MultisigP2WSH Scheme = "MULTISIG_P2WSH"
 )
 
+// IsMultisig returns true if the Scheme describes multisig keychains.
+func (s Scheme) IsMultisig() bool {
+	switch s {
+	case MultisigP2SH, MultisigP2SHP2WSH, MultisigP2WSH:
+		return true
+	default:
+		return false
+	}
+}
+
 // KeychainInfo models the global information related to an account registered
 // in the keystore.
 //
@@ -102,10 +208,11 @@ type KeychainInfo struct {
 	ID                            uuid.UUID        `json:"id"`                               // Keychain ID as a uuid.UUID type
 	ExternalDescriptor            string           `json:"external_descriptor"`              // External chain output descriptor
 	InternalDescriptor            string           `json:"internal_descriptor"`              // Internal chain output descriptor
+	MultipathDescriptor           string           `json:"multipath_descriptor,omitempty"`   // External and internal chains output descriptor
 	ExtendedPublicKey             string           `json:"extended_public_key"`              // Extended public key serialized with standard HD version bytes
 	SLIP32ExtendedPublicKey       string           `json:"slip32_extended_public_key"`       // Extended public key serialized with SLIP-0132 HD version bytes
-	ExternalXPub                  string           `json:"external_xpub"`                    // External chain extended public key at HD tree depth 4
-	InternalXPub                  string           `json:"internal_xpub"`                    // Internal chain extended public key at HD tree depth 4
+	ExternalXPub                  string           `json:"external_xpub"`                    // External chain extended public key, at HD tree depth 4 with the default path template
+	InternalXPub                  string           `json:"internal_xpub"`                    // Internal chain extended public key, empty for single-chain path templates
 	MaxConsecutiveExternalIndex   uint32           `json:"max_consecutive_external_index"`   // Max consecutive index (without any gap) on the external chain
 	MaxConsecutiveInternalIndex   uint32           `json:"max_consecutive_internal_index"`   // Max consecutive index (without any gap) on the internal chain
 	LookaheadSize                 uint32           `json:"lookahead_size"`                   // Numerical size of the lookahead zone
@@ -115,14 +222,22 @@ type KeychainInfo struct {
 	NonConsecutiveExternalIndexes []uint32         `json:"non_consecutive_external_indexes"` // Used external indexes that are creating a gap in the derivation
 	NonConsecutiveInternalIndexes []uint32         `json:"non_consecutive_internal_indexes"` // Used internal indexes that are creating a gap in the derivation
 	Metadata                      string           `json:"metadata"`                         // Additional info, unspecified
+	Threshold                     uint32           `json:"threshold,omitempty"`              // Number of required signatures of a multisig keychain
+	ExtendedPublicKeys            []string         `json:"extended_public_keys,omitempty"`   // Extended public keys of all cosigners of a multisig keychain
+	ExternalXPubs                 []string         `json:"external_xpubs,omitempty"`         // External chain extended public keys of all cosigners
+	InternalXPubs                 []string         `json:"internal_xpubs,omitempty"`         // Internal chain extended public keys of all cosigners
+	KeyOrigin                     *KeyOrigin       `json:"key_origin,omitempty"`             // Origin of the extended public key, if known
+	KeyOrigins                    []*KeyOrigin     `json:"key_origins,omitempty"`            // Origins of the extended public keys of all cosigners, if known
+	PathTemplate                  PathTemplate     `json:"path_template,omitempty"`          // Chains of the keychain, empty for the default path template
+	AddressFormat                 AddressFormat    `json:"address_format,omitempty"`         // Format of the addresses, empty for the standard format of the network
 }
 
 // Meta is a struct containing account details corresponding to a keychain ID,
 // such as derivations, addresses, etc.
 type Meta struct {
-	Main        KeychainInfo              `json:"main"`
-	Derivations map[DerivationPath]string `json:"derivations"` // public key at HD tree depth 5
-	Addresses   map[string]DerivationPath `json:"addresses"`   // derivation path at HD tree depth 5
+	Main        KeychainInfo                `json:"main"`
+	Derivations map[DerivationPath][]string `json:"derivations"` // public keys of addresses, one per cosigner
+	Addresses   map[string]DerivationPath   `json:"addresses"`   // derivation path of addresses
 }
 
 type FromChainCode struct {
@@ -177,8 +292,8 @@ func (m *Meta) UnmarshalJSON(data []byte) error {
 	// Step 2: Create an anonymous struct with raw replacements for the special
 	// fields.
 	aux := &struct {
-		Derivations map[string]string `json:"derivations"`
-		Addresses   map[string]string `json:"addresses"`
+		Derivations map[string]json.RawMessage `json:"derivations"`
+		Addresses   map[string]string          `json:"addresses"`
 		Main        struct {
 			ID string `json:"id"`
 			*KeychainInfoAlias
@@ -222,7 +337,7 @@ func (m *Meta) UnmarshalJSON(data []byte) error {
 		}
 	}
 
-	m.Derivations = map[DerivationPath]string{}
+	m.Derivations = map[DerivationPath][]string{}
 
 	for k, v := range aux.Derivations {
 		path := strings.Split(k, "/")
@@ -241,7 +356,19 @@ func (m *Meta) UnmarshalJSON(data []byte) error {
 			uint32(changeIndex), uint32(addressIndex),
 		}
 
-		m.Derivations[derivation] = v
+		// Keychains stored before multisig support have a single public
+		// key per derivation.
+		var publicKeys []string
+		if err := json.Unmarshal(v, &publicKeys); err != nil {
+			var publicKey string
+			if err := json.Unmarshal(v, &publicKey); err != nil {
+				return err
+			}
+
+			publicKeys = []string{publicKey}
+		}
+
+		m.Derivations[derivation] = publicKeys
 	}
 
 	return nil
@@ -252,12 +379,102 @@ func (m *Meta) UnmarshalJSON(data []byte) error {
 type AddressInfo struct {
 	Address    string
 	Derivation DerivationPath
+	Path       []uint32 // derivation path relative to the extended public key, see PathTemplate
 	Change     Change
 }
 
+// KeychainFilter selects keychains in ListKeychains. Empty fields select any
+// keychain.
+type KeychainFilter struct {
+	Network        chaincfg.Network
+	Scheme         Scheme
+	MetadataPrefix string
+}
+
+// Match returns true if the keychain is selected by the filter.
+func (f KeychainFilter) Match(info KeychainInfo) bool {
+	if f.Network != "" && info.Network != f.Network {
+		return false
+	}
+
+	if f.Scheme != "" && info.Scheme != f.Scheme {
+		return false
+	}
+
+	return strings.HasPrefix(info.Metadata, f.MetadataPrefix)
+}
+
+// pageSize returns the number of keychains per page of ListKeychains, for a
+// given limit.
+func pageSize(limit uint32) uint32 {
+	if limit == 0 {
+		return DefaultPageSize
+	}
+
+	return limit
+}
+
+// MarkStatus is the outcome of marking an address as used.
+type MarkStatus int
+
+const (
+	// Marked indicates that the address was marked as used.
+	Marked MarkStatus = iota
+
+	// AlreadyUsed indicates that the address was already used, and that the
+	// keychain was left unchanged.
+	AlreadyUsed
+
+	// MarkUnknownAddress indicates that the address was never derived by the
+	// keychain, which must observe it before it is marked as used.
+	MarkUnknownAddress
+)
+
+// MarkResult is the outcome of marking an address of a batch as used.
+type MarkResult struct {
+	Address    string
+	Derivation DerivationPath
+	Path       []uint32 // derivation path relative to the extended public key, see PathTemplate
+	Status     MarkStatus
+}
+
+// AddressOwner identifies the keychain from which an address was derived, and
+// the derivation path of the address in this keychain.
+type AddressOwner struct {
+	KeychainID uuid.UUID      `json:"keychain_id"`
+	Derivation DerivationPath `json:"derivation"`
+	Path       []uint32       `json:"path,omitempty"` // see AddressInfo.Path
+}
+
+// addressInfo returns the AddressInfo of an address of the keychain, derived
+// at path.
+func (m Meta) addressInfo(address string, path DerivationPath) AddressInfo {
+	return AddressInfo{
+		Address:    address,
+		Derivation: path,
+		Path:       m.Main.PathTemplate.Path(path),
+		Change:     path.ChangeIndex(),
+	}
+}
+
+// checkChange returns an error if the path template of the keychain has no
+// chain for change.
+func (m Meta) checkChange(change Change) error {
+	if !m.Main.PathTemplate.HasChange(change) {
+		return errors.Wrapf(ErrUnrecognizedChange,
+			"%d is not a chain of path template %s", change, m.Main.PathTemplate)
+	}
+
+	return nil
+}
+
 // ChangeXPub returns the ExtendedPublicKey of the keychain for the specified Change
 // (Internal or External).
 func (m Meta) ChangeXPub(change Change) (string, error) {
+	if err := m.checkChange(change); err != nil {
+		return "", err
+	}
+
 	switch change {
 	case External:
 		return m.Main.ExternalXPub, nil
@@ -268,9 +485,30 @@ func (m Meta) ChangeXPub(change Change) (string, error) {
 	}
 }
 
+// ChangeXPubs returns the extended public keys of all cosigners of a
+// multisig keychain for the specified Change (Internal or External).
+func (m Meta) ChangeXPubs(change Change) ([]string, error) {
+	if err := m.checkChange(change); err != nil {
+		return nil, err
+	}
+
+	switch change {
+	case External:
+		return m.Main.ExternalXPubs, nil
+	case Internal:
+		return m.Main.InternalXPubs, nil
+	default:
+		return nil, errors.Wrapf(ErrUnrecognizedChange, fmt.Sprint(change))
+	}
+}
+
 // MaxConsecutiveIndex returns the max consecutive index without any gap,
 // for the specified Change (Internal or External).
 func (m Meta) MaxConsecutiveIndex(change Change) (uint32, error) {
+	if err := m.checkChange(change); err != nil {
+		return 0, err
+	}
+
 	switch change {
 	case External:
 		return m.Main.MaxConsecutiveExternalIndex, nil
@@ -346,6 +584,10 @@ func (m *Meta) SetNonConsecutiveIndexes(change Change, indexes []uint32) error {
 // address indexes, for a given Change. It is therefore the maximum index
 // that is currently observed by the keychain.
 func (m Meta) MaxObservableIndex(change Change) (uint32, error) {
+	if err := m.checkChange(change); err != nil {
+		return 0, err
+	}
+
 	switch change {
 	case External:
 		n := uint32(len(m.Main.NonConsecutiveExternalIndexes))
@@ -362,7 +604,7 @@ func (m Meta) MaxObservableIndex(change Change) (uint32, error) {
 func (m *Meta) ResetKeychainMeta() {
 	m.Main.MaxConsecutiveExternalIndex = 0
 	m.Main.MaxConsecutiveInternalIndex = 0
-	m.Derivations = map[DerivationPath]string{}
+	m.Derivations = map[DerivationPath][]string{}
 	m.Addresses = map[string]DerivationPath{}
 }
 
@@ -380,9 +622,38 @@ func uuidFromInput(
 	return uuid.NewSHA1(namespace, []byte(key)), nil
 }
 
+// unscopedIDNetworks lists the networks whose keychain IDs do not depend on
+// the Network, since they were supported before keychain IDs did.
+var unscopedIDNetworks = map[chaincfg.Network]bool{
+	chaincfg.BitcoinMainnet:  true,
+	chaincfg.BitcoinTestnet3: true,
+	chaincfg.BitcoinRegtest:  true,
+	chaincfg.LitecoinMainnet: true,
+}
+
+// networkIDInput returns the part of the input of the ID of a keychain which
+// depends on its Network and AddressFormat, see uuidFromInput.
+//
+// Extended public keys of several networks share the same version bytes, such
+// as the tpub of the Bitcoin test networks, or the xpub of Bitcoin Cash, so
+// that the same key can be registered on each of them, in each address
+// format. The input is empty on the networks of unscopedIDNetworks, whose
+// keychains keep the ID they already had.
+func networkIDInput(net chaincfg.Network, format AddressFormat) string {
+	if unscopedIDNetworks[net] {
+		return ""
+	}
+
+	return string(net) + string(format)
+}
+
 func keystoreCreate(
+	ctx context.Context,
 	extendedPublicKey string,
 	fromChainCode *FromChainCode,
+	origin *KeyOrigin,
+	template PathTemplate,
+	format AddressFormat,
 	scheme Scheme,
 	net chaincfg.Network,
 	lookaheadSize uint32,
@@ -390,8 +661,17 @@ func keystoreCreate(
 	metadata string,
 	client bitcoin.CoinServiceClient,
 ) (Meta, error) {
+	template, err := ParsePathTemplate(string(template))
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if err := checkNetwork(scheme, format, net); err != nil {
+		return Meta{}, err
+	}
+
 	if fromChainCode != nil {
-		res, err := GetAccountExtendedKey(client, net, fromChainCode)
+		res, err := GetAccountExtendedKey(ctx, client, net, fromChainCode)
 		if err != nil {
 			return Meta{}, errors.Wrapf(err,
 				"failed to get extendend public key from chain code, request = %v", fromChainCode)
@@ -400,31 +680,58 @@ func keystoreCreate(
 		extendedPublicKey = res.ExtendedKey
 	}
 
-	internalDescriptor, err := MakeDescriptor(extendedPublicKey, Internal, scheme)
+	extendedPublicKey, err = FromSLIP132(extendedPublicKey, scheme, net)
 	if err != nil {
-		return Meta{}, errors.Wrapf(err,
-			"failed to make internal descriptor, xkey = %v", extendedPublicKey)
+		return Meta{}, err
 	}
 
-	externalDescriptor, err := MakeDescriptor(extendedPublicKey, External, scheme)
+	slip132ExtendedPublicKey, err := ToSLIP132(extendedPublicKey, scheme, net)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if origin != nil && len(origin.AccountPath) == 0 {
+		accountIndex := index
+		if fromChainCode != nil {
+			accountIndex = fromChainCode.AccountIndex
+		}
+
+		path, err := DefaultAccountPath(scheme, net, accountIndex)
+		if err != nil {
+			return Meta{}, errors.Wrapf(err,
+				"failed to infer account path of %s", origin)
+		}
+
+		origin = &KeyOrigin{
+			MasterFingerprint: origin.MasterFingerprint,
+			AccountPath:       path,
+		}
+	}
+
+	desc := &Descriptor{
+		Scheme: scheme,
+		Keys:   []DescriptorKey{{Origin: origin, ExtendedPublicKey: extendedPublicKey}},
+	}
+
+	internalDescriptor, externalDescriptor, multipathDescriptor, err := makeDescriptors(desc, template)
 	if err != nil {
 		return Meta{}, errors.Wrapf(err,
-			"failed to make internal descriptor, xkey = %v", extendedPublicKey)
+			"failed to make descriptors, xkey = %v", extendedPublicKey)
 	}
 
-	externalChild, err := childKDF(client, extendedPublicKey, 0)
+	externalXPub, err := chainXPub(ctx, client, extendedPublicKey, template, External)
 	if err != nil {
-		return Meta{}, errors.Wrapf(
-			err, "failed to derive xpub %v at index %v", extendedPublicKey, 0)
+		return Meta{}, err
 	}
 
-	internalChild, err := childKDF(client, extendedPublicKey, 1)
+	internalXPub, err := chainXPub(ctx, client, extendedPublicKey, template, Internal)
 	if err != nil {
-		return Meta{}, errors.Wrapf(
-			err, "failed to derive xpub %v at index %v", extendedPublicKey, 1)
+		return Meta{}, err
 	}
 
-	id, err := uuidFromInput(extendedPublicKey, scheme)
+	// Keychains with the default path template keep the ID they had before
+	// path templates, see also networkIDInput.
+	id, err := uuidFromInput(extendedPublicKey+string(template)+networkIDInput(net, format), scheme)
 	if err != nil {
 		return Meta{}, errors.Wrapf(
 			err, "cannot generate uuid")
@@ -434,29 +741,273 @@ func keystoreCreate(
 		ID:                          id,
 		InternalDescriptor:          internalDescriptor,
 		ExternalDescriptor:          externalDescriptor,
+		MultipathDescriptor:         multipathDescriptor,
 		ExtendedPublicKey:           extendedPublicKey,
-		SLIP32ExtendedPublicKey:     extendedPublicKey, // TODO: Convert ExtendedPublicKey to SLIP-0132 form
-		ExternalXPub:                externalChild.ExtendedKey,
+		SLIP32ExtendedPublicKey:     slip132ExtendedPublicKey,
+		ExternalXPub:                externalXPub,
 		MaxConsecutiveExternalIndex: 0,
-		InternalXPub:                internalChild.ExtendedKey,
+		InternalXPub:                internalXPub,
 		MaxConsecutiveInternalIndex: 0,
 		LookaheadSize:               lookaheadSize,
 		Scheme:                      scheme,
 		Network:                     net,
 		AccountIndex:                index,
 		Metadata:                    metadata,
+		KeyOrigin:                   origin,
+		PathTemplate:                template,
+		AddressFormat:               format,
 	}
 
 	meta := Meta{
 		Main:        keychainInfo,
-		Derivations: map[DerivationPath]string{},
+		Derivations: map[DerivationPath][]string{},
 		Addresses:   map[string]DerivationPath{},
 	}
 
 	return meta, nil
 }
 
+// keystoreCreateMultisig creates the Meta of a multisig keychain. The
+// optional origins are the key origins of extendedPublicKeys, in the same
+// order.
+func keystoreCreateMultisig(
+	ctx context.Context,
+	extendedPublicKeys []string,
+	origins []*KeyOrigin,
+	template PathTemplate,
+	format AddressFormat,
+	threshold uint32,
+	scheme Scheme,
+	net chaincfg.Network,
+	lookaheadSize uint32,
+	index uint32,
+	metadata string,
+	client bitcoin.CoinServiceClient,
+) (Meta, error) {
+	template, err := ParsePathTemplate(string(template))
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if err := checkNetwork(scheme, format, net); err != nil {
+		return Meta{}, err
+	}
+
+	if !scheme.IsMultisig() {
+		return Meta{}, errors.Wrapf(ErrUnrecognizedScheme,
+			"%s is not a multisig scheme", scheme)
+	}
+
+	if threshold < 1 || int(threshold) > len(extendedPublicKeys) ||
+		len(extendedPublicKeys) > address.MaxMultisigKeys {
+		return Meta{}, errors.Wrapf(ErrInvalidMultisig,
+			"threshold %d with %d cosigners", threshold, len(extendedPublicKeys))
+	}
+
+	normalized := make([]string, len(extendedPublicKeys))
+	seen := make(map[string]bool, len(extendedPublicKeys))
+
+	for i, extendedPublicKey := range extendedPublicKeys {
+		var err error
+		if normalized[i], err = FromSLIP132(extendedPublicKey, scheme, net); err != nil {
+			return Meta{}, err
+		}
+
+		// A cosigner listed twice could spend alone what requires two
+		// signatures.
+		if seen[normalized[i]] {
+			return Meta{}, errors.Wrapf(ErrInvalidMultisig,
+				"duplicate cosigner %s", extendedPublicKey)
+		}
+
+		seen[normalized[i]] = true
+	}
+
+	extendedPublicKeys = normalized
+
+	if origins != nil && len(origins) != len(extendedPublicKeys) {
+		return Meta{}, errors.Wrapf(ErrInvalidKeyOrigin,
+			"%d key origins for %d cosigners", len(origins), len(extendedPublicKeys))
+	}
+
+	desc := &Descriptor{Scheme: scheme, Threshold: threshold}
+	bare := &Descriptor{Scheme: scheme, Threshold: threshold}
+
+	for i, extendedPublicKey := range extendedPublicKeys {
+		key := DescriptorKey{ExtendedPublicKey: extendedPublicKey}
+		bare.Keys = append(bare.Keys, key)
+
+		if origins != nil {
+			key.Origin = origins[i]
+		}
+
+		desc.Keys = append(desc.Keys, key)
+	}
+
+	internalDescriptor, externalDescriptor, multipathDescriptor, err := makeDescriptors(desc, template)
+	if err != nil {
+		return Meta{}, errors.Wrapf(err,
+			"failed to make descriptors, xkeys = %v", extendedPublicKeys)
+	}
+
+	externalXPubs := make([]string, len(extendedPublicKeys))
+	internalXPubs := make([]string, len(extendedPublicKeys))
+
+	for i, extendedPublicKey := range extendedPublicKeys {
+		if externalXPubs[i], err = chainXPub(ctx, client, extendedPublicKey, template, External); err != nil {
+			return Meta{}, err
+		}
+
+		if internalXPubs[i], err = chainXPub(ctx, client, extendedPublicKey, template, Internal); err != nil {
+			return Meta{}, err
+		}
+	}
+
+	if !template.HasChange(Internal) {
+		internalXPubs = nil
+	}
+
+	// The ID only depends on the external descriptor without key origins nor
+	// checksum, the path template and the scheme, which are not sensitive to
+	// the order of cosigners.
+	derivation, _ := template.descriptorDerivation(External)
+
+	bareDescriptor, err := descriptorExpression(bare, derivation)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	id, err := uuidFromInput(bareDescriptor+string(template)+networkIDInput(net, format), scheme)
+	if err != nil {
+		return Meta{}, errors.Wrapf(
+			err, "cannot generate uuid")
+	}
+
+	keychainInfo := KeychainInfo{
+		ID:                          id,
+		InternalDescriptor:          internalDescriptor,
+		ExternalDescriptor:          externalDescriptor,
+		MultipathDescriptor:         multipathDescriptor,
+		MaxConsecutiveExternalIndex: 0,
+		MaxConsecutiveInternalIndex: 0,
+		LookaheadSize:               lookaheadSize,
+		Scheme:                      scheme,
+		Network:                     net,
+		AccountIndex:                index,
+		Metadata:                    metadata,
+		Threshold:                   threshold,
+		ExtendedPublicKeys:          extendedPublicKeys,
+		ExternalXPubs:               externalXPubs,
+		InternalXPubs:               internalXPubs,
+		KeyOrigins:                  origins,
+		PathTemplate:                template,
+		AddressFormat:               format,
+	}
+
+	meta := Meta{
+		Main:        keychainInfo,
+		Derivations: map[DerivationPath][]string{},
+		Addresses:   map[string]DerivationPath{},
+	}
+
+	return meta, nil
+}
+
+func keystoreCreateFromDescriptor(
+	ctx context.Context,
+	descriptor string,
+	net chaincfg.Network,
+	lookaheadSize uint32,
+	index uint32,
+	metadata string,
+	client bitcoin.CoinServiceClient,
+) (Meta, error) {
+	desc, err := ParseDescriptor(descriptor)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if net == "" {
+		if net, err = desc.Network(); err != nil {
+			return Meta{}, errors.Wrapf(err,
+				"failed to infer network of descriptor %s", descriptor)
+		}
+	}
+
+	// Single-key descriptors usually carry the account index in their key
+	// origin, e.g. [d34db33f/84'/0'/0'].
+	if origin := desc.Keys[0].Origin; index == 0 && len(desc.Keys) == 1 && origin != nil {
+		index, _ = origin.AccountIndex()
+	}
+
+	if desc.Scheme.IsMultisig() {
+		// Key origins are only stored if the descriptor has some.
+		var origins []*KeyOrigin
+
+		for i, key := range desc.Keys {
+			if key.Origin == nil {
+				continue
+			}
+
+			if origins == nil {
+				origins = make([]*KeyOrigin, len(desc.Keys))
+			}
+
+			origins[i] = key.Origin
+		}
+
+		return keystoreCreateMultisig(
+			ctx, desc.ExtendedPublicKeys(), origins, "", DefaultAddressFormat, desc.Threshold, desc.Scheme,
+			net, lookaheadSize, index, metadata, client)
+	}
+
+	// A key origin without derivation path only carries the master key
+	// fingerprint of a master extended public key, which is not an
+	// account-level key.
+	if origin := desc.Keys[0].Origin; origin != nil && len(origin.AccountPath) == 0 {
+		return Meta{}, errors.Wrapf(ErrInvalidKeyOrigin,
+			"missing account path in key origin %s", origin)
+	}
+
+	return keystoreCreate(
+		ctx, desc.Keys[0].ExtendedPublicKey, nil, desc.Keys[0].Origin, "", DefaultAddressFormat, desc.Scheme,
+		net, lookaheadSize, index, metadata, client)
+}
+
+// makeDescriptors builds the internal, external and multipath descriptors of
+// desc, for the chains of template.
+//
+// The internal descriptor is empty for single-chain templates, and the
+// multipath descriptor is empty if both chains can not be described by a
+// single multipath expression.
+func makeDescriptors(desc *Descriptor, template PathTemplate) (string, string, string, error) {
+	var internal, multipath string
+
+	if derivation, ok := template.descriptorDerivation(Internal); ok {
+		var err error
+		if internal, err = makeDescriptor(desc, derivation); err != nil {
+			return "", "", "", errors.Wrap(err, "failed to make internal descriptor")
+		}
+	}
+
+	derivation, _ := template.descriptorDerivation(External)
+
+	external, err := makeDescriptor(desc, derivation)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "failed to make external descriptor")
+	}
+
+	if derivation, ok := template.multipathDerivation(); ok {
+		if multipath, err = makeDescriptor(desc, derivation); err != nil {
+			return "", "", "", errors.Wrap(err, "failed to make multipath descriptor")
+		}
+	}
+
+	return internal, external, multipath, nil
+}
+
 func (m *Meta) keystoreGetFreshAddresses(
+	ctx context.Context,
 	client bitcoin.CoinServiceClient,
 	change Change,
 	size uint32,
@@ -472,27 +1023,25 @@ func (m *Meta) keystoreGetFreshAddresses(
 		return nil, err
 	}
 
-	for i := uint32(0); uint32(len(addrs)) < size; i++ {
+	var paths []DerivationPath
+
+	for i := uint32(0); uint32(len(paths)) < size; i++ {
 		index := maxConsecutiveIndex + i
 
 		// Skip any index that exists in non-consecutive indexes, to prevent
 		// address reuse.
 		if !contains(nonConsecutiveIndexes, index) {
-			path := DerivationPath{uint32(change), index}
-
-			addr, err := deriveAddress(client, m, path)
-			if err != nil {
-				return addrs, err
-			}
+			paths = append(paths, DerivationPath{uint32(change), index})
+		}
+	}
 
-			addrInfo := AddressInfo{
-				Address:    addr,
-				Derivation: path,
-				Change:     change,
-			}
+	derived, err := deriveAddresses(ctx, client, m, paths)
+	if err != nil {
+		return addrs, err
+	}
 
-			addrs = append(addrs, addrInfo)
-		}
+	for i, path := range paths {
+		addrs = append(addrs, m.addressInfo(derived[i], path))
 	}
 	return addrs, nil
 }
@@ -579,6 +1128,7 @@ func (m *Meta) keystoreMarkPathAsUsed(path DerivationPath) error {
 }
 
 func (m *Meta) keystoreGetAllObservableAddresses(
+	ctx context.Context,
 	client bitcoin.CoinServiceClient,
 	change Change,
 	fromIndex uint32,
@@ -589,7 +1139,13 @@ func (m *Meta) keystoreGetAllObservableAddresses(
 		return nil, err
 	}
 
-	length := minUint32(toIndex, maxObservableIndex) - fromIndex
+	lastIndex := minUint32(toIndex, maxObservableIndex)
+	if fromIndex > lastIndex {
+		// Empty range, which must not wrap around when computing its length.
+		return []AddressInfo{}, nil
+	}
+
+	length := lastIndex - fromIndex
 
 	log.WithFields(log.Fields{
 		"maxObservable": maxObservableIndex,
@@ -597,47 +1153,234 @@ func (m *Meta) keystoreGetAllObservableAddresses(
 		"computedRange": []uint32{fromIndex, fromIndex + length},
 	}).Info("[keystore] GetAllObservableAddresses: compute range")
 
-	addrs := []AddressInfo{}
+	paths := make([]DerivationPath, 0, length+1)
 
 	for i := fromIndex; i <= fromIndex+length; i++ {
-		path := DerivationPath{uint32(change), i}
+		paths = append(paths, DerivationPath{uint32(change), i})
+	}
 
-		addr, err := deriveAddress(client, m, path)
-		if err != nil {
-			return nil, err
-		}
+	derived, err := deriveAddresses(ctx, client, m, paths)
+	if err != nil {
+		return nil, err
+	}
 
-		addrInfo := AddressInfo{
-			Address:    addr,
-			Derivation: path,
-			Change:     change,
-		}
+	addrs := []AddressInfo{}
 
-		addrs = append(addrs, addrInfo)
+	for i, path := range paths {
+		addrs = append(addrs, m.addressInfo(derived[i], path))
 	}
 
 	return addrs, nil
 }
 
 func (m *Meta) keystoreGetDerivationPath(address string) (DerivationPath, error) {
-	path, ok := m.Addresses[address]
+	path, ok := m.Addresses[m.Main.normalizeAddress(address)]
 	if !ok {
 		return DerivationPath{}, ErrAddressNotFound
 	}
 	return path, nil
 }
 
-func keystoreMarkAddressAsUsed(s Keystore, id uuid.UUID, address string) error {
-	path, err := s.GetDerivationPath(id, address)
+func keystoreMarkAddressAsUsed(ctx context.Context, s Keystore, id uuid.UUID, address string) error {
+	results, err := s.MarkAddressesAsUsed(ctx, id, []string{address})
 	if err != nil {
 		return err
 	}
 
-	return s.MarkPathAsUsed(id, path)
+	if results[0].Status == MarkUnknownAddress {
+		return ErrAddressNotFound
+	}
+
+	return nil
+}
+
+// keystoreMarkAddressesAsUsed marks addresses as used, given the derivation
+// paths of the addresses known by the keychain. Addresses are looked up in
+// the AddressFormat of the keychain, but reported as given.
+//
+// Unknown addresses are searched beyond the observable range, if searchLimit
+// is not 0, see keystoreDiscoverAddresses. The addresses discovered are
+// returned, so that backends can index them.
+func (m *Meta) keystoreMarkAddressesAsUsed(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	addresses []string,
+	paths map[string]DerivationPath,
+	searchLimit uint32,
+) ([]MarkResult, []AddressInfo, error) {
+	var unknown []string
+
+	normalized := m.Main.normalizeAddresses(addresses)
+
+	for _, address := range normalized {
+		if _, ok := paths[address]; !ok {
+			unknown = append(unknown, address)
+		}
+	}
+
+	discovered, err := m.keystoreDiscoverAddresses(ctx, client, unknown, searchLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, addr := range discovered {
+		paths[addr.Address] = addr.Derivation
+	}
+
+	results := make([]MarkResult, len(addresses))
+
+	// If any mark fails, the keychain is left unchanged: indexes are only
+	// appended to, so a copy of the KeychainInfo is enough to restore them,
+	// and the derivations recorded by keystoreDiscoverAddresses, which were
+	// all unknown, are removed.
+	main := m.Main
+
+	restore := func() {
+		m.Main = main
+
+		for _, addr := range discovered {
+			delete(m.Addresses, addr.Address)
+			delete(m.Derivations, addr.Derivation)
+		}
+	}
+
+	for i, address := range addresses {
+		results[i].Address = address
+
+		path, ok := paths[normalized[i]]
+		if !ok {
+			results[i].Status = MarkUnknownAddress
+			continue
+		}
+
+		results[i].Derivation = path
+		results[i].Path = m.Main.PathTemplate.Path(path)
+
+		used, err := m.isPathUsed(path)
+		if err != nil {
+			restore()
+			return nil, nil, err
+		}
+
+		if used {
+			results[i].Status = AlreadyUsed
+			continue
+		}
+
+		if err := m.keystoreMarkPathAsUsed(path); err != nil {
+			restore()
+			return nil, nil, err
+		}
+
+		results[i].Status = Marked
+	}
+
+	return results, discovered, nil
+}
+
+// keystoreDiscoverAddresses derives addresses past the observable range of
+// all chains, up to searchLimit addresses per chain, until all given
+// addresses are found.
+//
+// Only the derivations of the addresses found are recorded in the keychain,
+// so that a failed search does not grow it.
+func (m *Meta) keystoreDiscoverAddresses(
+	ctx context.Context,
+	client bitcoin.CoinServiceClient,
+	addresses []string,
+	searchLimit uint32,
+) ([]AddressInfo, error) {
+	if searchLimit == 0 || len(addresses) == 0 {
+		return nil, nil
+	}
+
+	wanted := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		wanted[address] = true
+	}
+
+	scratch := &Meta{
+		Main:        m.Main,
+		Derivations: map[DerivationPath][]string{},
+		Addresses:   map[string]DerivationPath{},
+	}
+
+	var discovered []AddressInfo
+
+	for _, change := range m.Main.PathTemplate.Changes() {
+		if len(wanted) == 0 {
+			break
+		}
+
+		maxObservableIndex, err := m.MaxObservableIndex(change)
+		if err != nil {
+			return nil, err
+		}
+
+		var paths []DerivationPath
+
+		for i := uint32(1); i <= searchLimit; i++ {
+			path := DerivationPath{uint32(change), maxObservableIndex + i}
+
+			// Derivations beyond the observable range may have been
+			// requested with GetAllObservableAddresses.
+			if _, ok := m.Derivations[path]; ok {
+				continue
+			}
+
+			paths = append(paths, path)
+		}
+
+		derived, err := deriveAddresses(ctx, client, scratch, paths)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, path := range paths {
+			addr := derived[i]
+
+			if !wanted[addr] {
+				continue
+			}
+
+			delete(wanted, addr)
+
+			m.Addresses[addr] = path
+			m.Derivations[path] = scratch.Derivations[path]
+
+			discovered = append(discovered, m.addressInfo(addr, path))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":         m.Main.ID.String(),
+		"searched":   addresses,
+		"discovered": discovered,
+	}).Info("[keystore] discover addresses beyond the observable range")
+
+	return discovered, nil
+}
+
+// isPathUsed returns true if a derivation path was marked as used.
+func (m Meta) isPathUsed(path DerivationPath) (bool, error) {
+	change := path.ChangeIndex()
+
+	maxConsecutiveIndex, err := m.MaxConsecutiveIndex(change)
+	if err != nil {
+		return false, err
+	}
+
+	nonConsecutiveIndexes, err := m.NonConsecutiveIndexes(change)
+	if err != nil {
+		return false, err
+	}
+
+	return path.AddressIndex() < maxConsecutiveIndex ||
+		contains(nonConsecutiveIndexes, path.AddressIndex()), nil
 }
 
-func (m *Meta) keystoreGetAddressesPublicKeys(derivations []DerivationPath) ([]string, error) {
-	publicKeys := make([]string, len(derivations))
+func (m *Meta) keystoreGetAddressesPublicKeys(derivations []DerivationPath) ([][]string, error) {
+	publicKeys := make([][]string, len(derivations))
 
 	for idx, derivation := range derivations {
 		publicKey, ok := m.Derivations[derivation]
@@ -0,0 +1,231 @@
+package keystore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PathTemplate defines the chains of a keychain, as BIP32 derivation paths
+// relative to the extended public key of the keychain, ending with the
+// address index *. Hardened child indexes are not supported.
+//
+// Chains are separated by a comma: the first one is the External chain, and
+// the optional second one is the Internal chain. For example:
+//   0/*,1/*      BIP44 accounts, or Electrum wallets on a master key (m/0/i)
+//   0/*          single-chain accounts
+//   0/0/*,0/1/*  BIP45 accounts of cosigner 0 (m/45'/cosigner/change/i)
+//   *            chain extended public keys, exported at depth 4
+//
+// The empty PathTemplate is the DefaultPathTemplate.
+type PathTemplate string
+
+// DefaultPathTemplate is the template of keychains of account extended public
+// keys, with an external and an internal chain.
+const DefaultPathTemplate PathTemplate = "0/*,1/*"
+
+// ParsePathTemplate parses and validates a PathTemplate, such as "0/*,1/*".
+//
+// The DefaultPathTemplate is returned as the empty PathTemplate, so that
+// keychains with the default layout are stored as they were before path
+// templates.
+func ParsePathTemplate(text string) (PathTemplate, error) {
+	chains, err := parsePathTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	formatted := make([]string, len(chains))
+	for i, chain := range chains {
+		formatted[i] = formatChain(chain)
+	}
+
+	template := PathTemplate(strings.Join(formatted, ","))
+	if template == DefaultPathTemplate {
+		return "", nil
+	}
+
+	return template, nil
+}
+
+func parsePathTemplate(text string) ([][]uint32, error) {
+	if text == "" {
+		text = string(DefaultPathTemplate)
+	}
+
+	parts := strings.Split(text, ",")
+	if len(parts) > 2 {
+		return nil, errors.Wrapf(ErrInvalidPathTemplate,
+			"%q has more than an external and an internal chain", text)
+	}
+
+	chains := make([][]uint32, len(parts))
+
+	for i, part := range parts {
+		steps := strings.Split(part, "/")
+		if steps[len(steps)-1] != "*" {
+			return nil, errors.Wrapf(ErrInvalidPathTemplate,
+				"chain %q of %q must end with /*", part, text)
+		}
+
+		chain := make([]uint32, len(steps)-1)
+
+		for j, step := range steps[:len(steps)-1] {
+			index, err := strconv.ParseUint(step, 10, 31)
+			if err != nil {
+				return nil, errors.Wrapf(ErrInvalidPathTemplate,
+					"invalid child index %q in %q", step, text)
+			}
+
+			chain[j] = uint32(index)
+		}
+
+		chains[i] = chain
+	}
+
+	if len(chains) == 2 && formatChain(chains[0]) == formatChain(chains[1]) {
+		return nil, errors.Wrapf(ErrInvalidPathTemplate,
+			"external and internal chains of %q are the same", text)
+	}
+
+	return chains, nil
+}
+
+// formatChain formats the derivation path of a chain, followed by the
+// address index *.
+func formatChain(chain []uint32) string {
+	return formatPath(append(append([]uint32{}, chain...), 0), "*")
+}
+
+// formatPath formats a relative derivation path, such as 0/1/2, with the
+// last child index replaced by last, if not empty.
+func formatPath(path []uint32, last string) string {
+	steps := make([]string, len(path))
+	for i, index := range path {
+		steps[i] = fmt.Sprint(index)
+	}
+
+	if last != "" && len(steps) > 0 {
+		steps[len(steps)-1] = last
+	}
+
+	return strings.Join(steps, "/")
+}
+
+// String returns the template, the empty PathTemplate being formatted as the
+// DefaultPathTemplate.
+func (t PathTemplate) String() string {
+	if t == "" {
+		return string(DefaultPathTemplate)
+	}
+
+	return string(t)
+}
+
+// chains returns the derivation paths of the chains of the template,
+// relative to the extended public key of the keychain.
+func (t PathTemplate) chains() [][]uint32 {
+	// Templates are validated by ParsePathTemplate when keychains are
+	// created, so that a stored template is always valid.
+	chains, _ := parsePathTemplate(string(t))
+	return chains
+}
+
+// Changes returns the chains of the template: External, followed by Internal
+// unless the template has a single chain.
+func (t PathTemplate) Changes() []Change {
+	changes := []Change{External, Internal}
+	return changes[:len(t.chains())]
+}
+
+// HasChange returns true if the template has a chain for change.
+func (t PathTemplate) HasChange(change Change) bool {
+	return change >= External && int(change) < len(t.chains())
+}
+
+// ChainPath returns the derivation path of the chain of the template for
+// change, relative to the extended public key of the keychain.
+func (t PathTemplate) ChainPath(change Change) ([]uint32, error) {
+	if !t.HasChange(change) {
+		return nil, errors.Wrapf(ErrUnrecognizedChange,
+			"%d is not a chain of path template %s", change, t)
+	}
+
+	return t.chains()[change], nil
+}
+
+// Path returns the derivation path of an address of the keychain, relative
+// to the extended public key of the keychain, such as 0/1/2 for the
+// DerivationPath{1, 2} of template 0/0/*,0/1/*.
+//
+// It returns nil if the DerivationPath is not on a chain of the template.
+func (t PathTemplate) Path(path DerivationPath) []uint32 {
+	chain, err := t.ChainPath(path.ChangeIndex())
+	if err != nil {
+		return nil
+	}
+
+	return append(append([]uint32{}, chain...), path.AddressIndex())
+}
+
+// DerivationPath returns the DerivationPath of an address of the keychain,
+// from its derivation path relative to the extended public key of the
+// keychain. It is the inverse of Path.
+func (t PathTemplate) DerivationPath(path []uint32) (DerivationPath, error) {
+	for i, chain := range t.chains() {
+		if len(path) != len(chain)+1 {
+			continue
+		}
+
+		if formatPath(path, "*") == formatChain(chain) {
+			return DerivationPath{uint32(i), path[len(path)-1]}, nil
+		}
+	}
+
+	return DerivationPath{}, errors.Wrapf(ErrInvalidDerivationPath,
+		"%v is not on a chain of path template %s", path, t)
+}
+
+// descriptorDerivation returns the derivation of the chain of change in
+// descriptors, before the address index, such as 1 for the internal chain of
+// the DefaultPathTemplate.
+func (t PathTemplate) descriptorDerivation(change Change) (string, bool) {
+	chain, err := t.ChainPath(change)
+	if err != nil {
+		return "", false
+	}
+
+	return formatPath(chain, ""), true
+}
+
+// multipathDerivation returns the BIP389 multipath derivation of both chains
+// in descriptors, such as <0;1> for the DefaultPathTemplate.
+//
+// Both chains can only be described by a multipath expression if they only
+// differ by a single child index.
+func (t PathTemplate) multipathDerivation() (string, bool) {
+	chains := t.chains()
+	if len(chains) != 2 || len(chains[0]) != len(chains[1]) {
+		return "", false
+	}
+
+	steps := make([]string, len(chains[0]))
+	diffs := 0
+
+	for i := range chains[0] {
+		steps[i] = fmt.Sprint(chains[0][i])
+
+		if chains[0][i] != chains[1][i] {
+			steps[i] = fmt.Sprintf("<%d;%d>", chains[0][i], chains[1][i])
+			diffs++
+		}
+	}
+
+	if diffs != 1 {
+		return "", false
+	}
+
+	return strings.Join(steps, "/"), true
+}
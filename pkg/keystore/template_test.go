@@ -0,0 +1,303 @@
+//go:build !integration
+// +build !integration
+
+package keystore
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/native"
+	"github.com/pkg/errors"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    PathTemplate
+		wantErr error
+	}{
+		{
+			name: "empty",
+			text: "",
+			want: "",
+		},
+		{
+			name: "default",
+			text: "0/*,1/*",
+			want: "",
+		},
+		{
+			name: "single chain",
+			text: "0/*",
+			want: "0/*",
+		},
+		{
+			name: "chain extended public key",
+			text: "*",
+			want: "*",
+		},
+		{
+			name: "bip45",
+			text: "2/0/*,2/1/*",
+			want: "2/0/*,2/1/*",
+		},
+		{
+			name: "leading zeros",
+			text: "00/*,01/*",
+			want: "",
+		},
+		{
+			name:    "hardened",
+			text:    "0'/*",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "hardened index",
+			text:    "2147483648/*",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "missing address index",
+			text:    "0,1",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "address index not last",
+			text:    "*/0",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "same chains",
+			text:    "0/*,0/*",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "too many chains",
+			text:    "0/*,1/*,2/*",
+			wantErr: ErrInvalidPathTemplate,
+		},
+		{
+			name:    "empty child index",
+			text:    "0//*",
+			wantErr: ErrInvalidPathTemplate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePathTemplate(tt.text)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("ParsePathTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Fatalf("ParsePathTemplate() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathTemplate_Path(t *testing.T) {
+	tests := []struct {
+		name     string
+		template PathTemplate
+		path     DerivationPath
+		want     []uint32
+		wantErr  error
+	}{
+		{
+			name:     "default external",
+			template: "",
+			path:     DerivationPath{0, 5},
+			want:     []uint32{0, 5},
+		},
+		{
+			name:     "default internal",
+			template: "",
+			path:     DerivationPath{1, 2},
+			want:     []uint32{1, 2},
+		},
+		{
+			name:     "chain extended public key",
+			template: "*",
+			path:     DerivationPath{0, 7},
+			want:     []uint32{7},
+		},
+		{
+			name:     "bip45 internal",
+			template: "2/0/*,2/1/*",
+			path:     DerivationPath{1, 3},
+			want:     []uint32{2, 1, 3},
+		},
+		{
+			name:     "single chain internal",
+			template: "0/*",
+			path:     DerivationPath{1, 3},
+			wantErr:  ErrInvalidDerivationPath,
+		},
+		{
+			name:     "other chain",
+			template: "2/0/*,2/1/*",
+			path:     DerivationPath{2, 3},
+			wantErr:  ErrInvalidDerivationPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.template.Path(tt.path)
+
+			if tt.wantErr != nil {
+				if got != nil {
+					t.Fatalf("Path() got = %v, want nil", got)
+				}
+
+				// The same path with the DefaultPathTemplate is not on a
+				// chain of the template either.
+				_, err := tt.template.DerivationPath(tt.path.ToSlice())
+				if errors.Cause(err) != tt.wantErr {
+					t.Fatalf("DerivationPath() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Path() got = %v, want %v", got, tt.want)
+			}
+
+			path, err := tt.template.DerivationPath(got)
+			if err != nil || path != tt.path {
+				t.Fatalf("DerivationPath() got = %v, %v, want %v", path, err, tt.path)
+			}
+		})
+	}
+}
+
+func TestInMemoryKeystore_PathTemplate(t *testing.T) {
+	ctx := context.Background()
+	keystore := NewInMemoryKeystore(native.NewCoinServiceClient())
+
+	create := func(t *testing.T, xPub string, template PathTemplate) KeychainInfo {
+		t.Helper()
+
+		info, err := keystore.Create(
+			ctx, xPub, nil, nil, template, "", BIP84, chaincfg.BitcoinMainnet,
+			DefaultLookaheadSize, 0, "")
+		if err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		return info
+	}
+
+	addresses := func(t *testing.T, info KeychainInfo, change Change) []AddressInfo {
+		t.Helper()
+
+		addrs, err := keystore.GetAllObservableAddresses(ctx, info.ID, change, 0, 4)
+		if err != nil {
+			t.Fatalf("GetAllObservableAddresses() unexpected error: %v", err)
+		}
+
+		return addrs
+	}
+
+	account := create(t, mockXPub, "")
+
+	tests := []struct {
+		name     string
+		xPub     string
+		template PathTemplate
+		// addresses of the account keychain at the same index of each chain
+		want []Change
+		// derivation path of the first address of each chain
+		wantPaths [][]uint32
+	}{
+		{
+			name:      "swapped chains",
+			xPub:      mockXPub,
+			template:  "1/*,0/*",
+			want:      []Change{Internal, External},
+			wantPaths: [][]uint32{{1, 0}, {0, 0}},
+		},
+		{
+			name:      "single chain",
+			xPub:      mockXPub,
+			template:  "1/*",
+			want:      []Change{Internal},
+			wantPaths: [][]uint32{{1, 0}},
+		},
+		{
+			name:      "chain extended public key",
+			xPub:      account.ExternalXPub,
+			template:  "*",
+			want:      []Change{External},
+			wantPaths: [][]uint32{{0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := create(t, tt.xPub, tt.template)
+
+			if info.ID == account.ID || info.PathTemplate != tt.template {
+				t.Fatalf("Create() got ID = %v, template = %q", info.ID, info.PathTemplate)
+			}
+
+			for i, change := range tt.want {
+				got := addresses(t, info, Change(i))
+				want := addresses(t, account, change)
+
+				for j := range got {
+					if got[j].Address != want[j].Address || got[j].Derivation != (DerivationPath{uint32(i), uint32(j)}) {
+						t.Fatalf("GetAllObservableAddresses() got = %v, want address %s",
+							got[j], want[j].Address)
+					}
+				}
+
+				if !reflect.DeepEqual(got[0].Path, tt.wantPaths[i]) {
+					t.Fatalf("GetAllObservableAddresses() got path = %v, want %v",
+						got[0].Path, tt.wantPaths[i])
+				}
+			}
+
+			if len(tt.want) == 2 {
+				return
+			}
+
+			if info.InternalDescriptor != "" || info.MultipathDescriptor != "" {
+				t.Fatalf("Create() got internal descriptors %q and %q",
+					info.InternalDescriptor, info.MultipathDescriptor)
+			}
+
+			_, err := keystore.GetFreshAddresses(ctx, info.ID, Internal, 1)
+			if errors.Cause(err) != ErrUnrecognizedChange {
+				t.Fatalf("GetFreshAddresses() error = %v, wantErr %v", err, ErrUnrecognizedChange)
+			}
+		})
+	}
+
+	// Cosigner chains of BIP45 are one level below the chains of other
+	// templates.
+	bip45 := create(t, mockXPub, "0/0/*,0/1/*")
+	chain := create(t, account.ExternalXPub, "")
+
+	if !strings.Contains(bip45.MultipathDescriptor, "/0/<0;1>/*") {
+		t.Fatalf("Create() got multipath descriptor = %s", bip45.MultipathDescriptor)
+	}
+
+	for _, change := range []Change{External, Internal} {
+		got, want := addresses(t, bip45, change), addresses(t, chain, change)
+
+		for j := range got {
+			if got[j].Address != want[j].Address {
+				t.Fatalf("GetAllObservableAddresses() got = %v, want = %v", got[j], want[j])
+			}
+		}
+	}
+}
@@ -8,8 +8,10 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/ledgerhq/bitcoin-keychain/metrics"
 	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
 	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
 )
 
 // WDKeystore implement the Keystore interface to write on "wallet daemon" redis
@@ -19,8 +21,9 @@ type WDKeystore struct {
 	baseRedisKeystore
 }
 
-func NewWDKeystore(redisOpts *redis.Options) (*WDKeystore, error) {
+func NewWDKeystore(redisOpts *redis.Options, client bitcoin.CoinServiceClient) (*WDKeystore, error) {
 	rdb := redis.NewClient(redisOpts)
+	rdb.AddHook(metrics.RedisHook{})
 
 	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
 		return nil, fmt.Errorf("Pinging redis failed: %w", err)
@@ -28,19 +31,72 @@ func NewWDKeystore(redisOpts *redis.Options) (*WDKeystore, error) {
 
 	baseKeystore := baseRedisKeystore{
 		db:     rdb,
-		client: bitcoin.NewBitcoinClient(),
+		client: client,
 	}
 
 	return &WDKeystore{baseKeystore}, nil
 }
 
-func (s *WDKeystore) Delete(id uuid.UUID) error {
-	redisContext := newRedisContext(s.db)
+// Create only supports the default path template, since wallet daemon
+// accounts always have an external and an internal chain.
+func (s *WDKeystore) Create(
+	ctx context.Context, extendedPublicKey string, fromChainCode *FromChainCode,
+	origin *KeyOrigin, template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	template, err := ParsePathTemplate(string(template))
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if template != "" {
+		return KeychainInfo{}, errors.Wrapf(ErrInvalidPathTemplate,
+			"%s is not supported by the wd store", template)
+	}
+
+	return s.baseRedisKeystore.Create(
+		ctx, extendedPublicKey, fromChainCode, origin, template, format, scheme, net,
+		lookaheadSize, index, metadata)
+}
+
+// CreateMultisig is not supported, since the wallet daemon has no multisig
+// wallet type.
+func (s *WDKeystore) CreateMultisig(
+	ctx context.Context, extendedPublicKeys []string, threshold uint32,
+	template PathTemplate, format AddressFormat, scheme Scheme,
+	net chaincfg.Network, lookaheadSize uint32, index uint32, metadata string,
+) (KeychainInfo, error) {
+	return KeychainInfo{}, errors.Wrapf(ErrUnrecognizedScheme,
+		"%s is not supported by the wd store", scheme)
+}
+
+// CreateFromDescriptor only supports single-key descriptors, see
+// CreateMultisig.
+func (s *WDKeystore) CreateFromDescriptor(
+	ctx context.Context, descriptor string, net chaincfg.Network, lookaheadSize uint32,
+	index uint32, metadata string,
+) (KeychainInfo, error) {
+	desc, err := ParseDescriptor(descriptor)
+	if err != nil {
+		return KeychainInfo{}, err
+	}
+
+	if desc.Scheme.IsMultisig() {
+		return KeychainInfo{}, errors.Wrapf(ErrUnrecognizedScheme,
+			"%s is not supported by the wd store", desc.Scheme)
+	}
+
+	return s.baseRedisKeystore.CreateFromDescriptor(
+		ctx, descriptor, net, lookaheadSize, index, metadata)
+}
+
+func (s *WDKeystore) Delete(ctx context.Context, id uuid.UUID) error {
+	redisContext := newRedisContext(ctx, s.db)
 
 	redisUpdate := func(tx *redis.Tx) error {
 		var meta Meta
 
-		err := get(s.db, id.String(), &meta)
+		err := get(ctx, tx, id.String(), &meta)
 
 		if err != nil {
 			return ErrKeychainNotFound
@@ -75,13 +131,13 @@ func (s *WDKeystore) Delete(id uuid.UUID) error {
 	return redisContext.watch(redisUpdate, id.String())
 }
 
-func (s *WDKeystore) Reset(id uuid.UUID) error {
-	redisContext := newRedisContext(s.db)
+func (s *WDKeystore) Reset(ctx context.Context, id uuid.UUID) error {
+	redisContext := newRedisContext(ctx, s.db)
 
 	redisUpdate := func(tx *redis.Tx) error {
 		var meta Meta
 
-		err := get(s.db, id.String(), &meta)
+		err := get(ctx, tx, id.String(), &meta)
 		if err != nil {
 			return ErrKeychainNotFound
 		}
@@ -118,27 +174,27 @@ func (s *WDKeystore) Reset(id uuid.UUID) error {
 	return redisContext.watch(redisUpdate, id.String())
 }
 
-func (s *WDKeystore) GetFreshAddress(id uuid.UUID, change Change) (*AddressInfo, error) {
-	addrs, err := s.GetFreshAddresses(id, change, 1)
+func (s *WDKeystore) GetFreshAddress(ctx context.Context, id uuid.UUID, change Change) (*AddressInfo, error) {
+	addrs, err := s.GetFreshAddresses(ctx, id, change, 1)
 	if err != nil {
 		return nil, err
 	}
 	return &addrs[0], err
 }
 
-func (s *WDKeystore) GetFreshAddresses(id uuid.UUID, change Change, size uint32) ([]AddressInfo, error) {
+func (s *WDKeystore) GetFreshAddresses(ctx context.Context, id uuid.UUID, change Change, size uint32) ([]AddressInfo, error) {
 	var res []AddressInfo
 
-	redisContext := newRedisContext(s.db)
+	redisContext := newRedisContext(ctx, s.db)
 
 	redisUpdate := func(tx *redis.Tx) error {
 		var meta Meta
-		err := get(s.db, id.String(), &meta)
+		err := get(ctx, tx, id.String(), &meta)
 		if err != nil {
 			return err
 		}
 
-		addrs, err := meta.keystoreGetFreshAddresses(s.client, change, size)
+		addrs, err := meta.keystoreGetFreshAddresses(ctx, s.client, change, size)
 		if err != nil {
 			return err
 		}
@@ -174,13 +230,13 @@ func (s *WDKeystore) GetFreshAddresses(id uuid.UUID, change Change, size uint32)
 	return res, nil
 }
 
-func (s *WDKeystore) MarkPathAsUsed(id uuid.UUID, path DerivationPath) error {
-	redisContext := newRedisContext(s.db)
+func (s *WDKeystore) MarkPathAsUsed(ctx context.Context, id uuid.UUID, path DerivationPath) error {
+	redisContext := newRedisContext(ctx, s.db)
 
 	redisUpdate := func(tx *redis.Tx) error {
 		var meta Meta
 
-		err := get(s.db, id.String(), &meta)
+		err := get(ctx, tx, id.String(), &meta)
 		if err != nil {
 			return ErrKeychainNotFound
 		}
@@ -209,22 +265,22 @@ func (s *WDKeystore) MarkPathAsUsed(id uuid.UUID, path DerivationPath) error {
 }
 
 func (s *WDKeystore) GetAllObservableAddresses(
-	id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
+	ctx context.Context, id uuid.UUID, change Change, fromIndex uint32, toIndex uint32,
 ) ([]AddressInfo, error) {
 	var res []AddressInfo
 
-	redisContext := newRedisContext(s.db)
+	redisContext := newRedisContext(ctx, s.db)
 
 	redisUpdate := func(tx *redis.Tx) error {
 		var meta Meta
 
-		err := get(s.db, id.String(), &meta)
+		err := get(ctx, tx, id.String(), &meta)
 		if err != nil {
 			return ErrKeychainNotFound
 		}
 
 		addrs, err := meta.keystoreGetAllObservableAddresses(
-			s.client, change, fromIndex, toIndex,
+			ctx, s.client, change, fromIndex, toIndex,
 		)
 		if err != nil {
 			return err
@@ -262,8 +318,118 @@ func (s *WDKeystore) GetAllObservableAddresses(
 	return res, nil
 }
 
-func (s *WDKeystore) MarkAddressAsUsed(id uuid.UUID, address string) error {
-	return keystoreMarkAddressAsUsed(s, id, address)
+func (s *WDKeystore) MarkAddressesAsUsed(
+	ctx context.Context, id uuid.UUID, addresses []string,
+) ([]MarkResult, error) {
+	var results []MarkResult
+
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		var meta Meta
+
+		err := get(ctx, tx, id.String(), &meta)
+		if err != nil {
+			return ErrKeychainNotFound
+		}
+
+		marks, discovered, err := meta.keystoreMarkAddressesAsUsed(
+			ctx, s.client, addresses, meta.Addresses, s.searchLimit,
+		)
+		if err != nil {
+			return err
+		}
+
+		redistx := newRedisTransaction(redisContext, tx)
+
+		err = redistx.set(id.String(), meta)
+		if err != nil {
+			return err
+		}
+
+		err = s.updateState(redistx, meta.Main)
+		if err != nil {
+			return err
+		}
+
+		if len(discovered) > 0 {
+			err = s.updateAddresses(redistx, meta.Main, discovered)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := redistx.exec(); err != nil {
+			return err
+		}
+
+		results = marks
+		return nil
+	}
+
+	err := redisContext.watch(redisUpdate, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *WDKeystore) DiscoverKeychain(
+	ctx context.Context, id uuid.UUID, oracle AddressUsageOracle,
+) ([]AddressInfo, error) {
+	var res []AddressInfo
+
+	redisContext := newRedisContext(ctx, s.db)
+
+	redisUpdate := func(tx *redis.Tx) error {
+		var meta Meta
+
+		err := get(ctx, tx, id.String(), &meta)
+		if err != nil {
+			return ErrKeychainNotFound
+		}
+
+		used, derived, err := meta.keystoreDiscover(ctx, s.client, oracle)
+		if err != nil {
+			return err
+		}
+
+		redistx := newRedisTransaction(redisContext, tx)
+
+		err = redistx.set(id.String(), meta)
+		if err != nil {
+			return err
+		}
+
+		err = s.updateState(redistx, meta.Main)
+		if err != nil {
+			return err
+		}
+
+		err = s.updateAddresses(redistx, meta.Main, derived)
+		if err != nil {
+			return err
+		}
+
+		if err := redistx.exec(); err != nil {
+			return err
+		}
+
+		res = used
+		return nil
+	}
+
+	err := redisContext.watch(redisUpdate, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s *WDKeystore) MarkAddressAsUsed(ctx context.Context, id uuid.UUID, address string) error {
+	return keystoreMarkAddressAsUsed(ctx, s, id, address)
 }
 
 func (s *WDKeystore) updateState(redistx *redisTransaction, keychainInfo KeychainInfo) error {
@@ -309,7 +475,12 @@ func (s *WDKeystore) updateAddresses(redistx *redisTransaction, keychainInfo Key
 		}
 	}
 
-	return nil
+	owners := make(map[string]DerivationPath, len(addrs))
+	for _, addr := range addrs {
+		owners[addr.Address] = addr.Derivation
+	}
+
+	return redistx.indexAddresses(keychainInfo.ID, keychainInfo.PathTemplate, owners)
 }
 
 func (s *WDKeystore) deleteAddresses(redistx *redisTransaction, keychainInfo KeychainInfo, addrs []AddressInfo) error {
@@ -329,7 +500,12 @@ func (s *WDKeystore) deleteAddresses(redistx *redisTransaction, keychainInfo Key
 		}
 	}
 
-	return nil
+	owners := make([]string, len(addrs))
+	for i, addr := range addrs {
+		owners[i] = addr.Address
+	}
+
+	return redistx.unindexAddresses(owners)
 }
 
 type WdKey struct {
@@ -424,6 +600,14 @@ func keychainInfoToWalletType(keychainInfo KeychainInfo) (string, error) {
 	switch keychainInfo.Network {
 	case chaincfg.LitecoinMainnet:
 		return "litecoin", nil
+	case chaincfg.LitecoinTestnet:
+		return "litecoin_testnet", nil
+	case chaincfg.LitecoinRegtest:
+		return "litecoin_regtest", nil
+	case chaincfg.BitcoinCashMainnet:
+		return "bitcoin_cash", nil
+	case chaincfg.BitcoinCashTestnet:
+		return "bitcoin_cash_testnet", nil
 	case chaincfg.BitcoinMainnet:
 		switch keychainInfo.Scheme {
 		case BIP44:
@@ -434,6 +618,8 @@ func keychainInfoToWalletType(keychainInfo KeychainInfo) (string, error) {
 			return "bitcoin_segwit", nil
 		case BIP84:
 			return "bitcoin_native_segwit", nil
+		case BIP86:
+			return "bitcoin_taproot", nil
 		}
 	case chaincfg.BitcoinTestnet3:
 		switch keychainInfo.Scheme {
@@ -443,6 +629,30 @@ func keychainInfoToWalletType(keychainInfo KeychainInfo) (string, error) {
 			return "bitcoin_testnet_segwit", nil
 		case BIP84:
 			return "bitcoin_testnet_native_segwit", nil
+		case BIP86:
+			return "bitcoin_testnet_taproot", nil
+		}
+	case chaincfg.BitcoinTestnet4:
+		switch keychainInfo.Scheme {
+		case BIP44:
+			return "bitcoin_testnet4", nil
+		case BIP49:
+			return "bitcoin_testnet4_segwit", nil
+		case BIP84:
+			return "bitcoin_testnet4_native_segwit", nil
+		case BIP86:
+			return "bitcoin_testnet4_taproot", nil
+		}
+	case chaincfg.BitcoinSignet:
+		switch keychainInfo.Scheme {
+		case BIP44:
+			return "bitcoin_signet", nil
+		case BIP49:
+			return "bitcoin_signet_segwit", nil
+		case BIP84:
+			return "bitcoin_signet_native_segwit", nil
+		case BIP86:
+			return "bitcoin_signet_taproot", nil
 		}
 	}
 
@@ -36,6 +36,21 @@ func TestWd_keychainInfoToWDKey(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			input: KeychainInfo{
+				Metadata:     "libcore_prefix:ledger1",
+				Scheme:       "BIP84",
+				Network:      "bitcoin_signet",
+				AccountIndex: 0,
+			},
+			want: WdKey{
+				Prefix:     "libcore_prefix",
+				Workspace:  "ledger1",
+				WalletType: "bitcoin_signet_native_segwit",
+				Index:      0,
+			},
+			err: nil,
+		},
 		{
 			input: KeychainInfo{
 				Metadata:     "libcore_prefix:ledger1",
@@ -0,0 +1,217 @@
+// Package native implements the bitcoin-lib-grpc CoinService in-process.
+//
+// It allows the keychain to derive extended keys and encode addresses without
+// any network round-trip, and without depending on another service.
+package native
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/address"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/chaincfg"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// accountDepth is the BIP32 depth of account-level extended keys, i.e.
+// m / purpose' / coin_type' / account'.
+const accountDepth = 3
+
+type coinServiceClient struct{}
+
+// NewCoinServiceClient returns a bitcoin.CoinServiceClient which serves every
+// request in-process, with the same results as bitcoin-lib-grpc.
+//
+// Requests are rejected once their context is done, so that derivations
+// stop with the request being served.
+func NewCoinServiceClient() bitcoin.CoinServiceClient {
+	return &coinServiceClient{}
+}
+
+func (c *coinServiceClient) ValidateAddress(
+	ctx context.Context,
+	in *bitcoin.ValidateAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.ValidateAddressResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	params, err := chainParams(in.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := address.Validate(in.Address, params)
+	if err != nil {
+		return &bitcoin.ValidateAddressResponse{
+			Address:       in.Address,
+			IsValid:       false,
+			InvalidReason: err.Error(),
+		}, nil
+	}
+
+	return &bitcoin.ValidateAddressResponse{
+		Address: addr,
+		IsValid: true,
+	}, nil
+}
+
+func (c *coinServiceClient) DeriveExtendedKey(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeyResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key, err := bip32.ParseExtendedKey(in.ExtendedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse extended key %s", in.ExtendedKey)
+	}
+
+	child, err := key.Derive(in.Derivation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to derive %s at %v",
+			in.ExtendedKey, in.Derivation)
+	}
+
+	return &bitcoin.DeriveExtendedKeyResponse{
+		ExtendedKey: child.String(),
+		PublicKey:   child.PublicKey.SerializeCompressed(),
+		ChainCode:   child.ChainCode,
+	}, nil
+}
+
+// DeriveExtendedKeys parses the extended key once, and derives every child
+// index from it.
+func (c *coinServiceClient) DeriveExtendedKeys(
+	ctx context.Context,
+	in *bitcoin.DeriveExtendedKeysRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.DeriveExtendedKeysResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key, err := bip32.ParseExtendedKey(in.ExtendedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse extended key %s", in.ExtendedKey)
+	}
+
+	resp := &bitcoin.DeriveExtendedKeysResponse{
+		Keys: make([]*bitcoin.DeriveExtendedKeyResponse, len(in.ChildIndexes)),
+	}
+
+	for i, index := range in.ChildIndexes {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to derive %s at %d",
+				in.ExtendedKey, index)
+		}
+
+		resp.Keys[i] = &bitcoin.DeriveExtendedKeyResponse{
+			ExtendedKey: child.String(),
+			PublicKey:   child.PublicKey.SerializeCompressed(),
+			ChainCode:   child.ChainCode,
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *coinServiceClient) EncodeAddress(
+	ctx context.Context,
+	in *bitcoin.EncodeAddressRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.EncodeAddressResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	params, err := chainParams(in.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr string
+
+	switch in.Encoding {
+	case bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH:
+		addr, err = address.EncodeP2PKH(in.PublicKey, params)
+	case bitcoin.AddressEncoding_ADDRESS_ENCODING_P2SH_P2WPKH:
+		addr, err = address.EncodeP2SHP2WPKH(in.PublicKey, params)
+	case bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH:
+		addr, err = address.EncodeP2WPKH(in.PublicKey, params)
+	default:
+		return nil, errors.Wrap(bitcoin.ErrUnrecognizedAddressEncoding,
+			fmt.Sprint(in.Encoding))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &bitcoin.EncodeAddressResponse{Address: addr}, nil
+}
+
+func (c *coinServiceClient) GetAccountExtendedKey(
+	ctx context.Context,
+	in *bitcoin.GetAccountExtendedKeyRequest,
+	opts ...grpc.CallOption,
+) (*bitcoin.GetAccountExtendedKeyResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	params, err := chainParams(in.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parent fingerprint is unknown, since only the account-level key
+	// material is provided.
+	key, err := bip32.NewExtendedKey(
+		params.HDPublicKeyID, in.PublicKey, in.ChainCode, [4]byte{},
+		accountDepth, bip32.HardenedKeyStart+in.AccountIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bitcoin.GetAccountExtendedKeyResponse{
+		ExtendedKey: key.String(),
+	}, nil
+}
+
+// chainParams is a helper to get the chaincfg.Params corresponding to the
+// chain params of a bitcoin-lib-grpc request.
+func chainParams(params *bitcoin.ChainParams) (*chaincfg.Params, error) {
+	var net chaincfg.Network
+
+	switch params.GetNetwork().(type) {
+	case *bitcoin.ChainParams_BitcoinNetwork:
+		switch params.GetBitcoinNetwork() {
+		case bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET:
+			net = chaincfg.BitcoinMainnet
+		case bitcoin.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3:
+			net = chaincfg.BitcoinTestnet3
+		case bitcoin.BitcoinNetwork_BITCOIN_NETWORK_REGTEST:
+			net = chaincfg.BitcoinRegtest
+		}
+	case *bitcoin.ChainParams_LitecoinNetwork:
+		switch params.GetLitecoinNetwork() {
+		case bitcoin.LitecoinNetwork_LITECOIN_NETWORK_MAINNET:
+			net = chaincfg.LitecoinMainnet
+		}
+	}
+
+	if net == "" {
+		return nil, errors.Wrap(bitcoin.ErrUnrecognizedNetwork, fmt.Sprint(params))
+	}
+
+	return chaincfg.GetParams(net)
+}
@@ -0,0 +1,269 @@
+//go:build !integration
+// +build !integration
+
+package native
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ledgerhq/bitcoin-keychain/pb/bitcoin"
+	"github.com/ledgerhq/bitcoin-keychain/pkg/bip32"
+	"github.com/pkg/errors"
+)
+
+func bitcoinParams(net bitcoin.BitcoinNetwork) *bitcoin.ChainParams {
+	return &bitcoin.ChainParams{
+		Network: &bitcoin.ChainParams_BitcoinNetwork{BitcoinNetwork: net},
+	}
+}
+
+func litecoinParams(net bitcoin.LitecoinNetwork) *bitcoin.ChainParams {
+	return &bitcoin.ChainParams{
+		Network: &bitcoin.ChainParams_LitecoinNetwork{LitecoinNetwork: net},
+	}
+}
+
+// The vectors below are the ones of the integration test suite, which were
+// produced by bitcoin-lib-grpc.
+func TestDeriveAndEncodeAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		extendedKey string
+		encoding    bitcoin.AddressEncoding
+		chainParams *bitcoin.ChainParams
+		derivation  []uint32
+		want        string
+	}{
+		{
+			name:        "bitcoin mainnet p2pkh receive",
+			extendedKey: "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET),
+			derivation:  []uint32{0, 0},
+			want:        "151krzHgfkNoH3XHBzEVi6tSn4db7pVjmR",
+		},
+		{
+			name:        "bitcoin mainnet p2pkh change",
+			extendedKey: "xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET),
+			derivation:  []uint32{1, 0},
+			want:        "13hSrTAvfRzyEcjRcGS5gLEcNVNDhPvvUv",
+		},
+		{
+			name:        "bitcoin testnet3 p2pkh receive",
+			extendedKey: "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3),
+			derivation:  []uint32{0, 0},
+			want:        "mkpZhYtJu2r87Js3pDiWJDmPte2NRZ8bJV",
+		},
+		{
+			name:        "bitcoin testnet3 p2pkh change",
+			extendedKey: "tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3),
+			derivation:  []uint32{1, 0},
+			want:        "mi8nhzZgGZQthq6DQHbru9crMDerUdTKva",
+		},
+		{
+			name:        "bitcoin testnet3 p2sh-p2wpkh receive",
+			extendedKey: "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2SH_P2WPKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3),
+			derivation:  []uint32{0, 0},
+			want:        "2MvuUMAG1NFQmmM69Writ6zTsYCnQHFG9BF",
+		},
+		{
+			name:        "bitcoin testnet3 p2sh-p2wpkh change",
+			extendedKey: "tpubDCcvqEHx7prGddpWTfEviiew5YLMrrKy4oJbt14teJZenSi6AYMAs2SNXwYXFzkrNYwECSmobwxESxMCrpfqw4gsUt88bcr8iMrJmbb8P2q",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2SH_P2WPKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_TESTNET3),
+			derivation:  []uint32{1, 0},
+			want:        "2MsMvWTbPMg4eiSudDa5i7y8XNC8fLCok3c",
+		},
+		{
+			name:        "bitcoin mainnet p2wpkh receive",
+			extendedKey: "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET),
+			derivation:  []uint32{0, 0},
+			want:        "bc1qh4kl0a0a3d7su8udc2rn62f8w939prqpl34z86",
+		},
+		{
+			name:        "bitcoin mainnet p2wpkh change",
+			extendedKey: "xpub6CMeLkY9TzXyLYXPWMXB5LWtprVABb6HwPEPXnEgESMNrSUBsvhXNsA7zKS1ZRKhUyQG4HjZysEP8v7gDNU4J6PvN5yLx4meEm3mpEapLMN",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH,
+			chainParams: bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET),
+			derivation:  []uint32{1, 0},
+			want:        "bc1qry3crfssh8w6guajms7upclgqsfac4fs4g7nwj",
+		},
+		{
+			name:        "litecoin mainnet p2wpkh receive",
+			extendedKey: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH,
+			chainParams: litecoinParams(bitcoin.LitecoinNetwork_LITECOIN_NETWORK_MAINNET),
+			derivation:  []uint32{0, 0},
+			want:        "ltc1q7qnj9xm8wp8ucmg64lk0h03as8k6ql6rk4wvsd",
+		},
+		{
+			name:        "litecoin mainnet p2wpkh change",
+			extendedKey: "Ltub2YC8XgcRjMJqvX8LsuBxdM7PKE5uih6247CpgK2rfEdzEGt1YHVHW4L865ss5eEy2K1KixTMkrHJbzTtqxpiGpM4wyrxYRFJFxuACSJqkyo",
+			encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2WPKH,
+			chainParams: litecoinParams(bitcoin.LitecoinNetwork_LITECOIN_NETWORK_MAINNET),
+			derivation:  []uint32{1, 0},
+			want:        "ltc1qx7mt6nztm8sm3dlj2lnw3vd4qg7jm2q6mvytsk",
+		},
+	}
+
+	client := NewCoinServiceClient()
+	ctx := context.Background()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child, err := client.DeriveExtendedKey(ctx, &bitcoin.DeriveExtendedKeyRequest{
+				ExtendedKey: tt.extendedKey,
+				Derivation:  tt.derivation,
+			})
+			if err != nil {
+				t.Fatalf("DeriveExtendedKey() unexpected error: %v", err)
+			}
+
+			addr, err := client.EncodeAddress(ctx, &bitcoin.EncodeAddressRequest{
+				PublicKey:   child.PublicKey,
+				Encoding:    tt.encoding,
+				ChainParams: tt.chainParams,
+			})
+			if err != nil {
+				t.Fatalf("EncodeAddress() unexpected error: %v", err)
+			}
+
+			if addr.Address != tt.want {
+				t.Fatalf("EncodeAddress() got = %v, want = %v", addr.Address, tt.want)
+			}
+
+			valid, err := client.ValidateAddress(ctx, &bitcoin.ValidateAddressRequest{
+				Address:     addr.Address,
+				ChainParams: tt.chainParams,
+			})
+			if err != nil {
+				t.Fatalf("ValidateAddress() unexpected error: %v", err)
+			}
+
+			if !valid.IsValid {
+				t.Fatalf("ValidateAddress() invalid address %s: %s",
+					addr.Address, valid.InvalidReason)
+			}
+		})
+	}
+}
+
+func TestDeriveExtendedKeys(t *testing.T) {
+	const xpub = "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"
+
+	client := NewCoinServiceClient()
+	ctx := context.Background()
+
+	indexes := []uint32{7, 0, 1}
+
+	resp, err := client.DeriveExtendedKeys(ctx, &bitcoin.DeriveExtendedKeysRequest{
+		ExtendedKey:  xpub,
+		ChildIndexes: indexes,
+	})
+	if err != nil {
+		t.Fatalf("DeriveExtendedKeys() unexpected error: %v", err)
+	}
+
+	if len(resp.Keys) != len(indexes) {
+		t.Fatalf("DeriveExtendedKeys() got %d keys, want %d", len(resp.Keys), len(indexes))
+	}
+
+	for i, index := range indexes {
+		want, err := client.DeriveExtendedKey(ctx, &bitcoin.DeriveExtendedKeyRequest{
+			ExtendedKey: xpub,
+			Derivation:  []uint32{index},
+		})
+		if err != nil {
+			t.Fatalf("DeriveExtendedKey() unexpected error: %v", err)
+		}
+
+		if !proto.Equal(resp.Keys[i], want) {
+			t.Fatalf("DeriveExtendedKeys() got = %v at index %d, want = %v",
+				resp.Keys[i], index, want)
+		}
+	}
+
+	_, err = client.DeriveExtendedKeys(ctx, &bitcoin.DeriveExtendedKeysRequest{
+		ExtendedKey:  xpub,
+		ChildIndexes: []uint32{0, bip32.HardenedKeyStart},
+	})
+	if errors.Cause(err) != bip32.ErrDeriveHardened {
+		t.Fatalf("DeriveExtendedKeys() error = %v, wantErr = %v",
+			err, bip32.ErrDeriveHardened)
+	}
+}
+
+func TestGetAccountExtendedKey(t *testing.T) {
+	key, err := bip32.ParseExtendedKey(
+		"xpub6DCi5iJ57ZPd5qPzvTm5hUt6X23TJdh9H4NjNsNbt7t7UuTMJfawQWsdWRFhfLwkiMkB1rQ4ZJWLB9YBnzR7kbs9N8b2PsKZgKUHQm1X4or")
+	if err != nil {
+		t.Fatalf("ParseExtendedKey() unexpected error: %v", err)
+	}
+
+	resp, err := NewCoinServiceClient().GetAccountExtendedKey(
+		context.Background(), &bitcoin.GetAccountExtendedKeyRequest{
+			PublicKey:    key.PublicKey.SerializeUncompressed(),
+			ChainCode:    key.ChainCode,
+			AccountIndex: 7,
+			ChainParams:  bitcoinParams(bitcoin.BitcoinNetwork_BITCOIN_NETWORK_MAINNET),
+		})
+	if err != nil {
+		t.Fatalf("GetAccountExtendedKey() unexpected error: %v", err)
+	}
+
+	got, err := bip32.ParseExtendedKey(resp.ExtendedKey)
+	if err != nil {
+		t.Fatalf("ParseExtendedKey() unexpected error: %v", err)
+	}
+
+	if got.Depth != 3 || got.ChildNumber != bip32.HardenedKeyStart+7 {
+		t.Fatalf("GetAccountExtendedKey() got depth = %d, child = %#x",
+			got.Depth, got.ChildNumber)
+	}
+
+	if !got.PublicKey.IsEqual(key.PublicKey) || string(got.ChainCode) != string(key.ChainCode) {
+		t.Fatalf("GetAccountExtendedKey() got = %v, want key material of %v",
+			got, key)
+	}
+}
+
+func TestUnrecognizedNetwork(t *testing.T) {
+	_, err := NewCoinServiceClient().EncodeAddress(
+		context.Background(), &bitcoin.EncodeAddressRequest{
+			Encoding:    bitcoin.AddressEncoding_ADDRESS_ENCODING_P2PKH,
+			ChainParams: &bitcoin.ChainParams{},
+		})
+
+	if errors.Cause(err) != bitcoin.ErrUnrecognizedNetwork {
+		t.Fatalf("EncodeAddress() error = %v, wantErr = %v",
+			err, bitcoin.ErrUnrecognizedNetwork)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewCoinServiceClient().DeriveExtendedKey(
+		ctx, &bitcoin.DeriveExtendedKeyRequest{
+			ExtendedKey: "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj",
+			Derivation:  []uint32{0},
+		})
+
+	if err != context.Canceled {
+		t.Fatalf("DeriveExtendedKey() error = %v, wantErr = %v",
+			err, context.Canceled)
+	}
+}
@@ -0,0 +1,131 @@
+// Package oracle implements the keystore.AddressUsageOracle interface, which
+// reports whether addresses have transaction history: an adapter to an HTTP
+// service, and an in-memory implementation for tests.
+package oracle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout bounds every request to the HTTP service, unless another
+// timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
+// ErrUnexpectedStatus indicates that the HTTP service did not answer a
+// request with 200 OK.
+var ErrUnexpectedStatus = errors.New("unexpected status of address usage oracle")
+
+// usageRequest and usageResponse are the JSON bodies exchanged with the HTTP
+// service.
+type usageRequest struct {
+	Addresses []string `json:"addresses"`
+}
+
+type usageResponse struct {
+	Used []bool `json:"used"`
+}
+
+// HTTP is an address usage oracle served by an HTTP service, such as a
+// blockchain explorer adapter.
+//
+// Addresses are sent with POST to the URL of the service, in a JSON object
+// {"addresses": [...]}. The service answers with a JSON object
+// {"used": [...]}, with one boolean per address in the same order.
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP returns an oracle querying the HTTP service at url. Every request
+// is bounded by timeout, or by DefaultTimeout if it is 0.
+func NewHTTP(url string, timeout time.Duration) *HTTP {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &HTTP{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// AddressesUsed queries the usage of addresses from the HTTP service.
+func (o *HTTP) AddressesUsed(ctx context.Context, addresses []string) ([]bool, error) {
+	body, err := json.Marshal(usageRequest{Addresses: addresses})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrap(ErrUnexpectedStatus, resp.Status)
+	}
+
+	var usage usageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, errors.Wrap(err, "failed to decode address usage")
+	}
+
+	return usage.Used, nil
+}
+
+// Memory is an address usage oracle backed by a set of used addresses. It
+// is meant for tests.
+type Memory struct {
+	mu   sync.RWMutex
+	used map[string]bool
+}
+
+// NewMemory returns an oracle reporting the given addresses as used.
+func NewMemory(used ...string) *Memory {
+	o := &Memory{used: make(map[string]bool, len(used))}
+	o.Use(used...)
+
+	return o
+}
+
+// Use reports addresses as used from now on.
+func (o *Memory) Use(addresses ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, address := range addresses {
+		o.used[address] = true
+	}
+}
+
+// AddressesUsed returns whether every address was reported as used by Use.
+func (o *Memory) AddressesUsed(ctx context.Context, addresses []string) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	usage := make([]bool, len(addresses))
+	for i, address := range addresses {
+		usage[i] = o.used[address]
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,81 @@
+//go:build !integration
+// +build !integration
+
+package oracle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestHTTP_AddressesUsed(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    []bool
+		wantErr error
+	}{
+		{
+			name: "usage of every address",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req usageRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				used := make([]bool, len(req.Addresses))
+				for i, address := range req.Addresses {
+					used[i] = address == "used"
+				}
+
+				_ = json.NewEncoder(w).Encode(usageResponse{Used: used})
+			},
+			want: []bool{true, false},
+		},
+		{
+			name: "service error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "explorer unavailable", http.StatusServiceUnavailable)
+			},
+			wantErr: ErrUnexpectedStatus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			got, err := NewHTTP(server.URL, 0).AddressesUsed(
+				context.Background(), []string{"used", "unused"})
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("AddressesUsed() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("AddressesUsed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemory_AddressesUsed(t *testing.T) {
+	o := NewMemory("a")
+	o.Use("c")
+
+	got, err := o.AddressesUsed(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("AddressesUsed() unexpected error: %v", err)
+	}
+
+	if want := []bool{true, false, true}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AddressesUsed() = %v, want %v", got, want)
+	}
+}